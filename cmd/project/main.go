@@ -4,14 +4,19 @@ import (
 	"context"
 	"log"
 	"net/http"
-	"os"
+	"tefsi/internal/config"
 	"tefsi/internal/inits"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 func main() {
-	db, err := pgxpool.Connect(context.Background(), os.Getenv("DATABASE_URL"))
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := pgxpool.Connect(context.Background(), cfg.DatabaseURL)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -24,16 +29,38 @@ func main() {
 	}
 	log.Println("got all tables")
 
-	repos, err := inits.InitRepositories(db, allTables)
+	repos, err := inits.InitRepositories(db, allTables, cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	services := inits.InitServices(repos)
-	handlers := inits.InitHandlers(services)
+	services, err := inits.InitServices(repos, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = inits.InitAdmin(services, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	auth, err := inits.InitAuth(services, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	limiter, err := inits.InitLimiter(repos, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inits.StartJobs(services, limiter, cfg)
+
+	handlers := inits.InitHandlers(services, auth, limiter, cfg)
+	mw := inits.InitMiddleware(auth)
 
-	r := inits.InitRouter(handlers)
+	r := inits.InitRouter(handlers, mw)
 
-	log.Println("Starting server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Printf("Starting server on %s", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, r))
 }
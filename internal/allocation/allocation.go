@@ -0,0 +1,138 @@
+package allocation
+
+import (
+	"slices"
+
+	"tefsi/internal/domain"
+)
+
+// Allocate picks the warehouses that ship the lines of an order.
+// If one warehouse has everything the whole order ships from it, otherwise each line
+// comes from the warehouses already shipping other lines where possible, then from the rest
+// by priority, split between several of them if no single one has enough.
+// Lines of items whose stock isn't tracked get no allocation, lines that can't be filled
+// are returned as shortages instead. Stock of inactive warehouses isn't used
+func Allocate(
+	lines []domain.ItemWithAmount, warehouses []domain.Warehouse, stock []domain.StockLevel,
+) ([]domain.Allocation, []domain.StockShortage) {
+	preferred := []domain.Warehouse{}
+	for _, warehouse := range warehouses {
+		if warehouse.Active {
+			preferred = append(preferred, warehouse)
+		}
+	}
+	slices.SortFunc(preferred, func(a, b domain.Warehouse) int {
+		if a.Priority != b.Priority {
+			return b.Priority - a.Priority
+		}
+		return a.ID - b.ID
+	})
+
+	available := map[stockKey]int{}
+	tracked := map[lineKey]bool{}
+	for _, level := range stock {
+		tracked[lineKey{level.ItemID, level.VariantID}] = true
+		available[stockKey{level.WarehouseID, lineKey{level.ItemID, level.VariantID}}] += level.Quantity
+	}
+
+	// lines of the same item and variant are shipped together
+	trackedLines := []domain.ItemWithAmount{}
+	for _, line := range lines {
+		if line.Amount <= 0 || !tracked[lineKey{line.ItemID, line.VariantID}] {
+			continue
+		}
+		i := slices.IndexFunc(trackedLines, func(other domain.ItemWithAmount) bool {
+			return other.ItemID == line.ItemID && other.VariantID == line.VariantID
+		})
+		if i == -1 {
+			trackedLines = append(trackedLines, line)
+		} else {
+			trackedLines[i].Amount += line.Amount
+		}
+	}
+
+	for _, warehouse := range preferred {
+		if hasAll(warehouse.ID, trackedLines, available) {
+			allocations := []domain.Allocation{}
+			for _, line := range trackedLines {
+				allocations = append(allocations, domain.Allocation{
+					WarehouseID: warehouse.ID, ItemID: line.ItemID, VariantID: line.VariantID, Amount: line.Amount,
+				})
+			}
+			return allocations, nil
+		}
+	}
+
+	allocations := []domain.Allocation{}
+	shortages := []domain.StockShortage{}
+	used := []domain.Warehouse{}
+	for _, line := range trackedLines {
+		key := lineKey{line.ItemID, line.VariantID}
+
+		// shipping the line with others is better than opening another parcel
+		i := slices.IndexFunc(used, func(warehouse domain.Warehouse) bool {
+			return available[stockKey{warehouse.ID, key}] >= line.Amount
+		})
+		if i != -1 {
+			available[stockKey{used[i].ID, key}] -= line.Amount
+			allocations = append(allocations, domain.Allocation{
+				WarehouseID: used[i].ID, ItemID: line.ItemID, VariantID: line.VariantID, Amount: line.Amount,
+			})
+			continue
+		}
+
+		total := 0
+		for _, warehouse := range preferred {
+			total += available[stockKey{warehouse.ID, key}]
+		}
+		if total < line.Amount {
+			shortages = append(shortages, domain.StockShortage{
+				ItemID: line.ItemID, VariantID: line.VariantID, Requested: line.Amount, Available: total,
+			})
+			continue
+		}
+
+		remaining := line.Amount
+		for _, warehouse := range preferred {
+			amount := min(remaining, available[stockKey{warehouse.ID, key}])
+			if amount == 0 {
+				continue
+			}
+			available[stockKey{warehouse.ID, key}] -= amount
+			remaining -= amount
+			allocations = append(allocations, domain.Allocation{
+				WarehouseID: warehouse.ID, ItemID: line.ItemID, VariantID: line.VariantID, Amount: amount,
+			})
+			if !slices.Contains(used, warehouse) {
+				used = append(used, warehouse)
+			}
+			if remaining == 0 {
+				break
+			}
+		}
+	}
+
+	if len(shortages) > 0 {
+		return nil, shortages
+	}
+	return allocations, nil
+}
+
+type lineKey struct {
+	itemID    int
+	variantID int
+}
+
+type stockKey struct {
+	warehouseID int
+	line        lineKey
+}
+
+func hasAll(warehouseID int, lines []domain.ItemWithAmount, available map[stockKey]int) bool {
+	for _, line := range lines {
+		if available[stockKey{warehouseID, lineKey{line.ItemID, line.VariantID}}] < line.Amount {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"time"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
+)
+
+// authenticates a request by its X-API-Key header or, without one, by its bearer token
+func (a *Auth) Authenticate(r *http.Request) (*domain.User, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.GetUserFromAPIKey(r.Context(), key)
+	}
+	return a.GetUserFromJWT(r.Header.Get("Authorization"))
+}
+
+// returns a user standing in for the api key, it has no id or roles
+// and exactly the permissions in the key's scopes
+func (a *Auth) GetUserFromAPIKey(ctx context.Context, key string) (*domain.User, error) {
+	prefix, ok := tokens.APIKeyPrefix(key)
+	if !ok {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	apiKey, err := a.service.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, domain.ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(tokens.Hash(key)), []byte(apiKey.SecretHash)) != 1 {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt)) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+
+	err = a.service.TouchAPIKey(ctx, apiKey.ID)
+	if err != nil {
+		log.Printf("couldn't update last use of api key %d: %s", apiKey.ID, err.Error())
+	}
+
+	return &domain.User{
+		Login:       "api-key:" + apiKey.Prefix,
+		Roles:       []string{},
+		Permissions: apiKey.Scopes,
+	}, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"tefsi/internal/domain"
+)
+
+// audience of guest cart tokens, they identify a cart and nothing else
+const cartAudience = "cart"
+
+// signs a token for the guest cart, it expires together with the cart
+func (a *Auth) IssueCartToken(cart *domain.GuestCart) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Issuer:    a.cfg.Issuer,
+		Subject:   cart.ID,
+		Audience:  jwt.ClaimStrings{cartAudience},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(cart.ExpiresAt),
+	}
+	return a.keys.Sign(claims)
+}
+
+// returns the id of the guest cart the token was issued for
+func (a *Auth) ParseCartToken(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := a.cartParser.ParseWithClaims(token, claims, a.keys.Keyfunc)
+	if err != nil || claims.Subject == "" {
+		return "", domain.ErrInvalidCartToken
+	}
+	return claims.Subject, nil
+}
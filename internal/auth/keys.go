@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+)
+
+type key struct {
+	id         string
+	method     jwt.SigningMethod
+	signKey    any
+	verifyKey  any
+	verifyOnly bool
+}
+
+// KeyManager signs tokens with the current signing key and verifies them
+// with any configured key, so tokens signed before a key rotation stay valid
+// for as long as the old key is kept around as verify_only
+type KeyManager struct {
+	keys    map[string]*key
+	order   []string
+	signing *key
+}
+
+func NewKeyManager(cfg config.JWTConfig) (*KeyManager, error) {
+	m := &KeyManager{keys: make(map[string]*key)}
+
+	for _, keyCfg := range cfg.Keys {
+		k, err := parseKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key '%s': %w", keyCfg.ID, err)
+		}
+		if _, ok := m.keys[k.id]; ok {
+			return nil, fmt.Errorf("duplicate jwt key id '%s'", k.id)
+		}
+		m.keys[k.id] = k
+		m.order = append(m.order, k.id)
+
+		if m.signing == nil && !k.verifyOnly && (cfg.SigningKeyID == "" || cfg.SigningKeyID == k.id) {
+			m.signing = k
+		}
+	}
+
+	if m.signing == nil {
+		return nil, fmt.Errorf("no active jwt signing key configured")
+	}
+	return m, nil
+}
+
+func parseKey(cfg config.JWTKey) (*key, error) {
+	if cfg.ID == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+	method := jwt.GetSigningMethod(cfg.Algorithm)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("unsupported algorithm '%s'", cfg.Algorithm)
+	}
+
+	k := &key{id: cfg.ID, method: method, verifyOnly: cfg.VerifyOnly}
+
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(cfg.Secret) < 32 {
+			return nil, fmt.Errorf("hmac secret must be at least 32 bytes long")
+		}
+		k.signKey = []byte(cfg.Secret)
+		k.verifyKey = []byte(cfg.Secret)
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		k.signKey = private
+		k.verifyKey = &private.PublicKey
+	case *jwt.SigningMethodECDSA:
+		private, err := jwt.ParseECPrivateKeyFromPEM([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		if private.Curve.Params().BitSize != method.(*jwt.SigningMethodECDSA).CurveBits {
+			return nil, fmt.Errorf("curve %s can't be used with %s", private.Curve.Params().Name, cfg.Algorithm)
+		}
+		k.signKey = private
+		k.verifyKey = &private.PublicKey
+	case *jwt.SigningMethodEd25519:
+		private, err := jwt.ParseEdPrivateKeyFromPEM([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an ed25519 private key")
+		}
+		k.signKey = signer
+		k.verifyKey = signer.Public()
+	default:
+		return nil, fmt.Errorf("unsupported algorithm '%s'", cfg.Algorithm)
+	}
+
+	return k, nil
+}
+
+// signs claims with the current signing key, stamping its kid into the header
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.signing.method, claims)
+	token.Header["kid"] = m.signing.id
+	return token.SignedString(m.signing.signKey)
+}
+
+// jwt.Keyfunc that picks the verification key by the kid header,
+// tokens without a kid are checked against every key with a matching algorithm
+func (m *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		set := jwt.VerificationKeySet{}
+		for _, id := range m.order {
+			if m.keys[id].method.Alg() == token.Method.Alg() {
+				set.Keys = append(set.Keys, m.keys[id].verifyKey)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("no key for algorithm %s", token.Method.Alg())
+		}
+		return set, nil
+	}
+
+	k, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+	if k.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("key '%s' can't be used with %s", kid, token.Method.Alg())
+	}
+	return k.verifyKey, nil
+}
+
+// algorithms of all configured keys, to be passed to jwt.WithValidMethods
+func (m *KeyManager) Algorithms() []string {
+	algs := []string{}
+	seen := make(map[string]struct{})
+	for _, id := range m.order {
+		alg := m.keys[id].method.Alg()
+		if _, ok := seen[alg]; !ok {
+			seen[alg] = struct{}{}
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// public keys of all asymmetric keys, hmac secrets are never published
+func (m *KeyManager) JWKS() domain.JWKS {
+	jwks := domain.JWKS{Keys: []domain.JWK{}}
+	for _, id := range m.order {
+		k := m.keys[id]
+		jwk, ok := publicJWK(k.verifyKey)
+		if !ok {
+			continue
+		}
+		jwk.KeyID = k.id
+		jwk.Algorithm = k.method.Alg()
+		jwk.Use = "sig"
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func publicJWK(public crypto.PublicKey) (domain.JWK, bool) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return domain.JWK{
+			KeyType: "RSA",
+			N:       encode(public.N.Bytes()),
+			E:       encode(big.NewInt(int64(public.E)).Bytes()),
+		}, true
+	case *ecdsa.PublicKey:
+		ecdhKey, err := public.ECDH()
+		if err != nil {
+			return domain.JWK{}, false
+		}
+		// uncompressed point: 0x04 || x || y
+		point := ecdhKey.Bytes()
+		size := (len(point) - 1) / 2
+		return domain.JWK{
+			KeyType: "EC",
+			Curve:   curveName(public.Curve),
+			X:       encode(point[1 : 1+size]),
+			Y:       encode(point[1+size:]),
+		}, true
+	case ed25519.PublicKey:
+		return domain.JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       encode(public),
+		}, true
+	}
+	return domain.JWK{}, false
+}
+
+func curveName(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "P-256"
+	case elliptic.P384():
+		return "P-384"
+	case elliptic.P521():
+		return "P-521"
+	}
+	return curve.Params().Name
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
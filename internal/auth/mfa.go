@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"tefsi/internal/domain"
+)
+
+// audience of mfa challenge tokens, access tokens have none
+// so the two can't be used in place of each other
+const mfaAudience = "mfa"
+
+// issues the short lived token a user with mfa exchanges for tokens together with a code
+func (a *Auth) IssueMFAChallenge(user *domain.User) (*domain.MFAChallenge, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Issuer:    a.cfg.Issuer,
+		Subject:   user.Login,
+		Audience:  jwt.ClaimStrings{mfaAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(a.mfa.ChallengeTTL)),
+	}
+	token, err := a.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(a.mfa.ChallengeTTL.Seconds()),
+	}, nil
+}
+
+// returns the login the challenge token was issued for
+func (a *Auth) ParseMFAChallenge(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := a.mfaParser.ParseWithClaims(token, claims, a.keys.Keyfunc)
+	if err != nil {
+		return "", domain.ErrInvalidMFAToken
+	}
+	return claims.Subject, nil
+}
+
+// admins who haven't set up mfa while it is required keep their roles
+// but get no permissions, they can still use their own account and enroll
+//
+// every permission opens some part of the admin api, so anyone holding one counts as an admin
+// whether it comes from the admin role or a custom one
+func (a *Auth) applyMFAPolicy(user *domain.User) {
+	if a.mfa.RequireForAdmins && len(user.Permissions) > 0 && !user.MFAEnabled {
+		user.Permissions = nil
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"log"
+
+	"tefsi/internal/domain"
+)
+
+// rejects tokens of revoked sessions, tokens issued before sessions were tracked have no sid
+func (a *Auth) checkSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	session, err := a.service.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return domain.ErrSessionRevoked
+	}
+
+	err = a.service.TouchSession(ctx, sessionID, nil)
+	if err != nil {
+		log.Printf("couldn't update last use of session %s: %s", sessionID, err.Error())
+	}
+	return nil
+}
+
+func (a *Auth) GetSessions(ctx context.Context, userID int) (*[]domain.Session, error) {
+	return a.service.GetUserSessions(ctx, userID)
+}
+
+// signs the user out on one device, access tokens of the session stop working right away
+func (a *Auth) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	session, err := a.service.GetSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != userID {
+		return domain.ErrSessionNotFound
+	}
+	return a.service.RevokeTokenFamily(ctx, sessionID)
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
+)
+
+// issues an access token and a refresh token starting a new token family and session
+func (a *Auth) IssueTokens(ctx context.Context, user *domain.User, info *domain.SessionInfo) (*domain.LoginResponse, error) {
+	session := &domain.Session{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		UserAgent: info.UserAgent,
+		IP:        info.IP,
+		ExpiresAt: time.Now().Add(a.cfg.RefreshTokenTTL),
+	}
+	err := a.service.CreateSession(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, user, session.ID)
+}
+
+func (a *Auth) issueTokens(ctx context.Context, user *domain.User, familyID string) (*domain.LoginResponse, error) {
+	a.applyMFAPolicy(user)
+	now := time.Now()
+	claims := accessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    a.cfg.Issuer,
+			Subject:   user.Login,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.cfg.AccessTokenTTL)),
+		},
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		SessionID:   familyID,
+	}
+	accessToken, err := a.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := tokens.New()
+	if err != nil {
+		return nil, err
+	}
+	err = a.service.CreateRefreshToken(ctx, &domain.RefreshToken{
+		Hash:      tokens.Hash(refreshToken),
+		FamilyID:  familyID,
+		UserID:    user.ID,
+		ExpiresAt: now.Add(a.cfg.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(a.cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// exchanges a refresh token for a new pair of tokens
+//
+// every refresh token can only be used once, presenting a used token means it was stolen
+// (either the thief or the real user already used it) so the whole family is revoked
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (*domain.LoginResponse, error) {
+	hash := tokens.Hash(refreshToken)
+	token, err := a.service.GetRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.UsedAt != nil || token.RevokedAt != nil {
+		return nil, a.revokeReusedFamily(ctx, token)
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+
+	ok, err := a.service.MarkRefreshTokenUsed(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, a.revokeReusedFamily(ctx, token)
+	}
+
+	user, err := a.service.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	err = user.CanLogIn()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(a.cfg.RefreshTokenTTL)
+	err = a.service.TouchSession(ctx, token.FamilyID, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return a.issueTokens(ctx, user, token.FamilyID)
+}
+
+func (a *Auth) revokeReusedFamily(ctx context.Context, token *domain.RefreshToken) error {
+	log.Printf("refresh token reuse detected for user %d, revoking token family %s", token.UserID, token.FamilyID)
+	err := a.service.RevokeTokenFamily(ctx, token.FamilyID)
+	if err != nil {
+		return err
+	}
+	return domain.ErrRefreshTokenReused
+}
+
+// revokes the access token from the authorization header
+// and the refresh token family if a refresh token is given
+func (a *Auth) Logout(ctx context.Context, header string, refreshToken string) error {
+	claims, err := a.parseHeader(ctx, header)
+	if err != nil {
+		return err
+	}
+
+	err = a.service.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+	if claims.SessionID != "" {
+		err = a.service.RevokeTokenFamily(ctx, claims.SessionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	token, err := a.service.GetRefreshToken(ctx, tokens.Hash(refreshToken))
+	if err != nil {
+		return err
+	}
+
+	user, err := a.service.GetUserByLogin(ctx, claims.Subject)
+	if err != nil {
+		return err
+	}
+	if token.UserID != user.ID {
+		return domain.ErrInvalidRefreshToken
+	}
+
+	return a.service.RevokeTokenFamily(ctx, token.FamilyID)
+}
+
+// logs the user out of every session, used when an admin locks the account
+func (a *Auth) RevokeUserTokens(ctx context.Context, userID int) error {
+	return a.service.RevokeUserTokens(ctx, userID)
+}
@@ -3,60 +3,136 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
+	"tefsi/internal/config"
 	"tefsi/internal/domain"
 )
 
 type AuthService interface {
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	GetUserByID(ctx context.Context, id int) (*domain.User, error)
+	CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID int) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error)
+	TouchAPIKey(ctx context.Context, id int) error
+	CreateSession(ctx context.Context, session *domain.Session) error
+	GetSession(ctx context.Context, id string) (*domain.Session, error)
+	GetUserSessions(ctx context.Context, userID int) (*[]domain.Session, error)
+	TouchSession(ctx context.Context, id string, expiresAt *time.Time) error
+}
+
+// roles and permissions are put into access tokens for other services verifying them with the jwks,
+// tefsi itself resolves them from the database on every request so they can be changed without a new login
+type accessClaims struct {
+	jwt.RegisteredClaims
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
+	// id of the session, the same as the refresh token family
+	SessionID string `json:"sid,omitempty"`
 }
 
 type Auth struct {
-	service AuthService
+	service    AuthService
+	keys       *KeyManager
+	cfg        config.JWTConfig
+	mfa        config.MFAConfig
+	parser     *jwt.Parser
+	mfaParser  *jwt.Parser
+	cartParser *jwt.Parser
 }
 
-func NewAuth(service AuthService) *Auth {
-	return &Auth{service: service}
+func NewAuth(service AuthService, keys *KeyManager, cfg config.JWTConfig, mfa config.MFAConfig) *Auth {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(keys.Algorithms()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	mfaParser := jwt.NewParser(
+		jwt.WithValidMethods(keys.Algorithms()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(mfaAudience),
+		jwt.WithExpirationRequired(),
+	)
+	cartParser := jwt.NewParser(
+		jwt.WithValidMethods(keys.Algorithms()),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cartAudience),
+		jwt.WithExpirationRequired(),
+	)
+	return &Auth{
+		service: service, keys: keys, cfg: cfg, mfa: mfa,
+		parser: parser, mfaParser: mfaParser, cartParser: cartParser,
+	}
 }
 
 func (a *Auth) GetUserFromJWT(header string) (*domain.User, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("invalid header: %s", header)
-		}
-	}()
+	ctx := context.Background()
+
+	claims, err := a.parseHeader(ctx, header)
+	if err != nil {
+		return nil, err
+	}
+	err = a.checkSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.service.GetUserByLogin(ctx, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	err = user.CanLogIn()
+	if err != nil {
+		return nil, err
+	}
+	a.applyMFAPolicy(user)
+	return user, nil
+}
 
+// parses and validates the bearer token from the authorization header,
+// including the revocation list check
+func (a *Auth) parseHeader(ctx context.Context, header string) (*accessClaims, error) {
 	if header == "" {
 		return nil, fmt.Errorf("no header provided")
 	}
-	t := strings.Split(header, " ")[1]
-	log.Printf("got token %s", t)
-
-	token, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte("some_secret"), nil
-	})
+	t, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || t == "" {
+		return nil, fmt.Errorf("invalid authorization header")
+	}
 
-	log.Printf("token parsed")
+	claims := &accessClaims{}
+	_, err := a.parser.ParseWithClaims(t, claims, a.keys.Keyfunc)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("loading payload")
-	payload, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid token: %v", token)
+
+	if claims.ID == "" {
+		return nil, fmt.Errorf("token has no jti")
+	}
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("not an access token")
 	}
-	log.Printf("payload loaded")
-	login := payload["sub"].(string)
-	user, err := a.service.GetUserByLogin(context.Background(), login)
+	revoked, err := a.service.IsAccessTokenRevoked(ctx, claims.ID)
 	if err != nil {
 		return nil, err
 	}
-	return user, nil
+	if revoked {
+		return nil, domain.ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// public keys for other services to verify our tokens with
+func (a *Auth) JWKS() domain.JWKS {
+	return a.keys.JWKS()
 }
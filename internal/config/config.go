@@ -0,0 +1,412 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	DatabaseURL string
+	Addr        string
+	JWT         JWTConfig
+	// first admin, created on startup if there are no admins yet
+	AdminLogin    string
+	AdminPassword string
+	// base url of the storefront, used for links in mails
+	PublicURL        string
+	PasswordResetTTL time.Duration
+	// lifetime of the link sent to confirm an email address
+	EmailVerificationTTL time.Duration
+	// guest carts that aren't changed for this long are deleted
+	GuestCartTTL time.Duration
+	// how often the expired guest carts are deleted
+	GuestCartCleanupInterval time.Duration
+	// unverified users can't place orders if set
+	RequireVerifiedEmailForOrders bool
+	Mail                          MailConfig
+	MFA                           MFAConfig
+	// external identity providers users can log in with
+	OIDC          []OIDCProvider
+	LoginThrottle LoginThrottleConfig
+	Password      PasswordConfig
+	// postgres text search configuration used for item search, like russian or english,
+	// russian also stems english words
+	SearchLanguage string
+	// latency budget of search suggestions, slower ones are dropped
+	SuggestTimeout time.Duration
+}
+
+// new passwords are hashed with Algorithm, older hashes are replaced on the next login
+type PasswordConfig struct {
+	// argon2id or bcrypt
+	Algorithm  string
+	BcryptCost int
+	// in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// failed logins are tracked per login and per client ip, after FreeAttempts failures
+// every attempt for the login has to wait twice as long as the one before, up to MaxDelay,
+// after MaxFailures (IPMaxFailures for ips) the login or ip is locked for LockoutDuration
+type LoginThrottleConfig struct {
+	// postgres or memory
+	Store           string
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	MaxFailures     int
+	IPMaxFailures   int
+	LockoutDuration time.Duration
+	// failures older than this are forgotten
+	Window time.Duration
+	// how often the forgotten failures are deleted
+	CleanupInterval time.Duration
+}
+
+// one OpenID Connect provider, loaded from the json file in OIDC_PROVIDERS_FILE
+type OIDCProvider struct {
+	// used in the login and callback urls, /users/oidc/{name}/login
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	RedirectURL  string   `json:"redirect_url,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+	// link logins with a verified email to the existing user with that email,
+	// only for providers trusted to verify the addresses they vouch for
+	LinkByEmail bool `json:"link_by_email,omitempty"`
+}
+
+type MFAConfig struct {
+	// shown in authenticator apps
+	Issuer string
+	// users with any permission and no mfa keep their roles but get no permissions until they enroll
+	RequireForAdmins bool
+	// lifetime of the token exchanged for tokens after the second step of the login
+	ChallengeTTL time.Duration
+}
+
+type MailConfig struct {
+	// smtp, file or memory, has to be set explicitly so mail is never written to disk by accident
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// directory for the file driver
+	Dir string
+}
+
+type JWTConfig struct {
+	Issuer string
+	// kid of the key new tokens are signed with, the first active key is used if empty
+	SigningKeyID    string
+	Keys            []JWTKey
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// one signing key, loaded from the json file in JWT_KEYS_FILE
+//
+// HMAC keys (HS256, HS384, HS512) use Secret,
+// RSA, ECDSA and EdDSA keys use a PEM encoded private key from PrivateKey or PrivateKeyFile
+type JWTKey struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKey     string `json:"private_key,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	// retired keys are only used to verify tokens issued before the rotation
+	VerifyOnly bool `json:"verify_only,omitempty"`
+}
+
+// loads config from the environment
+func Load() (*Config, error) {
+	accessTokenTTL, err := getDuration("JWT_ACCESS_TTL", 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	refreshTokenTTL, err := getDuration("JWT_REFRESH_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordResetTTL, err := getDuration("PASSWORD_RESET_TTL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	emailVerificationTTL, err := getDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	guestCartTTL, err := getDuration("GUEST_CART_TTL", 30*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	guestCartCleanupInterval, err := getDuration("GUEST_CART_CLEANUP_INTERVAL", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if guestCartCleanupInterval <= 0 {
+		return nil, fmt.Errorf("GUEST_CART_CLEANUP_INTERVAL has to be positive")
+	}
+	suggestTimeout, err := getDuration("SUGGEST_TIMEOUT", 150*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	requireVerifiedEmail, err := getBool("ORDERS_REQUIRE_VERIFIED_EMAIL", false)
+	if err != nil {
+		return nil, err
+	}
+	mfaChallengeTTL, err := getDuration("MFA_CHALLENGE_TTL", 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	requireAdminMFA, err := getBool("REQUIRE_ADMIN_MFA", false)
+	if err != nil {
+		return nil, err
+	}
+	loginThrottle, err := loadLoginThrottle()
+	if err != nil {
+		return nil, err
+	}
+	passwordCfg, err := loadPassword()
+	if err != nil {
+		return nil, err
+	}
+	smtpPort, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+	}
+
+	cfg := &Config{
+		DatabaseURL:                   os.Getenv("DATABASE_URL"),
+		Addr:                          getEnv("ADDR", ":8080"),
+		AdminLogin:                    os.Getenv("ADMIN_LOGIN"),
+		AdminPassword:                 os.Getenv("ADMIN_PASSWORD"),
+		PublicURL:                     strings.TrimSuffix(getEnv("PUBLIC_URL", "http://localhost:8080"), "/"),
+		PasswordResetTTL:              passwordResetTTL,
+		EmailVerificationTTL:          emailVerificationTTL,
+		GuestCartTTL:                  guestCartTTL,
+		GuestCartCleanupInterval:      guestCartCleanupInterval,
+		SearchLanguage:                getEnv("SEARCH_LANGUAGE", "russian"),
+		SuggestTimeout:                suggestTimeout,
+		RequireVerifiedEmailForOrders: requireVerifiedEmail,
+		Mail: MailConfig{
+			Driver:       os.Getenv("MAIL_DRIVER"),
+			From:         getEnv("MAIL_FROM", "tefsi <noreply@localhost>"),
+			SMTPHost:     os.Getenv("SMTP_HOST"),
+			SMTPPort:     smtpPort,
+			SMTPUsername: os.Getenv("SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			Dir:          getEnv("MAIL_DIR", filepath.Join(os.TempDir(), "tefsi-mail")),
+		},
+		LoginThrottle: *loginThrottle,
+		Password:      *passwordCfg,
+		MFA: MFAConfig{
+			Issuer:           getEnv("MFA_ISSUER", "tefsi"),
+			RequireForAdmins: requireAdminMFA,
+			ChallengeTTL:     mfaChallengeTTL,
+		},
+		JWT: JWTConfig{
+			Issuer:          getEnv("JWT_ISSUER", "tefsi"),
+			SigningKeyID:    os.Getenv("JWT_SIGNING_KID"),
+			AccessTokenTTL:  accessTokenTTL,
+			RefreshTokenTTL: refreshTokenTTL,
+		},
+	}
+
+	if cfg.Mail.Driver == "" {
+		return nil, fmt.Errorf("MAIL_DRIVER is not set, use smtp, file or memory")
+	}
+
+	if providersFile := os.Getenv("OIDC_PROVIDERS_FILE"); providersFile != "" {
+		providers, err := loadOIDCProviders(providersFile, cfg.PublicURL)
+		if err != nil {
+			return nil, err
+		}
+		cfg.OIDC = providers
+	}
+
+	if keysFile := os.Getenv("JWT_KEYS_FILE"); keysFile != "" {
+		keys, err := loadJWTKeys(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.JWT.Keys = keys
+	} else if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		cfg.JWT.Keys = []JWTKey{{ID: "default", Algorithm: "HS256", Secret: secret}}
+	} else {
+		log.Println("neither JWT_KEYS_FILE nor JWT_SECRET is set, signing tokens with a random secret")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		cfg.JWT.Keys = []JWTKey{{ID: "ephemeral", Algorithm: "HS256", Secret: base64.RawURLEncoding.EncodeToString(secret)}}
+	}
+
+	return cfg, nil
+}
+
+func loadJWTKeys(path string) ([]JWTKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []JWTKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid jwt keys file %s: %w", path, err)
+	}
+
+	for i := range keys {
+		if keys[i].PrivateKeyFile == "" {
+			continue
+		}
+		pem, err := os.ReadFile(keys[i].PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys[i].PrivateKey = string(pem)
+	}
+
+	return keys, nil
+}
+
+func loadLoginThrottle() (*LoginThrottleConfig, error) {
+	cfg := &LoginThrottleConfig{Store: getEnv("LOGIN_THROTTLE_STORE", "postgres")}
+	var err error
+	if cfg.FreeAttempts, err = getInt("LOGIN_FREE_ATTEMPTS", 3); err != nil {
+		return nil, err
+	}
+	if cfg.BaseDelay, err = getDuration("LOGIN_BASE_DELAY", time.Second); err != nil {
+		return nil, err
+	}
+	if cfg.MaxDelay, err = getDuration("LOGIN_MAX_DELAY", time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.MaxFailures, err = getInt("LOGIN_MAX_FAILURES", 10); err != nil {
+		return nil, err
+	}
+	if cfg.IPMaxFailures, err = getInt("LOGIN_IP_MAX_FAILURES", 100); err != nil {
+		return nil, err
+	}
+	if cfg.LockoutDuration, err = getDuration("LOGIN_LOCKOUT", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.Window, err = getDuration("LOGIN_FAILURE_WINDOW", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupInterval, err = getDuration("LOGIN_ATTEMPT_CLEANUP_INTERVAL", time.Hour); err != nil {
+		return nil, err
+	}
+	if cfg.CleanupInterval <= 0 {
+		return nil, fmt.Errorf("LOGIN_ATTEMPT_CLEANUP_INTERVAL has to be positive")
+	}
+	return cfg, nil
+}
+
+// defaults are the OWASP recommendations
+func loadPassword() (*PasswordConfig, error) {
+	cfg := &PasswordConfig{Algorithm: getEnv("PASSWORD_ALGORITHM", "argon2id")}
+	var err error
+	if cfg.BcryptCost, err = getInt("PASSWORD_BCRYPT_COST", 12); err != nil {
+		return nil, err
+	}
+	memory, err := getInt("PASSWORD_ARGON2_MEMORY", 19456)
+	if err != nil {
+		return nil, err
+	}
+	iterations, err := getInt("PASSWORD_ARGON2_ITERATIONS", 2)
+	if err != nil {
+		return nil, err
+	}
+	parallelism, err := getInt("PASSWORD_ARGON2_PARALLELISM", 1)
+	if err != nil {
+		return nil, err
+	}
+	if memory < 1 || iterations < 1 || parallelism < 1 || parallelism > 255 {
+		return nil, fmt.Errorf("invalid argon2 parameters")
+	}
+	cfg.Argon2Memory = uint32(memory)
+	cfg.Argon2Iterations = uint32(iterations)
+	cfg.Argon2Parallelism = uint8(parallelism)
+	return cfg, nil
+}
+
+func loadOIDCProviders(path string, publicURL string) ([]OIDCProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []OIDCProvider
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("invalid oidc providers file %s: %w", path, err)
+	}
+
+	for i := range providers {
+		if providers[i].Name == "" || providers[i].Issuer == "" || providers[i].ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %d needs a name, issuer and client_id", i)
+		}
+		if providers[i].RedirectURL == "" {
+			providers[i].RedirectURL = publicURL + "/users/oidc/" + providers[i].Name + "/callback"
+		}
+	}
+
+	return providers, nil
+}
+
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func getDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return d, nil
+}
+
+func getBool(key string, fallback bool) (bool, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func getInt(key string, fallback int) (int, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return i, nil
+}
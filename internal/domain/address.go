@@ -0,0 +1,46 @@
+package domain
+
+import "strings"
+
+// the part of an address that is copied onto orders,
+// so editing or deleting an address doesn't change past orders
+type PostalAddress struct {
+	// recipient
+	Name       string `json:"name"`
+	Phone      string `json:"phone"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	// ISO 3166-1 alpha-2 code
+	Country string `json:"country"`
+}
+
+// an entry of a user's address book, any address can be used for shipping and billing,
+// the defaults are used by orders that don't reference an address
+type Address struct {
+	ID     int `json:"id"`
+	UserID int `json:"user_id"`
+	PostalAddress
+	DefaultShipping bool `json:"default_shipping"`
+	DefaultBilling  bool `json:"default_billing"`
+}
+
+// trims and checks the required fields
+func (a *PostalAddress) Normalize() error {
+	for _, field := range []*string{&a.Name, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode} {
+		*field = strings.TrimSpace(*field)
+	}
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+
+	if a.Name == "" || a.Line1 == "" || a.City == "" || len(a.Country) != 2 {
+		return ErrInvalidAddress
+	}
+	phone, err := NormalizePhone(a.Phone)
+	if err != nil {
+		return err
+	}
+	a.Phone = phone
+	return nil
+}
@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// key for server to server integrations, requests with it get exactly the permissions in Scopes
+type APIKey struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// first part of the key, shown to tell keys apart and used to look them up
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// the full key is only returned once, when it is created
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
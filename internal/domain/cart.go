@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+const CartTokenHeader = "X-Cart-Token"
+
+type Cart struct {
+	// signed token of a guest cart, also sent in the X-Cart-Token header
+	Token string           `json:"cart_token,omitempty"`
+	Items []ItemWithAmount `json:"items"`
+}
+
+type CartItemRequest struct {
+	// required for items with variants, each variant is a separate line of the cart
+	VariantID int `json:"variant_id"`
+	// 0 removes the item from the cart
+	Amount int `json:"amount"`
+}
+
+// cart of a shopper who isn't logged in, only known through its cart token
+type GuestCart struct {
+	ID string
+	// the cart is deleted if it isn't changed until then
+	ExpiresAt time.Time
+}
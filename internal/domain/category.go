@@ -1,6 +1,137 @@
 package domain
 
+import (
+	"regexp"
+	"strings"
+)
+
 type Category struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// unique name used in urls, made from the title if not set
+	Slug string `json:"slug"`
+	// 0 for top level categories
+	ParentID int `json:"parent_id,omitempty"`
+	// breadcrumbs from the top level category down to this one, only set for a single category
+	Path []CategoryRef `json:"path,omitempty"`
+}
+
+type CategoryRef struct {
 	ID    int    `json:"id"`
 	Title string `json:"title"`
+	Slug  string `json:"slug"`
+}
+
+type CategoryNode struct {
+	ID       int            `json:"id"`
+	Title    string         `json:"title"`
+	Children []CategoryNode `json:"children"`
+}
+
+type CategoryMove struct {
+	// 0 moves the category to the top level
+	ParentID int `json:"parent_id"`
+}
+
+// nests categories under their parents, children keep the order of categories
+func BuildCategoryTree(categories []Category) []CategoryNode {
+	children := map[int][]Category{}
+	for _, category := range categories {
+		children[category.ParentID] = append(children[category.ParentID], category)
+	}
+
+	var build func(parentID int) []CategoryNode
+	build = func(parentID int) []CategoryNode {
+		nodes := []CategoryNode{}
+		for _, category := range children[parentID] {
+			nodes = append(nodes, CategoryNode{ID: category.ID, Title: category.Title, Children: build(category.ID)})
+		}
+		return nodes
+	}
+	return build(0)
+}
+
+// fields to change in a category, nil fields are left as they are
+type CategoryUpdate struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Slug        *string `json:"slug"`
+}
+
+func (u *CategoryUpdate) Validate() error {
+	if u.Title != nil && strings.TrimSpace(*u.Title) == "" {
+		return ErrInvalidCategory
+	}
+	if u.Slug != nil && !slugPattern.MatchString(*u.Slug) {
+		return ErrInvalidSlug
+	}
+	return nil
+}
+
+// what happens to the items and subcategories of a deleted category
+const (
+	// the category is only deleted if it has neither
+	CategoryDeleteRefuse = "refuse"
+	// both are moved to another category
+	CategoryDeleteReassign = "reassign"
+	// subcategories are deleted too and all their items are hidden and left without a category
+	CategoryDeleteArchive = "archive"
+)
+
+type CategoryDelete struct {
+	Strategy string
+	// the category to move everything to for CategoryDeleteReassign
+	TargetID int
+}
+
+func (d *CategoryDelete) Validate() error {
+	switch d.Strategy {
+	case CategoryDeleteRefuse, CategoryDeleteArchive:
+		return nil
+	case CategoryDeleteReassign:
+		if d.TargetID == 0 {
+			return ErrInvalidCategory
+		}
+		return nil
+	}
+	return ErrInvalidCategory
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "h", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// url friendly version of a title, russian letters are transliterated
+// and everything else that isn't a latin letter or digit separates words
+func Slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		latin, ok := cyrillicToLatin[r]
+		if !ok && (r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			latin, ok = string(r), true
+		}
+		if !ok {
+			dash = b.Len() > 0
+			continue
+		}
+		if latin == "" {
+			continue
+		}
+		if dash {
+			b.WriteByte('-')
+			dash = false
+		}
+		b.WriteString(latin)
+	}
+	if b.Len() == 0 {
+		return "category"
+	}
+	return b.String()
 }
@@ -0,0 +1,67 @@
+package domain
+
+import "errors"
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, all tokens of the session were revoked")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrUnknownRole         = errors.New("unknown role")
+	ErrUnknownPermission   = errors.New("unknown permission")
+	ErrRoleExists          = errors.New("role already exists")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserDisabled        = errors.New("user is disabled")
+	ErrPasswordResetNeeded = errors.New("password reset required")
+	ErrInvalidCredentials  = errors.New("invalid login or password")
+	ErrLastAdmin           = errors.New("can't demote the last admin")
+	ErrInvalidToken        = errors.New("invalid or expired token")
+	ErrInvalidEmail        = errors.New("invalid email address")
+	ErrEmailTaken          = errors.New("email address can't be used for this account")
+	ErrEmailNotVerified    = errors.New("email address is not verified")
+	ErrInvalidPhone        = errors.New("invalid phone number")
+	ErrInvalidAddress      = errors.New("invalid address")
+	ErrAddressNotFound     = errors.New("address not found")
+	ErrInvalidCartToken    = errors.New("invalid or expired cart token")
+	ErrInvalidAmount       = errors.New("amount can't be negative")
+	ErrItemNotFound        = errors.New("item not found")
+	ErrInvalidItem         = errors.New("invalid item")
+	ErrCategoryNotFound    = errors.New("category not found")
+	ErrCategoryCycle       = errors.New("can't move a category into itself or its subcategories")
+	ErrCategoryNotEmpty    = errors.New("category still has items or subcategories")
+	ErrInvalidCategory     = errors.New("invalid category")
+	ErrInvalidSlug         = errors.New("slugs can only contain lowercase latin letters, digits and single dashes")
+	ErrSlugTaken           = errors.New("slug is already used by another category")
+	ErrVersionConflict     = errors.New("item was changed since it was read")
+	ErrInvalidVariant      = errors.New("invalid variant or options")
+	ErrVariantNotFound     = errors.New("variant not found")
+	ErrVariantRequired     = errors.New("the item has variants, one of them has to be chosen")
+	ErrVariantUnavailable  = errors.New("variant is not available")
+	ErrVariantExists       = errors.New("the item already has a variant with these options")
+	ErrSKUTaken            = errors.New("sku is already used by another variant")
+	ErrVariantInUse        = errors.New("variant was ordered or stocked, make it unavailable instead")
+	ErrItemInUse           = errors.New("item has stock history, hide it instead")
+	ErrInsufficientStock   = errors.New("not enough stock")
+	ErrInvalidAdjustment   = errors.New("invalid stock adjustment")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderNotCancellable = errors.New("only orders in progress can be cancelled")
+	ErrOrderCancelled      = errors.New("order was cancelled and can't be changed")
+	ErrWarehouseNotFound   = errors.New("warehouse not found")
+	ErrInvalidWarehouse    = errors.New("invalid warehouse")
+	ErrWarehouseNameTaken  = errors.New("warehouse name is already used")
+	ErrInvalidTransfer     = errors.New("invalid stock transfer")
+	ErrMFANotEnrolled      = errors.New("two-factor authentication is not set up")
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrInvalidMFACode      = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAToken     = errors.New("invalid or expired mfa token")
+	ErrUnknownProvider     = errors.New("unknown identity provider")
+	ErrInvalidOIDCState    = errors.New("invalid or expired login state")
+	ErrInvalidIDToken      = errors.New("invalid id token")
+	ErrIdentityNotFound    = errors.New("identity not linked to any user")
+	ErrLoginToLink         = errors.New("an account with this email address exists, log in with its password and verify the address first")
+	ErrTooManyAttempts     = errors.New("too many failed login attempts")
+	ErrInvalidAPIKey       = errors.New("invalid api key")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrAPIKeyNotFound      = errors.New("api key not found")
+	ErrScopeNotAllowed     = errors.New("can't grant a scope you don't have")
+)
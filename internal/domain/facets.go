@@ -0,0 +1,38 @@
+package domain
+
+// lower bounds of the price facets, each bucket ends where the next one starts
+var PriceBuckets = []int{0, 500, 1000, 5000, 10000, 50000}
+
+// counts of the items matching the filters of a query, whatever its page
+type ItemFacets struct {
+	Total      int             `json:"total"`
+	Categories []CategoryFacet `json:"categories"`
+	// one per PriceBuckets, empty ones too
+	Prices []PriceFacet `json:"prices"`
+	// counts of the values of each attribute
+	Attributes map[string][]AttributeFacet `json:"attributes"`
+}
+
+type CategoryFacet struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Count int    `json:"count"`
+}
+
+// items with From <= price < To, the last bucket has no upper bound
+type PriceFacet struct {
+	From  int  `json:"from"`
+	To    *int `json:"to,omitempty"`
+	Count int  `json:"count"`
+}
+
+type AttributeFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type ItemPage struct {
+	Items      []Item      `json:"items"`
+	Facets     *ItemFacets `json:"facets"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
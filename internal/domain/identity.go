@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+// account of a user at an external identity provider
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	UserID   int    `json:"user_id"`
+	Email    string `json:"email"`
+}
+
+// what tefsi uses from a validated ID token
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// login started with a provider, kept until the user comes back to the callback
+type OIDCState struct {
+	Hash         string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
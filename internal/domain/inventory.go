@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// units of an item, or of one of its variants, in stock at a warehouse.
+// items without a stock level anywhere aren't tracked and can always be ordered
+type StockLevel struct {
+	WarehouseID int `json:"warehouse_id"`
+	ItemID      int `json:"item_id"`
+	VariantID   int `json:"variant_id,omitempty"`
+	Quantity    int `json:"quantity"`
+}
+
+const (
+	// stock reserved by a placed order
+	AdjustmentReasonOrder = "order"
+	// stock given back by a cancelled, changed or deleted order
+	AdjustmentReasonOrderReleased = "order_released"
+	// stock moved between warehouses, recorded once for each of them
+	AdjustmentReasonTransfer   = "transfer"
+	AdjustmentReasonRestock    = "restock"
+	AdjustmentReasonReturn     = "return"
+	AdjustmentReasonDamaged    = "damaged"
+	AdjustmentReasonCorrection = "correction"
+)
+
+// reasons that can be used for manual adjustments, the others are recorded by orders
+var ManualAdjustmentReasons = []string{
+	AdjustmentReasonRestock,
+	AdjustmentReasonReturn,
+	AdjustmentReasonDamaged,
+	AdjustmentReasonCorrection,
+}
+
+// an entry of the inventory ledger, every change of a stock level is recorded as one
+type StockAdjustment struct {
+	ID int `json:"id"`
+	// the default warehouse if not set
+	WarehouseID int    `json:"warehouse_id"`
+	ItemID      int    `json:"item_id"`
+	VariantID   int    `json:"variant_id,omitempty"`
+	Delta       int    `json:"delta"`
+	Reason      string `json:"reason"`
+	Note        string `json:"note,omitempty"`
+	// set for adjustments made by orders and transfers
+	OrderID    int       `json:"order_id,omitempty"`
+	TransferID int       `json:"transfer_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (a *StockAdjustment) Validate() error {
+	if a.Delta == 0 || !slices.Contains(ManualAdjustmentReasons, a.Reason) {
+		return ErrInvalidAdjustment
+	}
+	return nil
+}
+
+// a line of an order that can't be reserved
+type StockShortage struct {
+	ItemID    int `json:"item_id"`
+	VariantID int `json:"variant_id,omitempty"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+// returned when an order asks for more than is in stock, it matches ErrInsufficientStock
+type StockShortageError struct {
+	Lines []StockShortage
+}
+
+func (e *StockShortageError) Error() string {
+	lines := make([]string, 0, len(e.Lines))
+	for _, line := range e.Lines {
+		lines = append(lines, fmt.Sprintf("item %d variant %d: %d requested, %d available",
+			line.ItemID, line.VariantID, line.Requested, line.Available))
+	}
+	return ErrInsufficientStock.Error() + ": " + strings.Join(lines, "; ")
+}
+
+func (e *StockShortageError) Unwrap() error {
+	return ErrInsufficientStock
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "strings"
+
 type Item struct {
 	ID            int    `json:"id"`
 	Title         string `json:"title"`
@@ -7,9 +9,68 @@ type Item struct {
 	Price         int    `json:"price"`
 	CategoryID    int    `json:"category_id"`
 	CategoryTitle string `json:"category_title"`
+	// hidden items are only shown to those who know their id
+	Hidden bool `json:"hidden"`
+	// like color or size, used for filters and facets
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// incremented on every update, also sent as the ETag
+	Version int `json:"version"`
+	// options the variants of the item differ in
+	Options []ItemOption `json:"options,omitempty"`
+	// only set for a single item
+	Variants []Variant `json:"variants,omitempty"`
+	// only set in search results
+	Rank      float32        `json:"rank,omitempty"`
+	Highlight *ItemHighlight `json:"highlight,omitempty"`
+}
+
+// fragments of a search result with the matched words wrapped in <b></b>,
+// the rest of the text is html-escaped so the fragments can be inserted as is
+type ItemHighlight struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// fields to change in an item, nil fields are left as they are
+type ItemUpdate struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Price       *int    `json:"price"`
+	CategoryID  *int    `json:"category_id"`
+	Hidden      *bool   `json:"hidden"`
+	// replaces all attributes
+	Attributes *map[string]string `json:"attributes"`
+}
+
+// an update that sets every field of item
+func ReplaceItem(item *Item) *ItemUpdate {
+	attributes := item.Attributes
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	return &ItemUpdate{
+		Title:       &item.Title,
+		Description: &item.Description,
+		Price:       &item.Price,
+		CategoryID:  &item.CategoryID,
+		Hidden:      &item.Hidden,
+		Attributes:  &attributes,
+	}
+}
+
+func (u *ItemUpdate) Validate() error {
+	if u.Title != nil && strings.TrimSpace(*u.Title) == "" {
+		return ErrInvalidItem
+	}
+	if u.Price != nil && *u.Price < 0 {
+		return ErrInvalidItem
+	}
+	return nil
 }
 
 type ItemWithAmount struct {
 	ItemID int `json:"item_id"`
-	Amount int `json:"amount"`
+	// required for items with variants
+	VariantID int `json:"variant_id,omitempty"`
+	Amount    int `json:"amount"`
 }
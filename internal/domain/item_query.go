@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// sort keys of ItemQuery, a leading - sorts descending
+const (
+	ItemSortPrice     = "price"
+	ItemSortPriceDesc = "-price"
+	ItemSortTitle     = "title"
+	ItemSortTitleDesc = "-title"
+	ItemSortNewest    = "newest"
+	// best matches of Search first
+	ItemSortRelevance = "relevance"
+)
+
+var ItemSorts = []string{ItemSortPrice, ItemSortPriceDesc, ItemSortTitle, ItemSortTitleDesc, ItemSortNewest, ItemSortRelevance}
+
+var (
+	ErrInvalidItemQuery = errors.New("invalid item query")
+	ErrInvalidCursor    = errors.New("invalid cursor")
+)
+
+// filters, order and page of an item list, zero values don't filter anything
+type ItemQuery struct {
+	// full-text search over titles and descriptions, in web search syntax:
+	// "quoted phrases", or and -excluded words
+	Search string
+	// items of any of the categories
+	CategoryIDs []int
+	// also items of the subcategories of CategoryIDs, at any depth
+	IncludeSubcategories bool
+	MinPrice             *int
+	MaxPrice             *int
+	// items with any of the values of each attribute
+	Attributes map[string][]string
+	// one of ItemSorts, items are sorted by relevance if empty and Search is set, by id otherwise
+	Sort string
+	// 0 means no limit
+	Limit  int
+	Offset int
+	// continues after the last item of a previous page, can't be combined with Offset
+	Cursor *ItemCursor
+}
+
+// position of an item in a sorted list, only the fields of the sort key are set
+type ItemCursor struct {
+	Sort  string `json:"s,omitempty"`
+	ID    int    `json:"id"`
+	Price int    `json:"p,omitempty"`
+	Title string `json:"t,omitempty"`
+	// ts_rank is a real, float32 survives the round trip through json exactly
+	Rank float32 `json:"r,omitempty"`
+}
+
+func (q *ItemQuery) Validate() error {
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return ErrInvalidItemQuery
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return ErrInvalidItemQuery
+	}
+	if q.Sort != "" && !slices.Contains(ItemSorts, q.Sort) {
+		return ErrInvalidItemQuery
+	}
+	for name, values := range q.Attributes {
+		if name == "" || len(values) == 0 {
+			return ErrInvalidItemQuery
+		}
+	}
+	if q.Sort == ItemSortRelevance && q.Search == "" {
+		return ErrInvalidItemQuery
+	}
+	if q.Cursor != nil && (q.Offset != 0 || q.Cursor.Sort != q.Sort) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// returns the cursor of the page after items if it was a full page
+func (q *ItemQuery) NextCursor(items []Item) string {
+	if q.Limit == 0 || len(items) < q.Limit {
+		return ""
+	}
+	last := items[len(items)-1]
+	cursor := ItemCursor{Sort: q.Sort, ID: last.ID}
+	switch q.SortKey() {
+	case ItemSortPrice, ItemSortPriceDesc:
+		cursor.Price = last.Price
+	case ItemSortTitle, ItemSortTitleDesc:
+		cursor.Title = last.Title
+	case ItemSortRelevance:
+		cursor.Rank = last.Rank
+	}
+	return cursor.Encode()
+}
+
+// the sort actually used, Sort with the default filled in
+func (q *ItemQuery) SortKey() string {
+	if q.Sort == "" && q.Search != "" {
+		return ItemSortRelevance
+	}
+	return q.Sort
+}
+
+func (c *ItemCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseItemCursor(s string) (*ItemCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &ItemCursor{}
+	err = json.Unmarshal(data, cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// failed logins for one key, a login or a client ip
+type LoginAttempts struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// a login attempt the throttle counted as failed before the password was checked,
+// it stays counted if it fails and is taken back otherwise
+type PendingLogin struct {
+	Login string
+	// empty if the attempt was only counted for the login
+	IP   string
+	Time time.Time
+	// when the login and ip failed before this attempt, put back when it's taken back
+	PreviousLoginFailure time.Time
+	PreviousIPFailure    time.Time
+}
@@ -0,0 +1,7 @@
+package domain
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
@@ -0,0 +1,40 @@
+package domain
+
+// totp secret of a user, it only protects logins once confirmed with a code
+type UserMFA struct {
+	UserID    int
+	Secret    string
+	Confirmed bool
+	// last time step a code was accepted for, older codes can't be reused
+	LastStep int64
+}
+
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	// otpauth:// uri for authenticator apps
+	URI string `json:"uri"`
+}
+
+// a totp code or, if the authenticator is lost, one of the recovery codes
+type MFACode struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// recovery codes are shown once when mfa is confirmed
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// returned by login instead of tokens when the user has mfa enabled
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	// lifetime of the mfa token in seconds
+	ExpiresIn int `json:"expires_in"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	MFACode
+}
@@ -1,9 +1,20 @@
 package domain
 
 type Order struct {
-	ID          int              `json:"id"`
-	StatusID    int              `json:"status_id"`
-	StatusTitle string           `json:"status_title"`
-	UserID      int              `json:"user_id"`
-	Items       []ItemWithAmount `json:"items"`
+	ID          int    `json:"id"`
+	StatusID    int    `json:"status_id"`
+	StatusTitle string `json:"status_title"`
+	// 0 for guest orders
+	UserID int `json:"user_id"`
+	// required for guest orders
+	ContactEmail string           `json:"contact_email"`
+	Items        []ItemWithAmount `json:"items"`
+	// addresses from the user's address book, the defaults are used if not set
+	ShippingAddressID int `json:"shipping_address_id,omitempty"`
+	BillingAddressID  int `json:"billing_address_id,omitempty"`
+	// copies of the addresses made when the order was placed
+	ShippingAddress *PostalAddress `json:"shipping_address"`
+	BillingAddress  *PostalAddress `json:"billing_address"`
+	// the warehouses the reserved items are shipped from
+	Allocations []Allocation `json:"allocations,omitempty"`
 }
@@ -0,0 +1,40 @@
+package domain
+
+import "slices"
+
+const (
+	PermissionCatalogWrite    = "catalog:write"
+	PermissionOrdersRead      = "orders:read"
+	PermissionOrdersFulfil    = "orders:fulfil"
+	PermissionOrdersManage    = "orders:manage"
+	PermissionUsersManage     = "users:manage"
+	PermissionAPIKeysManage   = "api_keys:manage"
+	PermissionInventoryManage = "inventory:manage"
+)
+
+var AllPermissions = []string{
+	PermissionCatalogWrite,
+	PermissionOrdersRead,
+	PermissionOrdersFulfil,
+	PermissionOrdersManage,
+	PermissionUsersManage,
+	PermissionAPIKeysManage,
+	PermissionInventoryManage,
+}
+
+// the admin role always has every permission
+const RoleAdmin = "admin"
+
+type Role struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleAssignment struct {
+	Role string `json:"role"`
+}
+
+func IsPermission(permission string) bool {
+	return slices.Contains(AllPermissions, permission)
+}
@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// one login on one device, its id is the id of the refresh token family
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// when the last refresh token of the session expires
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// where a login comes from
+type SessionInfo struct {
+	UserAgent string
+	IP        string
+}
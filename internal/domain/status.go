@@ -1,5 +1,12 @@
 package domain
 
+// statuses created with the orders table
+const (
+	StatusInProgress = 1
+	StatusReady      = 2
+	StatusCancelled  = 3
+)
+
 type Status struct {
 	ID    int    `json:"id"`
 	Title string `json:"title"`
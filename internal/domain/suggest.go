@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	SuggestionItem     = "item"
+	SuggestionCategory = "category"
+)
+
+// queries longer than this aren't typed into a search box
+const maxSuggestQueryLength = 100
+
+var ErrSuggestTimeout = errors.New("suggestions took too long")
+
+// an item title or category name matching what the user has typed so far
+type Suggestion struct {
+	// SuggestionItem or SuggestionCategory
+	Type  string `json:"type"`
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+type SuggestQuery struct {
+	Query string
+	Limit int
+}
+
+func (q *SuggestQuery) Validate() error {
+	if q.Limit <= 0 || utf8.RuneCountInString(q.Query) > maxSuggestQueryLength {
+		return ErrInvalidItemQuery
+	}
+	return nil
+}
+
+// the lowercased query and, if it differs, the same keys typed in the other keyboard layout,
+// so "vfibyf" also finds "машина" and "ьфырштф" finds "mashina"
+func (q *SuggestQuery) Variants() []string {
+	query := strings.ToLower(strings.TrimSpace(q.Query))
+	variants := []string{query}
+	if switched := SwitchKeyboardLayout(query); switched != query {
+		variants = append(variants, switched)
+	}
+	return variants
+}
+
+// keys of the qwerty and йцукен layouts, in the same order
+const (
+	qwertyKeys = "qwertyuiop[]asdfghjkl;'zxcvbnm,.`"
+	jcukenKeys = "йцукенгшщзхъфывапролджэячсмитьбюё"
+)
+
+var keyboardLayout = func() map[rune]rune {
+	layout := map[rune]rune{}
+	jcuken := []rune(jcukenKeys)
+	for i, key := range []rune(qwertyKeys) {
+		layout[key] = jcuken[i]
+		layout[jcuken[i]] = key
+	}
+	return layout
+}()
+
+// converts lowercase text typed in the qwerty layout to йцукен and back,
+// other characters are kept as they are
+func SwitchKeyboardLayout(s string) string {
+	return strings.Map(func(r rune) rune {
+		if switched, ok := keyboardLayout[r]; ok {
+			return switched
+		}
+		return r
+	}, s)
+}
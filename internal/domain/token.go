@@ -0,0 +1,42 @@
+package domain
+
+import "time"
+
+// refresh tokens are stored hashed, every refresh replaces the used token
+// with a new one from the same family
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeVerifyEmail   = "verify_email"
+)
+
+// single use token sent to the user by mail, stored hashed
+type UserToken struct {
+	Hash      string
+	UserID    int
+	Purpose   string
+	ExpiresAt time.Time
+}
+
+// either login or email identifies the user
+type ForgotPasswordRequest struct {
+	Login string `json:"login"`
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
@@ -1,13 +1,112 @@
 package domain
 
+import (
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+)
+
 // Структуры данных
 type User struct {
-	ID       int    `json:"id"`
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	// password hash in PHC format, never sent to clients
+	Password      string `json:"-"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Phone         string `json:"phone"`
+	// set if the user has the admin role
+	IsAdmin     bool     `json:"is_admin"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	Disabled    bool     `json:"disabled"`
+	MFAEnabled  bool     `json:"mfa_enabled"`
+	// set by an admin, the user can't log in until they change their password
+	PasswordResetRequired bool `json:"password_reset_required"`
+}
+
+// the only fields anyone can set when signing up
+type Credentials struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
-	IsAdmin  bool   `json:"is_admin"`
+	Email    string `json:"email"`
 }
 
-type LoginResponse struct {
+type VerifyEmailRequest struct {
 	Token string `json:"token"`
 }
+
+// lowercases and validates an email address, display names like "Name <a@b.c>" aren't accepted
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// profile fields a user can change about themselves, nil fields are left as they are
+type UserUpdate struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email"`
+	Phone *string `json:"phone"`
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{5,15}$`)
+
+// strips the usual separators from a phone number, an empty number is valid
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(" -().", r) {
+			return -1
+		}
+		return r
+	}, phone)
+	if phone != "" && !phonePattern.MatchString(phone) {
+		return "", ErrInvalidPhone
+	}
+	return phone, nil
+}
+
+type PasswordChange struct {
+	Login       string `json:"login"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+type UserQuery struct {
+	Search string
+	Limit  int
+	Offset int
+}
+
+type UserList struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+func (u *User) HasPermission(permission string) bool {
+	return slices.Contains(u.Permissions, permission)
+}
+
+// disabled users and users who have to reset their password can't log in
+// or keep using tokens issued before that
+func (u *User) CanLogIn() error {
+	if u.Disabled {
+		return ErrUserDisabled
+	}
+	if u.PasswordResetRequired {
+		return ErrPasswordResetNeeded
+	}
+	return nil
+}
+
+type LoginResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	// lifetime of the access token in seconds
+	ExpiresIn int `json:"expires_in"`
+}
@@ -0,0 +1,54 @@
+package domain
+
+import "slices"
+
+// something customers choose when buying an item, like size or color, with its possible values
+type ItemOption struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// one purchasable version of an item with a value for each of its options
+type Variant struct {
+	ID     int    `json:"id"`
+	ItemID int    `json:"item_id"`
+	SKU    string `json:"sku"`
+	// option name to value
+	Options map[string]string `json:"options"`
+	// overrides the price of the item if set
+	Price     *int `json:"price,omitempty"`
+	Available bool `json:"available"`
+}
+
+func ValidateItemOptions(options []ItemOption) error {
+	names := []string{}
+	for _, option := range options {
+		if option.Name == "" || len(option.Values) == 0 || slices.Contains(names, option.Name) {
+			return ErrInvalidVariant
+		}
+		names = append(names, option.Name)
+		for i, value := range option.Values {
+			if value == "" || slices.Contains(option.Values[:i], value) {
+				return ErrInvalidVariant
+			}
+		}
+	}
+	return nil
+}
+
+// a variant needs a SKU and exactly one of the allowed values of each option of its item
+func (v *Variant) Validate(options []ItemOption) error {
+	if v.SKU == "" || len(v.Options) != len(options) || len(options) == 0 {
+		return ErrInvalidVariant
+	}
+	if v.Price != nil && *v.Price < 0 {
+		return ErrInvalidVariant
+	}
+	for _, option := range options {
+		value, ok := v.Options[option.Name]
+		if !ok || !slices.Contains(option.Values, value) {
+			return ErrInvalidVariant
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// created with the warehouses table, stock without a warehouse is kept here
+const DefaultWarehouseID = 1
+
+type Warehouse struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// orders are shipped from warehouses with higher priority first
+	Priority int `json:"priority"`
+	// stock of inactive warehouses isn't allocated to orders
+	Active bool `json:"active"`
+}
+
+func (w *Warehouse) Validate() error {
+	w.Name = strings.TrimSpace(w.Name)
+	if w.Name == "" {
+		return ErrInvalidWarehouse
+	}
+	return nil
+}
+
+// changes to a warehouse, nil fields are left as they are
+type WarehouseUpdate struct {
+	Name     *string `json:"name"`
+	Priority *int    `json:"priority"`
+	Active   *bool   `json:"active"`
+}
+
+func (u *WarehouseUpdate) Validate() error {
+	if u.Name != nil {
+		name := strings.TrimSpace(*u.Name)
+		if name == "" {
+			return ErrInvalidWarehouse
+		}
+		u.Name = &name
+	}
+	return nil
+}
+
+// moves stock of an item or variant from one warehouse to another
+type StockTransfer struct {
+	ID              int       `json:"id"`
+	FromWarehouseID int       `json:"from_warehouse_id"`
+	ToWarehouseID   int       `json:"to_warehouse_id"`
+	ItemID          int       `json:"item_id"`
+	VariantID       int       `json:"variant_id,omitempty"`
+	Quantity        int       `json:"quantity"`
+	Note            string    `json:"note,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func (t *StockTransfer) Validate() error {
+	if t.Quantity <= 0 || t.ItemID == 0 || t.FromWarehouseID == 0 || t.ToWarehouseID == 0 ||
+		t.FromWarehouseID == t.ToWarehouseID {
+		return ErrInvalidTransfer
+	}
+	return nil
+}
+
+// units of an order line shipped from a warehouse
+type Allocation struct {
+	WarehouseID int `json:"warehouse_id"`
+	ItemID      int `json:"item_id"`
+	VariantID   int `json:"variant_id,omitempty"`
+	Amount      int `json:"amount"`
+}
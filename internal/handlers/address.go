@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+)
+
+func (h *UserHandler) GetAddresses(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getaddresses request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := h.service.GetAddresses(r.Context(), userID)
+	if err != nil {
+		log.Printf("error occured in getaddresses service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(addresses)
+}
+
+func (h *UserHandler) CreateAddress(w http.ResponseWriter, r *http.Request) {
+	log.Println("received createaddress request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var address domain.Address
+	err = json.NewDecoder(r.Body).Decode(&address)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	address.UserID = userID
+
+	err = h.service.CreateAddress(r.Context(), &address)
+	if errors.Is(err, domain.ErrInvalidAddress) || errors.Is(err, domain.ErrInvalidPhone) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in createaddress service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("created address with id %d for user with id %d", address.ID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(address)
+}
+
+func (h *UserHandler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	log.Println("received updateaddress request")
+	userID, addressID, ok := addressParams(w, r)
+	if !ok {
+		return
+	}
+
+	var address domain.Address
+	err := json.NewDecoder(r.Body).Decode(&address)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	address.ID = addressID
+	address.UserID = userID
+
+	err = h.service.UpdateAddress(r.Context(), &address)
+	if errors.Is(err, domain.ErrInvalidAddress) || errors.Is(err, domain.ErrInvalidPhone) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrAddressNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in updateaddress service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("updated address with id %d", address.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(address)
+}
+
+func (h *UserHandler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	log.Println("received deleteaddress request")
+	userID, addressID, ok := addressParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DeleteAddress(r.Context(), userID, addressID)
+	if errors.Is(err, domain.ErrAddressNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in deleteaddress service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("deleted address with id %d", addressID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parses the user and address ids from the url, responds with 400 if they are invalid
+func addressParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	addressIDStr := chi.URLParam(r, "address_id")
+	addressID, err := strconv.Atoi(addressIDStr)
+	if err != nil {
+		log.Printf("got invalid address ID '%s'", addressIDStr)
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return userID, addressID, true
+}
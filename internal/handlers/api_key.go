@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
+)
+
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, creator *domain.User, request *domain.APIKeyRequest) (*domain.CreatedAPIKey, error)
+	GetAPIKeys(ctx context.Context) (*[]domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+}
+
+type APIKeyHandler struct {
+	service APIKeyService
+}
+
+func NewAPIKeyHandler(service APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: service}
+}
+
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("received createapikey request")
+	var request domain.APIKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	creator := middleware.UserFromContext(r.Context())
+	key, err := h.service.CreateAPIKey(r.Context(), creator, &request)
+	if errors.Is(err, domain.ErrScopeNotAllowed) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in createapikey service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("created api key with id %d", key.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getapikeys request")
+	keys, err := h.service.GetAPIKeys(r.Context())
+	if err != nil {
+		log.Printf("error occured in getapikeys service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("received revokeapikey request")
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid api key ID '%s'", idStr)
+		http.Error(w, "Invalid api key ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, domain.ErrAPIKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in revokeapikey service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("revoked api key with id %d", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
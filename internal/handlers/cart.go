@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
+)
+
+// responds with the cart of the logged in user or with the guest cart from the X-Cart-Token header
+func (h *UserHandler) GetCart(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getcart request")
+	var items *[]domain.ItemWithAmount
+	var err error
+
+	user := middleware.UserFromContext(r.Context())
+	if user != nil {
+		if user.ID == 0 {
+			http.Error(w, "api keys have no cart", http.StatusBadRequest)
+			return
+		}
+		items, err = h.service.GetUserCartByID(r.Context(), user.ID)
+	} else if cartID := h.guestCartID(r); cartID != "" {
+		items, err = h.service.GetGuestCart(r.Context(), cartID)
+	} else {
+		items = &[]domain.ItemWithAmount{}
+	}
+	if err != nil {
+		log.Printf("error occured in getcart service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.Cart{Items: *items})
+}
+
+// sets the amount of an item in the cart, guests without a cart token get a new cart.
+// guests get a fresh token with every change
+func (h *UserHandler) SetCartItem(w http.ResponseWriter, r *http.Request) {
+	log.Println("received setcartitem request")
+	idStr := chi.URLParam(r, "item_id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var request domain.CartItemRequest
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	item := domain.ItemWithAmount{ItemID: itemID, VariantID: request.VariantID, Amount: request.Amount}
+
+	cart := domain.Cart{}
+	var items *[]domain.ItemWithAmount
+	user := middleware.UserFromContext(r.Context())
+	if user != nil {
+		if user.ID == 0 {
+			http.Error(w, "api keys have no cart", http.StatusBadRequest)
+			return
+		}
+		err = h.service.SetUserCartItem(r.Context(), user.ID, &item)
+		if err == nil {
+			items, err = h.service.GetUserCartByID(r.Context(), user.ID)
+		}
+	} else {
+		var guestCart *domain.GuestCart
+		guestCart, err = h.service.SetGuestCartItem(r.Context(), h.guestCartID(r), &item)
+		if err == nil {
+			cart.Token, err = h.auth.IssueCartToken(guestCart)
+		}
+		if err == nil {
+			items, err = h.service.GetGuestCart(r.Context(), guestCart.ID)
+		}
+	}
+	if errors.Is(err, domain.ErrInvalidAmount) || errors.Is(err, domain.ErrItemNotFound) ||
+		errors.Is(err, domain.ErrVariantRequired) || errors.Is(err, domain.ErrVariantNotFound) ||
+		errors.Is(err, domain.ErrVariantUnavailable) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in setcartitem service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cart.Items = *items
+
+	if cart.Token != "" {
+		w.Header().Set(domain.CartTokenHeader, cart.Token)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cart)
+}
+
+// returns the id of the guest cart from the X-Cart-Token header, or "" without a valid token
+func (h *UserHandler) guestCartID(r *http.Request) string {
+	token := r.Header.Get(domain.CartTokenHeader)
+	if token == "" {
+		return ""
+	}
+	cartID, err := h.auth.ParseCartToken(token)
+	if err != nil {
+		log.Printf("ignoring cart token: %s", err.Error())
+		return ""
+	}
+	return cartID
+}
+
+// moves the guest cart of a shopper who just logged in into their own cart,
+// a failed merge doesn't fail the login
+func (h *UserHandler) mergeGuestCart(r *http.Request, userID int) {
+	cartID := h.guestCartID(r)
+	if cartID == "" {
+		return
+	}
+	err := h.service.MergeGuestCart(r.Context(), cartID, userID)
+	if err != nil {
+		log.Printf("can't merge guest cart into the cart of user %d: %s", userID, err.Error())
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -15,39 +16,45 @@ type CategoryService interface {
 	CreateCategory(ctx context.Context, category *domain.Category) error
 	GetCategoryByID(ctx context.Context, id int) (*domain.Category, error)
 	GetCategories(ctx context.Context) (*[]domain.Category, error)
-	DeleteCategory(ctx context.Context, id int) error
+	GetCategoryBySlug(ctx context.Context, slug string) (*domain.Category, error)
+	UpdateCategory(ctx context.Context, id int, update *domain.CategoryUpdate) (*domain.Category, error)
+	DeleteCategory(ctx context.Context, id int, strategy *domain.CategoryDelete) error
+	GetCategoryTree(ctx context.Context) ([]domain.CategoryNode, error)
+	MoveCategory(ctx context.Context, id int, move *domain.CategoryMove) error
 }
 
 type CategoryHandler struct {
 	service CategoryService
-	auth    Auth
 }
 
-func NewCategoryHandler(service CategoryService, auth Auth) *CategoryHandler {
-	return &CategoryHandler{service, auth}
+func NewCategoryHandler(service CategoryService) *CategoryHandler {
+	return &CategoryHandler{service}
 }
 
 func (h *CategoryHandler) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	log.Println("received create category request")
 	var category domain.Category
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+	err := json.NewDecoder(r.Body).Decode(&category)
 	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
+
+	err = h.service.CreateCategory(r.Context(), &category)
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, "parent category not found", http.StatusBadRequest)
 		return
 	}
-	err = json.NewDecoder(r.Body).Decode(&category)
-	if err != nil {
-		log.Printf("bad json received, %s", err.Error())
+	if errors.Is(err, domain.ErrInvalidCategory) || errors.Is(err, domain.ErrInvalidSlug) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	err = h.service.CreateCategory(r.Context(), &category)
+	if errors.Is(err, domain.ErrSlugTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in createcategories service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -70,6 +77,10 @@ func (h *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request
 	}
 
 	category, err := h.service.GetCategoryByID(r.Context(), categoryID)
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in getcategorybyid service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -81,6 +92,69 @@ func (h *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(category)
 }
 
+func (h *CategoryHandler) GetCategoryBySlug(w http.ResponseWriter, r *http.Request) {
+	log.Println("receieved getcategorybyslug request")
+	slug := chi.URLParam(r, "slug")
+
+	category, err := h.service.GetCategoryBySlug(r.Context(), slug)
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in getcategorybyslug service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with category '%s' with id %d", category.Title, category.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(category)
+}
+
+func (h *CategoryHandler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	log.Println("received updatecategory request")
+
+	idStr := chi.URLParam(r, "id")
+	categoryID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid category id '%s'", idStr)
+		http.Error(w, "Invalid Category ID", http.StatusBadRequest)
+		return
+	}
+
+	var update domain.CategoryUpdate
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category, err := h.service.UpdateCategory(r.Context(), categoryID, &update)
+	if errors.Is(err, domain.ErrInvalidCategory) || errors.Is(err, domain.ErrInvalidSlug) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrSlugTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in updatecategory service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("updated category %d", categoryID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(category)
+}
+
 func (h *CategoryHandler) GetCategories(w http.ResponseWriter, r *http.Request) {
 	log.Println("received getcategories request")
 	categoryList, err := h.service.GetCategories(r.Context())
@@ -95,18 +169,62 @@ func (h *CategoryHandler) GetCategories(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(*categoryList)
 }
 
-func (h *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
-	log.Println("received deletecategory request")
+func (h *CategoryHandler) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getcategorytree request")
+	tree, err := h.service.GetCategoryTree(r.Context())
+	if err != nil {
+		log.Printf("error occured in getcategorytree service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("responded with category tree")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+func (h *CategoryHandler) MoveCategory(w http.ResponseWriter, r *http.Request) {
+	log.Println("received movecategory request")
+
+	idStr := chi.URLParam(r, "id")
+	categoryID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid category id '%s'", idStr)
+		http.Error(w, "Invalid Category ID", http.StatusBadRequest)
+		return
+	}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+	var move domain.CategoryMove
+	err = json.NewDecoder(r.Body).Decode(&move)
 	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
+
+	err = h.service.MoveCategory(r.Context(), categoryID, &move)
+	if errors.Is(err, domain.ErrCategoryCycle) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in movecategory service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Printf("moved category %d under %d", categoryID, move.ParentID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// query parameters: strategy, one of refuse (the default), reassign and archive,
+// and target, the category to reassign to
+func (h *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	log.Println("received deletecategory request")
 
 	idStr := chi.URLParam(r, "id")
 	categoryID, err := strconv.Atoi(idStr)
@@ -116,7 +234,31 @@ func (h *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = h.service.DeleteCategory(r.Context(), categoryID)
+	strategy := &domain.CategoryDelete{Strategy: r.URL.Query().Get("strategy")}
+	if strategy.Strategy == "" {
+		strategy.Strategy = domain.CategoryDeleteRefuse
+	}
+	if target := r.URL.Query().Get("target"); target != "" {
+		strategy.TargetID, err = strconv.Atoi(target)
+		if err != nil {
+			http.Error(w, "Invalid target category ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.service.DeleteCategory(r.Context(), categoryID, strategy)
+	if errors.Is(err, domain.ErrInvalidCategory) {
+		http.Error(w, "invalid strategy or target", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrCategoryNotEmpty) || errors.Is(err, domain.ErrCategoryCycle) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in deletecategory service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
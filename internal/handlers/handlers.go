@@ -1,14 +1,30 @@
 package handlers
 
-import "tefsi/internal/domain"
+import (
+	"context"
+
+	"tefsi/internal/domain"
+)
 
 type Auth interface {
-	GetUserFromJWT(header string) (*domain.User, error)
+	IssueTokens(ctx context.Context, user *domain.User, info *domain.SessionInfo) (*domain.LoginResponse, error)
+	Refresh(ctx context.Context, refreshToken string) (*domain.LoginResponse, error)
+	Logout(ctx context.Context, header string, refreshToken string) error
+	RevokeUserTokens(ctx context.Context, userID int) error
+	IssueMFAChallenge(user *domain.User) (*domain.MFAChallenge, error)
+	ParseMFAChallenge(token string) (string, error)
+	GetSessions(ctx context.Context, userID int) (*[]domain.Session, error)
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
+	IssueCartToken(cart *domain.GuestCart) (string, error)
+	ParseCartToken(token string) (string, error)
 }
 
 type AllHandlers struct {
-	UserHandler     *UserHandler
-	ItemHandler     *ItemHandler
-	OrderHandler    *OrderHandler
-	CategoryHandler *CategoryHandler
+	UserHandler      *UserHandler
+	ItemHandler      *ItemHandler
+	OrderHandler     *OrderHandler
+	CategoryHandler  *CategoryHandler
+	JWKSHandler      *JWKSHandler
+	APIKeyHandler    *APIKeyHandler
+	InventoryHandler *InventoryHandler
 }
@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tefsi/internal/domain"
+
+	"github.com/go-chi/chi"
+)
+
+type InventoryService interface {
+	GetStock(ctx context.Context, itemID int) (*[]domain.StockLevel, error)
+	AdjustStock(ctx context.Context, adjustment *domain.StockAdjustment) error
+	GetAdjustments(ctx context.Context, itemID int, limit int) (*[]domain.StockAdjustment, error)
+	GetWarehouseStock(ctx context.Context, warehouseID int) (*[]domain.StockLevel, error)
+	GetWarehouses(ctx context.Context) (*[]domain.Warehouse, error)
+	CreateWarehouse(ctx context.Context, warehouse *domain.Warehouse) error
+	UpdateWarehouse(ctx context.Context, id int, update *domain.WarehouseUpdate) (*domain.Warehouse, error)
+	TransferStock(ctx context.Context, transfer *domain.StockTransfer) error
+	GetTransfers(ctx context.Context, limit int) (*[]domain.StockTransfer, error)
+}
+
+type InventoryHandler struct {
+	service InventoryService
+}
+
+func NewInventoryHandler(service InventoryService) *InventoryHandler {
+	return &InventoryHandler{service}
+}
+
+const (
+	defaultLedgerLimit = 50
+	maxLedgerLimit     = 500
+)
+
+// reads the limit of adjustments or transfers to list, writes the error if it's invalid
+func parseLedgerLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultLedgerLimit, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxLedgerLimit {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+func (h *InventoryHandler) GetStock(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getstock request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	levels, err := h.service.GetStock(r.Context(), itemID)
+	if err != nil {
+		log.Printf("error occured in getstock service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with %d stock levels of item with id %d", len(*levels), itemID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levels)
+}
+
+// records a manual adjustment, the delta is added to the stock level
+func (h *InventoryHandler) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	log.Println("received adjuststock request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var adjustment domain.StockAdjustment
+	err = json.NewDecoder(r.Body).Decode(&adjustment)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adjustment.ItemID = itemID
+	adjustment.OrderID = 0
+	adjustment.TransferID = 0
+
+	err = h.service.AdjustStock(r.Context(), &adjustment)
+	switch {
+	case errors.Is(err, domain.ErrInvalidAdjustment), errors.Is(err, domain.ErrVariantRequired),
+		errors.Is(err, domain.ErrVariantNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrItemNotFound), errors.Is(err, domain.ErrWarehouseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, domain.ErrInsufficientStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("error occured in adjuststock service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("adjusted stock of item with id %d by %d", itemID, adjustment.Delta)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(adjustment)
+}
+
+// the inventory ledger of the item, newest first
+func (h *InventoryHandler) GetAdjustments(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getadjustments request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := parseLedgerLimit(w, r)
+	if !ok {
+		return
+	}
+
+	adjustments, err := h.service.GetAdjustments(r.Context(), itemID, limit)
+	if err != nil {
+		log.Printf("error occured in getadjustments service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with %d adjustments of item with id %d", len(*adjustments), itemID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adjustments)
+}
+
+func (h *InventoryHandler) GetWarehouses(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getwarehouses request")
+
+	warehouses, err := h.service.GetWarehouses(r.Context())
+	if err != nil {
+		log.Printf("error occured in getwarehouses service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with %d warehouses", len(*warehouses))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouses)
+}
+
+func (h *InventoryHandler) CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	log.Println("received createwarehouse request")
+
+	warehouse := domain.Warehouse{Active: true}
+	err := json.NewDecoder(r.Body).Decode(&warehouse)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.CreateWarehouse(r.Context(), &warehouse)
+	if errors.Is(err, domain.ErrInvalidWarehouse) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrWarehouseNameTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in createwarehouse service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("created warehouse '%s' with id %d", warehouse.Name, warehouse.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(warehouse)
+}
+
+// changes the fields present in the body
+func (h *InventoryHandler) UpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	log.Println("received updatewarehouse request")
+
+	idStr := chi.URLParam(r, "id")
+	warehouseID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid warehouse ID '%s'", idStr)
+		http.Error(w, "Invalid warehouse ID", http.StatusBadRequest)
+		return
+	}
+
+	var update domain.WarehouseUpdate
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	warehouse, err := h.service.UpdateWarehouse(r.Context(), warehouseID, &update)
+	if errors.Is(err, domain.ErrInvalidWarehouse) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrWarehouseNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrWarehouseNameTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in updatewarehouse service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("updated warehouse with id %d", warehouseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warehouse)
+}
+
+func (h *InventoryHandler) GetWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getwarehousestock request")
+
+	idStr := chi.URLParam(r, "id")
+	warehouseID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid warehouse ID '%s'", idStr)
+		http.Error(w, "Invalid warehouse ID", http.StatusBadRequest)
+		return
+	}
+
+	levels, err := h.service.GetWarehouseStock(r.Context(), warehouseID)
+	if err != nil {
+		log.Printf("error occured in getwarehousestock service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with %d stock levels of warehouse with id %d", len(*levels), warehouseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levels)
+}
+
+func (h *InventoryHandler) TransferStock(w http.ResponseWriter, r *http.Request) {
+	log.Println("received transferstock request")
+
+	var transfer domain.StockTransfer
+	err := json.NewDecoder(r.Body).Decode(&transfer)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.TransferStock(r.Context(), &transfer)
+	switch {
+	case errors.Is(err, domain.ErrInvalidTransfer), errors.Is(err, domain.ErrVariantNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, domain.ErrItemNotFound), errors.Is(err, domain.ErrWarehouseNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, domain.ErrInsufficientStock):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("error occured in transferstock service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("transferred %d of item with id %d from warehouse %d to %d",
+		transfer.Quantity, transfer.ItemID, transfer.FromWarehouseID, transfer.ToWarehouseID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// the latest transfers, newest first
+func (h *InventoryHandler) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	log.Println("received gettransfers request")
+
+	limit, ok := parseLedgerLimit(w, r)
+	if !ok {
+		return
+	}
+
+	transfers, err := h.service.GetTransfers(r.Context(), limit)
+	if err != nil {
+		log.Printf("error occured in gettransfers service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("responded with %d transfers", len(*transfers))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfers)
+}
@@ -3,9 +3,13 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"tefsi/internal/domain"
 
 	"github.com/go-chi/chi"
@@ -14,34 +18,31 @@ import (
 type ItemService interface {
 	CreateItem(ctx context.Context, item *domain.Item) error
 	GetItemByID(ctx context.Context, id int) (*domain.Item, error)
-	GetItems(ctx context.Context, filter *domain.Filter) (*[]domain.Item, error)
+	GetItems(ctx context.Context, query *domain.ItemQuery) (*[]domain.Item, error)
+	GetItemsWithFacets(ctx context.Context, query *domain.ItemQuery) (*domain.ItemPage, error)
+	UpdateItem(ctx context.Context, id int, version int, update *domain.ItemUpdate) (*domain.Item, error)
 	DeleteItem(ctx context.Context, id int) error
+	SuggestItems(ctx context.Context, query *domain.SuggestQuery) (*[]domain.Suggestion, error)
+	SetItemOptions(ctx context.Context, id int, options []domain.ItemOption) (*domain.Item, error)
+	GetVariants(ctx context.Context, itemID int) (*[]domain.Variant, error)
+	CreateVariant(ctx context.Context, variant *domain.Variant) error
+	UpdateVariant(ctx context.Context, variant *domain.Variant) error
+	DeleteVariant(ctx context.Context, itemID int, id int) error
 }
 
 type ItemHandler struct {
 	service ItemService
-	auth    Auth
 }
 
-func NewItemHandler(service ItemService, auth Auth) *ItemHandler {
-	return &ItemHandler{service, auth}
+func NewItemHandler(service ItemService) *ItemHandler {
+	return &ItemHandler{service}
 }
 
 func (h *ItemHandler) CreateItem(w http.ResponseWriter, r *http.Request) {
 	log.Println("received createitem request")
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	var item domain.Item
-	err = json.NewDecoder(r.Body).Decode(&item)
+	err := json.NewDecoder(r.Body).Decode(&item)
 	if err != nil {
 		log.Printf("bad json received, %s", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -71,6 +72,10 @@ func (h *ItemHandler) GetItemByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	item, err := h.service.GetItemByID(r.Context(), itemID)
+	if errors.Is(err, domain.ErrItemNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in getitembyid service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -78,32 +83,124 @@ func (h *ItemHandler) GetItemByID(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("responded with item '%s' with id %d", item.Title, itemID)
 
+	w.Header().Set("ETag", itemETag(item))
+	if r.Header.Get("If-None-Match") == itemETag(item) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(item)
 }
 
+// replaces all fields of the item, the If-Match header has to be the ETag of the item
+// as it was read so concurrent edits don't overwrite each other
+func (h *ItemHandler) PutItem(w http.ResponseWriter, r *http.Request) {
+	log.Println("received putitem request")
+
+	var item domain.Item
+	err := json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.updateItem(w, r, domain.ReplaceItem(&item))
+}
+
+// changes only the fields in the body, the If-Match header works as for PutItem
+func (h *ItemHandler) PatchItem(w http.ResponseWriter, r *http.Request) {
+	log.Println("received patchitem request")
+
+	var update domain.ItemUpdate
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.updateItem(w, r, &update)
+}
+
+func (h *ItemHandler) updateItem(w http.ResponseWriter, r *http.Request, update *domain.ItemUpdate) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header with the ETag of the item is required", http.StatusPreconditionRequired)
+		return
+	}
+	// only a single strong ETag can match, anything else never does
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(ifMatch, `"`), `"`))
+	if err != nil || ifMatch != fmt.Sprintf(`"%d"`, version) {
+		http.Error(w, domain.ErrVersionConflict.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	item, err := h.service.UpdateItem(r.Context(), itemID, version, update)
+	if errors.Is(err, domain.ErrInvalidItem) || errors.Is(err, domain.ErrCategoryNotFound) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrItemNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrVersionConflict) {
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in updateitem service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("updated item %d to version %d", item.ID, item.Version)
+
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func itemETag(item *domain.Item) string {
+	return fmt.Sprintf(`"%d"`, item.Version)
+}
+
+// query parameters: search, category (repeated or comma separated), subcategories (true to include
+// items of the subcategories of category), min_price, max_price,
+// attr.<name> (repeated or comma separated), sort (price, -price, title, -title, newest, relevance),
+// limit, offset and cursor.
+// the cursor of the next page is sent in the X-Next-Cursor header.
+// with facets=true the items are sent as an ItemPage with the facets of the filters
 func (h *ItemHandler) GetItems(w http.ResponseWriter, r *http.Request) {
 	log.Println("received getitems request")
-	categoryIDString := chi.URLParam(r, "category")
-	var categoryID int
-	if categoryIDString != "" {
-		var err error
-		categoryID, err = strconv.Atoi(categoryIDString)
+	query, err := parseItemQuery(r)
+	if err != nil {
+		log.Printf("got invalid item query: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	if facets := r.URL.Query().Get("facets"); facets != "" {
+		withFacets, err := strconv.ParseBool(facets)
 		if err != nil {
-			log.Printf("got invalid category ID '%s'", categoryIDString)
-			http.Error(w, "Invalid category ID", http.StatusBadRequest)
+			http.Error(w, "invalid facets", http.StatusBadRequest)
+			return
+		}
+		if withFacets {
+			h.getItemsWithFacets(w, r, query)
 			return
 		}
-	} else {
-		categoryID = 0
 	}
 
-	filter := domain.Filter{
-		CategoryID: categoryID,
+	itemList, err := h.service.GetItems(r.Context(), query)
+	if errors.Is(err, domain.ErrInvalidItemQuery) || errors.Is(err, domain.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	itemList, err := h.service.GetItems(r.Context(), &filter)
 	if err != nil {
 		log.Printf("error occured in getitems service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -111,23 +208,160 @@ func (h *ItemHandler) GetItems(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("responded with list of items")
 
+	if cursor := query.NextCursor(*itemList); cursor != "" {
+		w.Header().Set("X-Next-Cursor", cursor)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(*itemList)
 }
 
-func (h *ItemHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
-	log.Println("received deleteitem request")
-
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+func (h *ItemHandler) getItemsWithFacets(w http.ResponseWriter, r *http.Request, query *domain.ItemQuery) {
+	page, err := h.service.GetItemsWithFacets(r.Context(), query)
+	if errors.Is(err, domain.ErrInvalidItemQuery) || errors.Is(err, domain.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
+		log.Printf("error occured in getitemswithfacets service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("responded with page of items and facets")
+
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+const (
+	defaultItemLimit    = 50
+	maxItemLimit        = 100
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+)
+
+// query parameters: q, what the user has typed so far, and limit
+func (h *ItemHandler) SuggestItems(w http.ResponseWriter, r *http.Request) {
+	log.Println("received suggestitems request")
+	query := &domain.SuggestQuery{Query: r.URL.Query().Get("q"), Limit: defaultSuggestLimit}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit > maxSuggestLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	suggestions, err := h.service.SuggestItems(r.Context(), query)
+	if errors.Is(err, domain.ErrInvalidItemQuery) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
+	if err != nil {
+		log.Printf("error occured in suggestitems service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// the same prefixes are typed over and over
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(*suggestions)
+}
+
+func parseItemQuery(r *http.Request) (*domain.ItemQuery, error) {
+	values := r.URL.Query()
+	query := &domain.ItemQuery{
+		Search: strings.TrimSpace(values.Get("search")),
+		Sort:   values.Get("sort"),
+		Limit:  defaultItemLimit,
+	}
+
+	for _, param := range values["category"] {
+		for _, idStr := range strings.Split(param, ",") {
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid category ID '%s'", idStr)
+			}
+			query.CategoryIDs = append(query.CategoryIDs, id)
+		}
+	}
+
+	for param, attrValues := range values {
+		name, ok := strings.CutPrefix(param, "attr.")
+		if !ok {
+			continue
+		}
+		if query.Attributes == nil {
+			query.Attributes = map[string][]string{}
+		}
+		for _, value := range attrValues {
+			query.Attributes[name] = append(query.Attributes[name], strings.Split(value, ",")...)
+		}
+	}
+
+	if subcategories := values.Get("subcategories"); subcategories != "" {
+		var err error
+		query.IncludeSubcategories, err = strconv.ParseBool(subcategories)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subcategories '%s'", subcategories)
+		}
+	}
+
+	var err error
+	if query.MinPrice, err = optionalInt(values, "min_price"); err != nil {
+		return nil, err
+	}
+	if query.MaxPrice, err = optionalInt(values, "max_price"); err != nil {
+		return nil, err
+	}
+
+	limit, err := optionalInt(values, "limit")
+	if err != nil {
+		return nil, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > maxItemLimit {
+			return nil, fmt.Errorf("invalid limit, must be between 1 and %d", maxItemLimit)
+		}
+		query.Limit = *limit
+	}
+	offset, err := optionalInt(values, "offset")
+	if err != nil {
+		return nil, err
+	}
+	if offset != nil {
+		query.Offset = *offset
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		query.Cursor, err = domain.ParseItemCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return query, nil
+}
+
+// returns nil if the parameter isn't set
+func optionalInt(values url.Values, key string) (*int, error) {
+	str := values.Get(key)
+	if str == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s'", key, str)
+	}
+	return &value, nil
+}
+
+func (h *ItemHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	log.Println("received deleteitem request")
+
 	idStr := chi.URLParam(r, "id")
 	itemID, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -137,6 +371,10 @@ func (h *ItemHandler) DeleteItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.service.DeleteItem(r.Context(), itemID)
+	if errors.Is(err, domain.ErrItemInUse) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in deleteitem service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
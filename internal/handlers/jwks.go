@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"tefsi/internal/domain"
+)
+
+type KeySet interface {
+	JWKS() domain.JWKS
+}
+
+type JWKSHandler struct {
+	keys KeySet
+}
+
+func NewJWKSHandler(keys KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+func (h *JWKSHandler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getjwks request")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.keys.JWKS())
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
+)
+
+// second login step for users with mfa, exchanges the challenge token and a code for tokens
+func (h *UserHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	log.Println("received loginmfa request")
+	var request domain.MFALoginRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	login, err := h.auth.ParseMFAChallenge(request.MFAToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	user, err := h.service.GetUserByLogin(r.Context(), login)
+	if err != nil {
+		log.Printf("error occured in getuserbylogin service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = user.CanLogIn()
+	if err != nil {
+		log.Printf("user with id %d can't log in: %s", user.ID, err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// wrong codes count as failed logins, otherwise codes could be guessed within the challenge
+	attempt, ok := h.checkLimiter(w, r, login, clientIP(r))
+	if !ok {
+		return
+	}
+	err = h.service.VerifyMFA(r.Context(), user.ID, &request.MFACode)
+	if errors.Is(err, domain.ErrInvalidMFACode) || errors.Is(err, domain.ErrMFANotEnrolled) {
+		log.Printf("mfa failed for user with id %d: %s", user.ID, err.Error())
+		h.recordFailure(r, attempt)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	h.releaseAttempt(r, attempt)
+	if err != nil {
+		log.Printf("error occured in verifymfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.resetFailures(r, login)
+	tokens, err := h.auth.IssueTokens(r.Context(), user, sessionInfo(r))
+	if err != nil {
+		log.Printf("couldn't issue tokens: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("user with id %d logged in with mfa", user.ID)
+	h.mergeGuestCart(r, user.ID)
+
+	w.Header().Add("Authorization", "Bearer "+tokens.Token)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// creates a totp secret for the current user
+func (h *UserHandler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	log.Println("received enrollmfa request")
+	user := middleware.UserFromContext(r.Context())
+
+	enrollment, err := h.service.EnrollMFA(r.Context(), user)
+	if errors.Is(err, domain.ErrMFAAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in enrollmfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// enables mfa for the current user and responds with their recovery codes
+func (h *UserHandler) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	log.Println("received confirmmfa request")
+	user := middleware.UserFromContext(r.Context())
+	var request domain.MFACode
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.service.ConfirmMFA(r.Context(), user.ID, request.Code)
+	if errors.Is(err, domain.ErrInvalidMFACode) || errors.Is(err, domain.ErrMFANotEnrolled) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrMFAAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in confirmmfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("enabled mfa for user with id %d", user.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(codes)
+}
+
+// turns mfa off for the current user, they have to prove they still have a second factor
+func (h *UserHandler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	log.Println("received disablemfa request")
+	user := middleware.UserFromContext(r.Context())
+	var request domain.MFACode
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// throttled like LoginMFA, a stolen access token mustn't be enough to guess the code
+	attempt, ok := h.checkLimiter(w, r, user.Login, clientIP(r))
+	if !ok {
+		return
+	}
+	err = h.service.VerifyMFA(r.Context(), user.ID, &request)
+	if errors.Is(err, domain.ErrInvalidMFACode) || errors.Is(err, domain.ErrMFANotEnrolled) {
+		log.Printf("mfa failed for user with id %d: %s", user.ID, err.Error())
+		h.recordFailure(r, attempt)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.releaseAttempt(r, attempt)
+	if err != nil {
+		log.Printf("error occured in verifymfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.resetFailures(r, user.Login)
+
+	err = h.service.DisableMFA(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error occured in disablemfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("disabled mfa for user with id %d", user.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removes mfa of a user who lost their authenticator and recovery codes
+func (h *UserHandler) ResetMFA(w http.ResponseWriter, r *http.Request) {
+	log.Println("received resetmfa request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.DisableMFA(r.Context(), userID)
+	if err != nil {
+		log.Printf("error occured in disablemfa service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// whoever had the old factor shouldn't stay logged in
+	err = h.auth.RevokeUserTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("couldn't revoke tokens of user with id %d: %s", userID, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("reset mfa for user with id %d", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
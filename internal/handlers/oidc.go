@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+)
+
+type OIDCService interface {
+	StartLogin(ctx context.Context, providerName string) (string, error)
+	FinishLogin(ctx context.Context, providerName string, state string, code string) (*domain.User, error)
+}
+
+// redirects the user to the identity provider
+func (h *UserHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	log.Println("received oidclogin request")
+	provider := chi.URLParam(r, "provider")
+
+	redirectURL, err := h.oidc.StartLogin(r.Context(), provider)
+	if errors.Is(err, domain.ErrUnknownProvider) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in startlogin service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// the identity provider sends the user back here, responds like Login
+func (h *UserHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	log.Println("received oidccallback request")
+	provider := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("%s login failed: %s %s", provider, providerErr, query.Get("error_description"))
+		http.Error(w, "login failed: "+providerErr, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.oidc.FinishLogin(r.Context(), provider, query.Get("state"), query.Get("code"))
+	if errors.Is(err, domain.ErrUnknownProvider) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrInvalidOIDCState) || errors.Is(err, domain.ErrInvalidIDToken) {
+		log.Printf("%s login failed: %s", provider, err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, domain.ErrLoginToLink) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in finishlogin service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
@@ -3,10 +3,12 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
 
 	"github.com/go-chi/chi"
 )
@@ -18,15 +20,17 @@ type OrderService interface {
 	GetOrders(ctx context.Context) (*[]domain.Order, error)
 	DeleteOrder(ctx context.Context, id int) error
 	GetOrdersByUserID(ctx context.Context, id int) (*[]domain.Order, error)
+	CancelOrder(ctx context.Context, id int) error
 }
 
 type OrderHandler struct {
 	service OrderService
-	auth    Auth
+	// unverified users can't place orders for themselves if set
+	requireVerifiedEmail bool
 }
 
-func NewOrderHandler(service OrderService, auth Auth) *OrderHandler {
-	return &OrderHandler{service, auth}
+func NewOrderHandler(service OrderService, requireVerifiedEmail bool) *OrderHandler {
+	return &OrderHandler{service: service, requireVerifiedEmail: requireVerifiedEmail}
 }
 
 func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
@@ -39,8 +43,50 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// new orders always start in progress, the status is changed with updateorder
+	order.StatusID = domain.StatusInProgress
+
+	requestUser := middleware.UserFromContext(r.Context())
+	if requestUser == nil {
+		// guest order, it carries its own contact email and addresses
+		order.UserID = 0
+	} else {
+		// only order managers can place orders on behalf of other users
+		if !requestUser.HasPermission(domain.PermissionOrdersManage) || order.UserID == 0 {
+			order.UserID = requestUser.ID
+		}
+		// api keys don't stand for a user, they have to say who the order is for
+		if order.UserID == 0 {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+		if order.UserID == requestUser.ID {
+			if h.requireVerifiedEmail && !requestUser.EmailVerified {
+				http.Error(w, domain.ErrEmailNotVerified.Error(), http.StatusForbidden)
+				return
+			}
+			if order.ContactEmail == "" {
+				order.ContactEmail = requestUser.Email
+			}
+		}
+	}
 
 	err = h.service.CreateOrder(r.Context(), &order)
+	var shortage *domain.StockShortageError
+	if errors.As(err, &shortage) {
+		log.Printf("order is out of stock: %s", err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(stockShortageResponse{Error: domain.ErrInsufficientStock.Error(), Lines: shortage.Lines})
+		return
+	}
+	if errors.Is(err, domain.ErrInvalidAmount) || errors.Is(err, domain.ErrItemNotFound) || errors.Is(err, domain.ErrAddressNotFound) || errors.Is(err, domain.ErrInvalidAddress) ||
+		errors.Is(err, domain.ErrInvalidPhone) || errors.Is(err, domain.ErrInvalidEmail) ||
+		errors.Is(err, domain.ErrVariantRequired) || errors.Is(err, domain.ErrVariantNotFound) ||
+		errors.Is(err, domain.ErrVariantUnavailable) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in createorder service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -48,7 +94,9 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("created order with id %d", order.ID)
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
 }
 
 func (h *OrderHandler) GetOrderByID(w http.ResponseWriter, r *http.Request) {
@@ -63,19 +111,20 @@ func (h *OrderHandler) GetOrderByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	order, err := h.service.GetOrderByID(r.Context(), orderID)
+	if errors.Is(err, domain.ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in getorderbyid service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) && requestUser.ID != order.UserID {
-		w.WriteHeader(http.StatusForbidden)
+	requestUser := middleware.UserFromContext(r.Context())
+	// guest orders and orders of other users need orders:read
+	if !requestUser.HasPermission(domain.PermissionOrdersRead) && (order.UserID == 0 || requestUser.ID != order.UserID) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 		return
 	}
 
@@ -85,18 +134,62 @@ func (h *OrderHandler) GetOrderByID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(order)
 }
 
-func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
-	log.Println("received getorders request")
+// the body of the 409 response to an order that is out of stock
+type stockShortageResponse struct {
+	Error string                 `json:"error"`
+	Lines []domain.StockShortage `json:"lines"`
+}
+
+// cancels an order in progress, customers can cancel their own orders
+func (h *OrderHandler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	log.Println("received cancelorder request")
+	idStr := chi.URLParam(r, "id")
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+	orderID, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("got invalid order ID '%s'", idStr)
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.service.GetOrderByID(r.Context(), orderID)
+	if errors.Is(err, domain.ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in getorderbyid service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	requestUser := middleware.UserFromContext(r.Context())
+	if !requestUser.HasPermission(domain.PermissionOrdersManage) && (order.UserID == 0 || requestUser.ID != order.UserID) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	err = h.service.CancelOrder(r.Context(), orderID)
+	if errors.Is(err, domain.ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
+	if errors.Is(err, domain.ErrOrderNotCancellable) {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
+	if err != nil {
+		log.Printf("error occured in cancelorder service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("cancelled order with id %d", orderID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getorders request")
 
 	orderList, err := h.service.GetOrders(r.Context())
 	if err != nil {
@@ -113,18 +206,8 @@ func (h *OrderHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 func (h *OrderHandler) UpdateOrders(w http.ResponseWriter, r *http.Request) {
 	log.Println("received updateorders request")
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	var order domain.Order
-	err = json.NewDecoder(r.Body).Decode(&order)
+	err := json.NewDecoder(r.Body).Decode(&order)
 	if err != nil {
 		log.Printf("bad json received: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -132,6 +215,21 @@ func (h *OrderHandler) UpdateOrders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = h.service.UpdateOrder(r.Context(), &order)
+	var shortage *domain.StockShortageError
+	if errors.As(err, &shortage) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(stockShortageResponse{Error: domain.ErrInsufficientStock.Error(), Lines: shortage.Lines})
+		return
+	}
+	if errors.Is(err, domain.ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrOrderCancelled) || errors.Is(err, domain.ErrOrderNotCancellable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Println("error occured in updateorder service")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -145,16 +243,6 @@ func (h *OrderHandler) UpdateOrders(w http.ResponseWriter, r *http.Request) {
 func (h *OrderHandler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 	log.Println("received deleteorder request")
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	idStr := chi.URLParam(r, "id")
 	orderID, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -184,16 +272,6 @@ func (h *OrderHandler) GetOrdersByUserID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) && requestUser.ID != userID {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	orderList, err := h.service.GetOrdersByUserID(r.Context(), userID)
 	if err != nil {
 		log.Printf("error occured in getordersbyuserid service: %s", err.Error())
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+)
+
+func sessionInfo(r *http.Request) *domain.SessionInfo {
+	return &domain.SessionInfo{UserAgent: r.UserAgent(), IP: clientIP(r)}
+}
+
+func (h *UserHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getsessions request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := h.auth.GetSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("error occured while getting sessions: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	log.Println("received revokesession request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	sessionID := chi.URLParam(r, "sid")
+
+	err = h.auth.RevokeSession(r.Context(), userID, sessionID)
+	if errors.Is(err, domain.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured while revoking session: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("revoked session %s of user with id %d", sessionID, userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,15 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/golang-jwt/jwt/v5"
 
 	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
 )
 
 type UserService interface {
@@ -23,16 +27,52 @@ type UserService interface {
 	UserExists(ctx context.Context, login string) error
 	UserIsAdmin(ctx context.Context, login string) (bool, error)
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	AssignRole(ctx context.Context, userID int, role string) error
+	RemoveRole(ctx context.Context, userID int, role string) error
+	GetRoles(ctx context.Context) (*[]domain.Role, error)
+	CreateRole(ctx context.Context, role *domain.Role) error
+	GetUsers(ctx context.Context, query *domain.UserQuery) (*domain.UserList, error)
+	PromoteUser(ctx context.Context, id int) error
+	DemoteUser(ctx context.Context, id int) error
+	SetUserDisabled(ctx context.Context, id int, disabled bool) error
+	SetPasswordResetRequired(ctx context.Context, id int, required bool) error
+	ChangePassword(ctx context.Context, change *domain.PasswordChange) (*domain.User, error)
+	RequestPasswordReset(ctx context.Context, request *domain.ForgotPasswordRequest) error
+	ResetPassword(ctx context.Context, token string, newPassword string) (int, error)
+	SendEmailVerification(ctx context.Context, user *domain.User) error
+	VerifyEmail(ctx context.Context, token string) (int, error)
+	EnrollMFA(ctx context.Context, user *domain.User) (*domain.MFAEnrollment, error)
+	ConfirmMFA(ctx context.Context, userID int, code string) (*domain.MFARecoveryCodes, error)
+	VerifyMFA(ctx context.Context, userID int, code *domain.MFACode) error
+	DisableMFA(ctx context.Context, userID int) error
+	UpdateUser(ctx context.Context, id int, update *domain.UserUpdate) (*domain.User, error)
+	CreateAddress(ctx context.Context, address *domain.Address) error
+	GetAddresses(ctx context.Context, userID int) (*[]domain.Address, error)
+	UpdateAddress(ctx context.Context, address *domain.Address) error
+	DeleteAddress(ctx context.Context, userID int, id int) error
+	SetUserCartItem(ctx context.Context, userID int, item *domain.ItemWithAmount) error
+	GetGuestCart(ctx context.Context, cartID string) (*[]domain.ItemWithAmount, error)
+	SetGuestCartItem(ctx context.Context, cartID string, item *domain.ItemWithAmount) (*domain.GuestCart, error)
+	MergeGuestCart(ctx context.Context, cartID string, userID int) error
+}
+
+type LoginLimiter interface {
+	Attempt(ctx context.Context, login string, ip string) (*domain.PendingLogin, time.Duration, error)
+	Fail(ctx context.Context, attempt *domain.PendingLogin) error
+	Release(ctx context.Context, attempt *domain.PendingLogin) error
+	Succeed(ctx context.Context, login string) error
 }
 
 // Обработчики HTTP запросов
 type UserHandler struct {
 	service UserService
+	oidc    OIDCService
 	auth    Auth
+	limiter LoginLimiter
 }
 
-func NewUserHandler(service UserService, auth Auth) *UserHandler {
-	return &UserHandler{service: service, auth: auth}
+func NewUserHandler(service UserService, oidc OIDCService, auth Auth, limiter LoginLimiter) *UserHandler {
+	return &UserHandler{service: service, oidc: oidc, auth: auth, limiter: limiter}
 }
 
 func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
@@ -47,16 +87,6 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if !(requestUser.IsAdmin) && requestUser.ID != userID {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
 	log.Printf("getting user %d", userID)
 	user, err := h.service.GetUserByID(r.Context(), userID)
 	if err != nil {
@@ -72,15 +102,21 @@ func (h *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
 
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	log.Println("received createuser request")
-	var user domain.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	// signup only takes credentials, roles are granted by admins
+	var credentials domain.Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
 	if err != nil {
 		log.Printf("bad json received: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	log.Printf("creating user with login = %s, password = %s, is_admin = %v", user.Login, user.Password, user.IsAdmin)
+	log.Printf("creating user with login = %s", credentials.Login)
+	user := domain.User{Login: credentials.Login, Password: credentials.Password, Email: credentials.Email}
 	err = h.service.CreateUser(r.Context(), &user)
+	if errors.Is(err, domain.ErrInvalidEmail) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("error occured in createuser service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -91,53 +127,183 @@ func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("received updateuser request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var update domain.UserUpdate
+	err = json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.service.UpdateUser(r.Context(), userID, &update)
+	if errors.Is(err, domain.ErrInvalidEmail) || errors.Is(err, domain.ErrInvalidPhone) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in updateuser service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("updated user with id %d", user.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
 func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
-	var user domain.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var credentials domain.Credentials
+	err := json.NewDecoder(r.Body).Decode(&credentials)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// checked before the password so throttled attempts don't cost a password hash comparison
+	attempt, ok := h.checkLimiter(w, r, credentials.Login, clientIP(r))
+	if !ok {
+		return
+	}
+
+	user := domain.User{Login: credentials.Login, Password: credentials.Password}
 	err = h.service.CheckUserByDomain(r.Context(), &user)
 	if err != nil {
+		h.recordFailure(r, attempt)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	h.releaseAttempt(r, attempt)
 
-	payload := jwt.MapClaims{
-		"sub": user.Login,
-		"exp": time.Now().Add(time.Hour * 72).Unix(),
+	loggedIn, err := h.service.GetUserByLogin(r.Context(), user.Login)
+	if err != nil {
+		log.Printf("error occured in getuserbylogin service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
-	t, err := token.SignedString([]byte("some_secret"))
+	h.completeLogin(w, r, loggedIn)
+}
+
+// responds with tokens for a user who proved who they are,
+// or with an mfa challenge if they have a second factor
+func (h *UserHandler) completeLogin(w http.ResponseWriter, r *http.Request, loggedIn *domain.User) {
+	err := loggedIn.CanLogIn()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("user with id %d can't log in: %s", loggedIn.ID, err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
-	w.Header().Add("Authorization", "Bearer "+t)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(domain.LoginResponse{Token: t})
+
+	// users with mfa get a challenge and finish the login in LoginMFA
+	if loggedIn.MFAEnabled {
+		challenge, err := h.auth.IssueMFAChallenge(loggedIn)
+		if err != nil {
+			log.Printf("couldn't issue mfa challenge: %s", err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("user with id %d passed the first login step", loggedIn.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(challenge)
+		return
+	}
+
+	// failures are only forgotten once the login is complete, including the second factor
+	h.resetFailures(r, loggedIn.Login)
+	tokens, err := h.auth.IssueTokens(r.Context(), loggedIn, sessionInfo(r))
+	if err != nil {
+		log.Printf("couldn't issue tokens: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("user with id %d logged in", loggedIn.ID)
+	h.mergeGuestCart(r, loggedIn.ID)
+
+	w.Header().Add("Authorization", "Bearer "+tokens.Token)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
 }
 
-func (h *UserHandler) GetUserCartByID(w http.ResponseWriter, r *http.Request) {
-	log.Println("received getusercartbyid request")
-	idStr := chi.URLParam(r, "id")
+func (h *UserHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	log.Println("received refresh request")
+	var request domain.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	cartID, err := strconv.Atoi(idStr)
+	tokens, err := h.auth.Refresh(r.Context(), request.RefreshToken)
+	if errors.Is(err, domain.ErrInvalidRefreshToken) || errors.Is(err, domain.ErrRefreshTokenReused) {
+		log.Printf("refresh failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, domain.ErrUserDisabled) || errors.Is(err, domain.ErrPasswordResetNeeded) {
+		log.Printf("refresh failed: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
-		log.Printf("got invalid user ID '%s'", idStr)
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		log.Printf("error occured while refreshing tokens: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Println("refreshed tokens")
+
+	w.Header().Add("Authorization", "Bearer "+tokens.Token)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	log.Println("received logout request")
+	// the refresh token is optional, without it only the access token is revoked
+	var request domain.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.auth.Logout(r.Context(), r.Header.Get("Authorization"), request.RefreshToken)
+	if errors.Is(err, domain.ErrInvalidRefreshToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
+		log.Printf("error occured while logging out: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Printf("user with id %d logged out", middleware.UserFromContext(r.Context()).ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	if !(requestUser.IsAdmin) && requestUser.ID != cartID {
-		w.WriteHeader(http.StatusForbidden)
+func (h *UserHandler) GetUserCartByID(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getusercartbyid request")
+	idStr := chi.URLParam(r, "id")
+
+	cartID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
 		return
 	}
 
@@ -164,24 +330,501 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	requestUser, err := h.auth.GetUserFromJWT(r.Header.Get("Authorization"))
+	err = h.service.DeleteUser(r.Context(), userID)
 	if err != nil {
+		log.Printf("error occured in deleteuser service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Printf("deleted user with id %d", userID)
 
-	if !(requestUser.IsAdmin) && requestUser.ID != userID {
-		w.WriteHeader(http.StatusForbidden)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UserHandler) GetRoles(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getroles request")
+	roles, err := h.service.GetRoles(r.Context())
+	if err != nil {
+		log.Printf("error occured in getroles service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	log.Println("responded with list of roles")
 
-	err = h.service.DeleteUser(r.Context(), userID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(*roles)
+}
+
+func (h *UserHandler) CreateRole(w http.ResponseWriter, r *http.Request) {
+	log.Println("received createrole request")
+	var role domain.Role
+	err := json.NewDecoder(r.Body).Decode(&role)
 	if err != nil {
-		log.Printf("error occured in deleteuser service: %s", err.Error())
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.CreateRole(r.Context(), &role)
+	if errors.Is(err, domain.ErrUnknownPermission) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrRoleExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in createrole service: %s", err.Error())
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Printf("deleted user with id %d", userID)
+	log.Printf("created role '%s' with id %d", role.Name, role.ID)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *UserHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	log.Println("received assignrole request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var assignment domain.RoleAssignment
+	err = json.NewDecoder(r.Body).Decode(&assignment)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.AssignRole(r.Context(), userID, assignment.Role)
+	if errors.Is(err, domain.ErrUnknownRole) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in assignrole service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("assigned role '%s' to user with id %d", assignment.Role, userID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UserHandler) RemoveRole(w http.ResponseWriter, r *http.Request) {
+	log.Println("received removerole request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	role := chi.URLParam(r, "role")
+
+	err = h.service.RemoveRole(r.Context(), userID, role)
+	if errors.Is(err, domain.ErrUnknownRole) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in removerole service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("removed role '%s' from user with id %d", role, userID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getusers request")
+	query := domain.UserQuery{
+		Search: r.URL.Query().Get("search"),
+		Limit:  20,
+	}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 100 {
+			log.Printf("got invalid limit '%s'", limitStr)
+			http.Error(w, "Invalid limit, must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			log.Printf("got invalid offset '%s'", offsetStr)
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		query.Offset = offset
+	}
+
+	userList, err := h.service.GetUsers(r.Context(), &query)
+	if err != nil {
+		log.Printf("error occured in getusers service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Println("responded with list of users")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userList)
+}
+
+func (h *UserHandler) PromoteUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("received promoteuser request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.PromoteUser(r.Context(), userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in promoteuser service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("promoted user with id %d to admin", userID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UserHandler) DemoteUser(w http.ResponseWriter, r *http.Request) {
+	log.Println("received demoteuser request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.DemoteUser(r.Context(), userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, domain.ErrLastAdmin) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in demoteuser service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("demoted user with id %d", userID)
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (h *UserHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, true)
+}
+
+func (h *UserHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, false)
+}
+
+func (h *UserHandler) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	log.Printf("received setuserdisabled request, disabled = %v", disabled)
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.SetUserDisabled(r.Context(), userID, disabled)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in setuserdisabled service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if disabled {
+		err = h.auth.RevokeUserTokens(r.Context(), userID)
+		if err != nil {
+			log.Printf("couldn't revoke tokens of user with id %d: %s", userID, err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Printf("set disabled = %v for user with id %d", disabled, userID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// makes the user choose a new password before they can log in again
+func (h *UserHandler) ForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	log.Println("received forcepasswordreset request")
+	idStr := chi.URLParam(r, "id")
+	userID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid user ID '%s'", idStr)
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.SetPasswordResetRequired(r.Context(), userID, true)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in setpasswordresetrequired service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.auth.RevokeUserTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("couldn't revoke tokens of user with id %d: %s", userID, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("forced password reset for user with id %d", userID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	log.Println("received changepassword request")
+	var change domain.PasswordChange
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if change.NewPassword == "" {
+		http.Error(w, "new_password is required", http.StatusBadRequest)
+		return
+	}
+	// the old password is guessed here as easily as in Login, so it shares its throttling
+	attempt, ok := h.checkLimiter(w, r, change.Login, clientIP(r))
+	if !ok {
+		return
+	}
+
+	user, err := h.service.ChangePassword(r.Context(), &change)
+	if errors.Is(err, domain.ErrInvalidCredentials) {
+		h.recordFailure(r, attempt)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.releaseAttempt(r, attempt)
+	if errors.Is(err, domain.ErrUserDisabled) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// sessions started with the old password are ended
+	err = h.auth.RevokeUserTokens(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("couldn't revoke tokens of user with id %d: %s", user.ID, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("changed password of user with id %d", user.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	log.Println("received forgotpassword request")
+	var request domain.ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.RequestPasswordReset(r.Context(), &request)
+	if errors.Is(err, domain.ErrInvalidEmail) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in requestpasswordreset service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// same response whether the user exists or not
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	log.Println("received resetpassword request")
+	var request domain.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("bad json received: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.NewPassword == "" {
+		http.Error(w, "new_password is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.service.ResetPassword(r.Context(), request.Token, request.NewPassword)
+	if errors.Is(err, domain.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in resetpassword service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.auth.RevokeUserTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("couldn't revoke tokens of user with id %d: %s", userID, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("reset password of user with id %d", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirms an email address, the token comes either from the link in the mail (GET)
+// or from a json body (POST)
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	log.Println("received verifyemail request")
+	request := domain.VerifyEmailRequest{Token: r.URL.Query().Get("token")}
+	if r.Method == http.MethodPost {
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			log.Printf("bad json received: %s", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if request.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.service.VerifyEmail(r.Context(), request.Token)
+	if errors.Is(err, domain.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// only reachable with a link from the mailbox, the owner may learn the address is taken
+	if errors.Is(err, domain.ErrEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in verifyemail service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("verified email of user with id %d", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sends another verification link to the current user
+func (h *UserHandler) ResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	log.Println("received resendemailverification request")
+	user := middleware.UserFromContext(r.Context())
+
+	err := h.service.SendEmailVerification(r.Context(), user)
+	if errors.Is(err, domain.ErrInvalidEmail) {
+		http.Error(w, "user has no email address", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("error occured in sendemailverification service: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// counts an attempt for the login and ip, responds with 429 and returns false if they have to wait
+//
+// the attempt counts as failed until it is passed to recordFailure or releaseAttempt
+func (h *UserHandler) checkLimiter(w http.ResponseWriter, r *http.Request, login string, ip string) (*domain.PendingLogin, bool) {
+	attempt, wait, err := h.limiter.Attempt(r.Context(), login, ip)
+	if err != nil {
+		log.Printf("error occured while checking failed logins: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if wait > 0 {
+		log.Printf("throttled login of '%s' from %s for %s", login, ip, wait)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, domain.ErrTooManyAttempts.Error(), http.StatusTooManyRequests)
+		return nil, false
+	}
+	return attempt, true
+}
+
+func (h *UserHandler) recordFailure(r *http.Request, attempt *domain.PendingLogin) {
+	err := h.limiter.Fail(r.Context(), attempt)
+	if err != nil {
+		log.Printf("couldn't record failed login of '%s': %s", attempt.Login, err.Error())
+	}
+}
+
+// takes back an attempt that didn't fail, it neither counts nor resets earlier failures
+func (h *UserHandler) releaseAttempt(r *http.Request, attempt *domain.PendingLogin) {
+	err := h.limiter.Release(r.Context(), attempt)
+	if err != nil {
+		log.Printf("couldn't take back login attempt of '%s': %s", attempt.Login, err.Error())
+	}
+}
+
+func (h *UserHandler) resetFailures(r *http.Request, login string) {
+	err := h.limiter.Succeed(r.Context(), login)
+	if err != nil {
+		log.Printf("couldn't reset failed logins of '%s': %s", login, err.Error())
+	}
+}
+
+// address of the client without the port, proxies in front of tefsi have to set RemoteAddr
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"tefsi/internal/domain"
+
+	"github.com/go-chi/chi"
+)
+
+// replaces the options of the item, the body is the list of options
+func (h *ItemHandler) SetItemOptions(w http.ResponseWriter, r *http.Request) {
+	log.Println("received setitemoptions request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var options []domain.ItemOption
+	err = json.NewDecoder(r.Body).Decode(&options)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item, err := h.service.SetItemOptions(r.Context(), itemID, options)
+	if err != nil {
+		variantError(w, "setitemoptions", err)
+		return
+	}
+	log.Printf("set %d options of item with id %d", len(options), itemID)
+
+	w.Header().Set("ETag", itemETag(item))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+func (h *ItemHandler) GetVariants(w http.ResponseWriter, r *http.Request) {
+	log.Println("received getvariants request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	variants, err := h.service.GetVariants(r.Context(), itemID)
+	if err != nil {
+		variantError(w, "getvariants", err)
+		return
+	}
+	log.Printf("responded with %d variants of item with id %d", len(*variants), itemID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variants)
+}
+
+func (h *ItemHandler) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	log.Println("received createvariant request")
+
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	variant := domain.Variant{Available: true}
+	err = json.NewDecoder(r.Body).Decode(&variant)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	variant.ItemID = itemID
+
+	err = h.service.CreateVariant(r.Context(), &variant)
+	if err != nil {
+		variantError(w, "createvariant", err)
+		return
+	}
+	log.Printf("created variant '%s' with id %d", variant.SKU, variant.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(variant)
+}
+
+// replaces the variant with the body
+func (h *ItemHandler) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	log.Println("received updatevariant request")
+
+	itemID, variantID, ok := parseVariantPath(w, r)
+	if !ok {
+		return
+	}
+
+	var variant domain.Variant
+	err := json.NewDecoder(r.Body).Decode(&variant)
+	if err != nil {
+		log.Printf("bad json received, %s", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	variant.ItemID = itemID
+	variant.ID = variantID
+
+	err = h.service.UpdateVariant(r.Context(), &variant)
+	if err != nil {
+		variantError(w, "updatevariant", err)
+		return
+	}
+	log.Printf("updated variant with id %d", variantID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variant)
+}
+
+func (h *ItemHandler) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	log.Println("received deletevariant request")
+
+	itemID, variantID, ok := parseVariantPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.DeleteVariant(r.Context(), itemID, variantID)
+	if err != nil {
+		variantError(w, "deletevariant", err)
+		return
+	}
+	log.Printf("deleted variant with id %d", variantID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// reads the item and variant IDs from the path, writes the error if they are invalid
+func parseVariantPath(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	idStr := chi.URLParam(r, "id")
+	itemID, err := strconv.Atoi(idStr)
+	if err != nil {
+		log.Printf("got invalid item ID '%s'", idStr)
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	variantIDStr := chi.URLParam(r, "variant_id")
+	variantID, err := strconv.Atoi(variantIDStr)
+	if err != nil {
+		log.Printf("got invalid variant ID '%s'", variantIDStr)
+		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return itemID, variantID, true
+}
+
+func variantError(w http.ResponseWriter, service string, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidVariant):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domain.ErrItemNotFound), errors.Is(err, domain.ErrVariantNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, domain.ErrSKUTaken), errors.Is(err, domain.ErrVariantExists), errors.Is(err, domain.ErrVariantInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("error occured in %s service: %s", service, err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -4,9 +4,17 @@ import (
 	"context"
 	"log"
 	"tefsi/internal/auth"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
 	"tefsi/internal/handlers"
+	"tefsi/internal/mail"
+	"tefsi/internal/middleware"
+	"tefsi/internal/oidc"
+	"tefsi/internal/password"
 	"tefsi/internal/repositories"
 	"tefsi/internal/services"
+	"tefsi/internal/throttle"
+	"time"
 
 	"github.com/go-chi/chi"
 )
@@ -35,89 +43,266 @@ func GetAllTables(db repositories.Pool) (map[string]struct{}, error) {
 	return tables, nil
 }
 
-func InitRepositories(db repositories.Pool, allTables map[string]struct{}) (*repositories.AllRepositories, error) {
+func InitRepositories(db repositories.Pool, allTables map[string]struct{}, cfg *config.Config) (*repositories.AllRepositories, error) {
+	hasher, err := password.New(cfg.Password)
+	if err != nil {
+		return nil, err
+	}
+
 	categoryRepo, err := repositories.NewCategoryRepository(db, &allTables)
 	if err != nil {
 		return nil, err
 	}
 
-	itemRepo, err := repositories.NewItemRepository(db, &allTables)
+	itemRepo, err := repositories.NewItemRepository(db, &allTables, cfg.SearchLanguage)
 	if err != nil {
 		return nil, err
 	}
 
-	userRepo, err := repositories.NewUserRepository(db, &allTables)
+	userRepo, err := repositories.NewUserRepository(db, &allTables, hasher)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	tokenRepo, err := repositories.NewTokenRepository(db, &allTables)
+	if err != nil {
+		return nil, err
+	}
+
 	orderRepo, err := repositories.NewOrderRepository(db, &allTables)
 	if err != nil {
 		return nil, err
 	}
 
+	inventoryRepo, err := repositories.NewInventoryRepository(db, &allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	loginAttemptRepo, err := repositories.NewLoginAttemptRepository(db, &allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeyRepo, err := repositories.NewAPIKeyRepository(db, &allTables)
+	if err != nil {
+		return nil, err
+	}
+
 	return &repositories.AllRepositories{
-		UserRepository:     userRepo,
-		ItemRepository:     itemRepo,
-		OrderRepository:    orderRepo,
-		CategoryRepository: categoryRepo,
+		UserRepository:         userRepo,
+		ItemRepository:         itemRepo,
+		OrderRepository:        orderRepo,
+		InventoryRepository:    inventoryRepo,
+		CategoryRepository:     categoryRepo,
+		TokenRepository:        tokenRepo,
+		LoginAttemptRepository: loginAttemptRepo,
+		APIKeyRepository:       apiKeyRepo,
 	}, nil
 }
 
-func InitServices(allRepos *repositories.AllRepositories) *services.AllServices {
-	authService := services.NewDefaultAuthService(allRepos.UserRepository)
+func InitServices(allRepos *repositories.AllRepositories, cfg *config.Config) (*services.AllServices, error) {
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		return nil, err
+	}
+
+	authService := services.NewDefaultAuthService(allRepos.UserRepository, allRepos.TokenRepository, allRepos.APIKeyRepository)
 	categoryService := services.NewDefaultCategoryService(allRepos.CategoryRepository)
-	userService := services.NewDefaultUserService(allRepos.UserRepository)
-	itemService := services.NewDefaultItemService(allRepos.ItemRepository)
-	orderService := services.NewDefaultOrderService(allRepos.OrderRepository)
+	userService := services.NewDefaultUserService(allRepos.UserRepository, mailer, cfg)
+	itemService := services.NewDefaultItemService(allRepos.ItemRepository, cfg.SuggestTimeout)
+	orderService := services.NewDefaultOrderService(allRepos.OrderRepository, allRepos.UserRepository)
+
+	providers := []services.OIDCProvider{}
+	for _, providerCfg := range cfg.OIDC {
+		providers = append(providers, oidc.NewProvider(providerCfg, nil))
+	}
+	oidcService := services.NewDefaultOIDCService(allRepos.UserRepository, allRepos.TokenRepository, providers)
+	apiKeyService := services.NewDefaultAPIKeyService(allRepos.APIKeyRepository)
+	inventoryService := services.NewDefaultInventoryService(allRepos.InventoryRepository)
 
 	return &services.AllServices{
-		AuthService:     authService,
-		UserService:     userService,
-		ItemService:     itemService,
-		OrderService:    orderService,
-		CategoryService: categoryService,
+		AuthService:      authService,
+		UserService:      userService,
+		ItemService:      itemService,
+		OrderService:     orderService,
+		CategoryService:  categoryService,
+		OIDCService:      oidcService,
+		APIKeyService:    apiKeyService,
+		InventoryService: inventoryService,
+	}, nil
+}
+
+func InitAdmin(allServices *services.AllServices, cfg *config.Config) error {
+	if cfg.AdminLogin == "" || cfg.AdminPassword == "" {
+		return nil
+	}
+	return allServices.UserService.EnsureAdmin(context.Background(), cfg.AdminLogin, cfg.AdminPassword)
+}
+
+// runs the periodic cleanups in the background for as long as the server runs
+func StartJobs(allServices *services.AllServices, limiter *throttle.Limiter, cfg *config.Config) {
+	go func() {
+		ticker := time.NewTicker(cfg.GuestCartCleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			err := allServices.UserService.DeleteStaleGuestCarts(context.Background())
+			if err != nil {
+				log.Printf("can't delete stale guest carts: %v", err)
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(cfg.LoginThrottle.CleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, err := limiter.DeleteExpired(context.Background())
+			if err != nil {
+				log.Printf("can't delete expired login attempts: %v", err)
+			}
+		}
+	}()
+}
+
+func InitAuth(allServices *services.AllServices, cfg *config.Config) (*auth.Auth, error) {
+	keys, err := auth.NewKeyManager(cfg.JWT)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewAuth(allServices.AuthService, keys, cfg.JWT, cfg.MFA), nil
+}
+
+func InitLimiter(allRepos *repositories.AllRepositories, cfg *config.Config) (*throttle.Limiter, error) {
+	store, err := throttle.NewStore(cfg.LoginThrottle, allRepos.LoginAttemptRepository)
+	if err != nil {
+		return nil, err
 	}
+	return throttle.NewLimiter(store, cfg.LoginThrottle), nil
 }
 
-func InitHandlers(allServices *services.AllServices) *handlers.AllHandlers {
-	auth := auth.NewAuth(allServices.AuthService)
-	categoryHandler := handlers.NewCategoryHandler(allServices.CategoryService, auth)
-	userHandler := handlers.NewUserHandler(allServices.UserService, auth)
-	itemHandler := handlers.NewItemHandler(allServices.ItemService, auth)
-	orderHandler := handlers.NewOrderHandler(allServices.OrderService, auth)
+func InitHandlers(
+	allServices *services.AllServices, auth *auth.Auth, limiter *throttle.Limiter, cfg *config.Config,
+) *handlers.AllHandlers {
+	categoryHandler := handlers.NewCategoryHandler(allServices.CategoryService)
+	userHandler := handlers.NewUserHandler(allServices.UserService, allServices.OIDCService, auth, limiter)
+	itemHandler := handlers.NewItemHandler(allServices.ItemService)
+	orderHandler := handlers.NewOrderHandler(allServices.OrderService, cfg.RequireVerifiedEmailForOrders)
+	jwksHandler := handlers.NewJWKSHandler(auth)
+	apiKeyHandler := handlers.NewAPIKeyHandler(allServices.APIKeyService)
+	inventoryHandler := handlers.NewInventoryHandler(allServices.InventoryService)
 
 	return &handlers.AllHandlers{
-		UserHandler:     userHandler,
-		ItemHandler:     itemHandler,
-		OrderHandler:    orderHandler,
-		CategoryHandler: categoryHandler,
+		UserHandler:      userHandler,
+		ItemHandler:      itemHandler,
+		OrderHandler:     orderHandler,
+		CategoryHandler:  categoryHandler,
+		JWKSHandler:      jwksHandler,
+		APIKeyHandler:    apiKeyHandler,
+		InventoryHandler: inventoryHandler,
 	}
 }
 
-func InitRouter(allHandlers *handlers.AllHandlers) chi.Router {
+func InitMiddleware(auth *auth.Auth) *middleware.Middleware {
+	return middleware.New(auth)
+}
+
+func InitRouter(allHandlers *handlers.AllHandlers, mw *middleware.Middleware) chi.Router {
 	r := chi.NewRouter()
 
+	catalogWrite := mw.RequirePermission(domain.PermissionCatalogWrite)
+	usersManage := mw.RequirePermission(domain.PermissionUsersManage)
+	apiKeysManage := mw.RequirePermission(domain.PermissionAPIKeysManage)
+	inventoryManage := mw.RequirePermission(domain.PermissionInventoryManage)
+
+	r.Get("/.well-known/jwks.json", allHandlers.JWKSHandler.GetJWKS)
+
 	r.Get("/category/{id}", allHandlers.CategoryHandler.GetCategoryByID)
-	r.Post("/category", allHandlers.CategoryHandler.CreateCategory)
+	r.With(catalogWrite).Post("/category", allHandlers.CategoryHandler.CreateCategory)
 	r.Get("/category/list", allHandlers.CategoryHandler.GetCategories)
-	r.Delete("/category/delete/{id}", allHandlers.CategoryHandler.DeleteCategory)
+	r.Get("/category/tree", allHandlers.CategoryHandler.GetCategoryTree)
+	r.Get("/category/by-slug/{slug}", allHandlers.CategoryHandler.GetCategoryBySlug)
+	r.With(catalogWrite).Patch("/category/{id}", allHandlers.CategoryHandler.UpdateCategory)
+	r.With(catalogWrite).Put("/category/{id}/parent", allHandlers.CategoryHandler.MoveCategory)
+	r.With(catalogWrite).Delete("/category/delete/{id}", allHandlers.CategoryHandler.DeleteCategory)
 
 	r.Get("/item/{id}", allHandlers.ItemHandler.GetItemByID)
-	r.Post("/item", allHandlers.ItemHandler.CreateItem)
+	r.With(catalogWrite).Post("/item", allHandlers.ItemHandler.CreateItem)
+	r.With(catalogWrite).Put("/item/{id}", allHandlers.ItemHandler.PutItem)
+	r.With(catalogWrite).Patch("/item/{id}", allHandlers.ItemHandler.PatchItem)
 	r.Get("/item/list", allHandlers.ItemHandler.GetItems)
-	r.Delete("/item/delete/{id}", allHandlers.ItemHandler.DeleteItem)
+	r.Get("/item/suggest", allHandlers.ItemHandler.SuggestItems)
+	r.With(catalogWrite).Delete("/item/delete/{id}", allHandlers.ItemHandler.DeleteItem)
+	r.With(catalogWrite).Put("/item/{id}/options", allHandlers.ItemHandler.SetItemOptions)
+	r.Get("/item/{id}/variants", allHandlers.ItemHandler.GetVariants)
+	r.With(catalogWrite).Post("/item/{id}/variants", allHandlers.ItemHandler.CreateVariant)
+	r.With(catalogWrite).Put("/item/{id}/variants/{variant_id}", allHandlers.ItemHandler.UpdateVariant)
+	r.With(catalogWrite).Delete("/item/{id}/variants/{variant_id}", allHandlers.ItemHandler.DeleteVariant)
+	r.With(inventoryManage).Get("/item/{id}/stock", allHandlers.InventoryHandler.GetStock)
+	r.With(inventoryManage).Get("/item/{id}/stock/adjustments", allHandlers.InventoryHandler.GetAdjustments)
+	r.With(inventoryManage).Post("/item/{id}/stock/adjustments", allHandlers.InventoryHandler.AdjustStock)
 
-	r.Get("/users/{id}", allHandlers.UserHandler.GetUserByID)
+	r.With(usersManage).Get("/users", allHandlers.UserHandler.GetUsers)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Get("/users/{id}", allHandlers.UserHandler.GetUserByID)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Patch("/users/{id}", allHandlers.UserHandler.UpdateUser)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Get("/users/{id}/cart", allHandlers.UserHandler.GetUserCartByID)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Get("/users/{id}/addresses", allHandlers.UserHandler.GetAddresses)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Post("/users/{id}/addresses", allHandlers.UserHandler.CreateAddress)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Put("/users/{id}/addresses/{address_id}", allHandlers.UserHandler.UpdateAddress)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Delete("/users/{id}/addresses/{address_id}", allHandlers.UserHandler.DeleteAddress)
 	r.Post("/users", allHandlers.UserHandler.CreateUser)
 	r.Post("/users/login", allHandlers.UserHandler.Login)
-	r.Delete("/users/delete/{id}", allHandlers.UserHandler.DeleteUser)
+	r.Post("/users/login/mfa", allHandlers.UserHandler.LoginMFA)
+	r.Get("/users/oidc/{provider}/login", allHandlers.UserHandler.OIDCLogin)
+	r.Get("/users/oidc/{provider}/callback", allHandlers.UserHandler.OIDCCallback)
+	r.With(mw.RequireUser).Post("/users/mfa", allHandlers.UserHandler.EnrollMFA)
+	r.With(mw.RequireUser).Post("/users/mfa/confirm", allHandlers.UserHandler.ConfirmMFA)
+	r.With(mw.RequireUser).Post("/users/mfa/disable", allHandlers.UserHandler.DisableMFA)
+	r.With(usersManage).Delete("/users/{id}/mfa", allHandlers.UserHandler.ResetMFA)
+	r.Post("/users/password", allHandlers.UserHandler.ChangePassword)
+	r.Post("/users/password/forgot", allHandlers.UserHandler.ForgotPassword)
+	r.Post("/users/password/reset", allHandlers.UserHandler.ResetPassword)
+	r.Get("/users/verify", allHandlers.UserHandler.VerifyEmail)
+	r.Post("/users/verify", allHandlers.UserHandler.VerifyEmail)
+	r.With(mw.RequireUser).Post("/users/verify/resend", allHandlers.UserHandler.ResendEmailVerification)
+	r.Post("/users/refresh", allHandlers.UserHandler.Refresh)
+	r.With(mw.RequireUser).Post("/users/logout", allHandlers.UserHandler.Logout)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Delete("/users/delete/{id}", allHandlers.UserHandler.DeleteUser)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Get("/users/{id}/sessions", allHandlers.UserHandler.GetSessions)
+	r.With(mw.RequireSelfOr(domain.PermissionUsersManage, "id")).Delete("/users/{id}/sessions/{sid}", allHandlers.UserHandler.RevokeSession)
+	r.With(usersManage).Post("/users/{id}/roles", allHandlers.UserHandler.AssignRole)
+	r.With(usersManage).Delete("/users/{id}/roles/{role}", allHandlers.UserHandler.RemoveRole)
+	r.With(usersManage).Post("/users/{id}/promote", allHandlers.UserHandler.PromoteUser)
+	r.With(usersManage).Post("/users/{id}/demote", allHandlers.UserHandler.DemoteUser)
+	r.With(usersManage).Post("/users/{id}/disable", allHandlers.UserHandler.DisableUser)
+	r.With(usersManage).Post("/users/{id}/enable", allHandlers.UserHandler.EnableUser)
+	r.With(usersManage).Post("/users/{id}/force-password-reset", allHandlers.UserHandler.ForcePasswordReset)
+
+	r.With(usersManage).Get("/roles", allHandlers.UserHandler.GetRoles)
+	r.With(usersManage).Post("/roles", allHandlers.UserHandler.CreateRole)
+
+	r.With(inventoryManage).Get("/inventory/warehouses", allHandlers.InventoryHandler.GetWarehouses)
+	r.With(inventoryManage).Post("/inventory/warehouses", allHandlers.InventoryHandler.CreateWarehouse)
+	r.With(inventoryManage).Patch("/inventory/warehouses/{id}", allHandlers.InventoryHandler.UpdateWarehouse)
+	r.With(inventoryManage).Get("/inventory/warehouses/{id}/stock", allHandlers.InventoryHandler.GetWarehouseStock)
+	r.With(inventoryManage).Get("/inventory/transfers", allHandlers.InventoryHandler.GetTransfers)
+	r.With(inventoryManage).Post("/inventory/transfers", allHandlers.InventoryHandler.TransferStock)
+
+	r.With(apiKeysManage).Get("/api-keys", allHandlers.APIKeyHandler.GetAPIKeys)
+	r.With(apiKeysManage).Post("/api-keys", allHandlers.APIKeyHandler.CreateAPIKey)
+	r.With(apiKeysManage).Delete("/api-keys/{id}", allHandlers.APIKeyHandler.RevokeAPIKey)
+
+	// guests use the cart through their cart token
+	r.With(mw.OptionalAuth).Get("/cart", allHandlers.UserHandler.GetCart)
+	r.With(mw.OptionalAuth).Put("/cart/items/{item_id}", allHandlers.UserHandler.SetCartItem)
 
-	r.Get("/order/{id}", allHandlers.OrderHandler.GetOrderByID)
-	r.Post("/order", allHandlers.OrderHandler.CreateOrder)
-	r.Get("/order/list", allHandlers.OrderHandler.GetOrders)
-	r.Get("/order/list/{id}", allHandlers.OrderHandler.GetOrdersByUserID)
-	r.Delete("/order/delete/{id}", allHandlers.OrderHandler.DeleteOrder)
+	// order ownership is checked in the handler since the order has to be fetched first
+	r.With(mw.RequireAuth).Get("/order/{id}", allHandlers.OrderHandler.GetOrderByID)
+	r.With(mw.OptionalAuth).Post("/order", allHandlers.OrderHandler.CreateOrder)
+	r.With(mw.RequireAuth).Post("/order/{id}/cancel", allHandlers.OrderHandler.CancelOrder)
+	r.With(mw.RequirePermission(domain.PermissionOrdersRead)).Get("/order/list", allHandlers.OrderHandler.GetOrders)
+	r.With(mw.RequireSelfOr(domain.PermissionOrdersRead, "id")).Get("/order/list/{id}", allHandlers.OrderHandler.GetOrdersByUserID)
+	r.With(mw.RequirePermission(domain.PermissionOrdersManage)).Delete("/order/delete/{id}", allHandlers.OrderHandler.DeleteOrder)
 
 	return r
 }
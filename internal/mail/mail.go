@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+)
+
+type Mailer interface {
+	Send(ctx context.Context, msg *domain.MailMessage) error
+}
+
+// picks the mailer implementation from config
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg), nil
+	case "file":
+		return NewFileMailer(cfg.From, cfg.Dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver '%s'", cfg.Driver)
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	// the From header, may contain a display name
+	from string
+	// the bare address for the smtp envelope
+	envelopeFrom string
+}
+
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer {
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	envelopeFrom := cfg.From
+	if address, err := mail.ParseAddress(cfg.From); err == nil {
+		envelopeFrom = address.Address
+	}
+	return &SMTPMailer{
+		addr:         net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		auth:         auth,
+		from:         cfg.From,
+		envelopeFrom: envelopeFrom,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *domain.MailMessage) error {
+	// smtp.SendMail doesn't take a context, so it's only checked before sending
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.envelopeFrom, []string{msg.To}, format(m.from, msg))
+}
+
+// header values can't contain line breaks, otherwise they could inject more headers
+var headerReplacer = strings.NewReplacer("\r", "", "\n", "")
+
+// renders the message in the internet message format (RFC 5322)
+func format(from string, msg *domain.MailMessage) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerReplacer.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerReplacer.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerReplacer.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"tefsi/internal/domain"
+)
+
+type Auth interface {
+	Authenticate(r *http.Request) (*domain.User, error)
+}
+
+type contextKey struct{}
+
+var userKey = contextKey{}
+
+// Middleware authenticates requests and checks what the requesting user is allowed to do.
+// Every Require* middleware puts the authenticated *domain.User into the request context,
+// handlers get it back with UserFromContext
+type Middleware struct {
+	auth Auth
+}
+
+func New(auth Auth) *Middleware {
+	return &Middleware{auth: auth}
+}
+
+// returns the user put into ctx by one of the Require* middlewares or nil if there is none
+func UserFromContext(ctx context.Context) *domain.User {
+	user, _ := ctx.Value(userKey).(*domain.User)
+	return user
+}
+
+func WithUser(ctx context.Context, user *domain.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// responds with 401 if the request doesn't carry a valid token or api key
+func (m *Middleware) RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := m.auth.Authenticate(r)
+		if err != nil {
+			log.Printf("unauthorized request to %s: %s", r.URL.Path, err.Error())
+			unauthorized(w)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+	})
+}
+
+// like RequireAuth, but responds with 403 for api keys,
+// for routes that act on the account of the requesting user
+func (m *Middleware) RequireUser(next http.Handler) http.Handler {
+	return m.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// api keys don't stand for a user and get id 0
+		user := UserFromContext(r.Context())
+		if user.ID == 0 {
+			log.Printf("api key %s is not allowed to access %s", user.Login, r.URL.Path)
+			forbidden(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// authenticates requests carrying a token or api key like RequireAuth,
+// anonymous requests are let through without a user in the context
+func (m *Middleware) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" && r.Header.Get("X-API-Key") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.RequireAuth(next).ServeHTTP(w, r)
+	})
+}
+
+// responds with 401 for anonymous requests and 403 for users without the permission
+func (m *Middleware) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := UserFromContext(r.Context())
+			if !user.HasPermission(permission) {
+				log.Printf("user %d is missing %s to access %s", user.ID, permission, r.URL.Path)
+				forbidden(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// lets the request through if the requesting user has the permission or
+// if their id is equal to the user id in the url parameter param
+func (m *Middleware) RequireSelfOr(permission string, param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := UserFromContext(r.Context())
+			if user.HasPermission(permission) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			idStr := chi.URLParam(r, param)
+			userID, err := strconv.Atoi(idStr)
+			if err != nil {
+				log.Printf("got invalid user ID '%s'", idStr)
+				http.Error(w, "Invalid user ID", http.StatusBadRequest)
+				return
+			}
+
+			if user.ID != userID {
+				log.Printf("user %d is not allowed to access %s", user.ID, r.URL.Path)
+				forbidden(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func forbidden(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,66 @@
+// password hashing with bcrypt or argon2id, hashes are stored as PHC style strings
+// so the algorithm and its parameters can be changed without breaking existing passwords
+package password
+
+import (
+	"fmt"
+
+	"tefsi/internal/config"
+)
+
+type Algorithm interface {
+	Hash(password string) (string, error)
+	// whether the hash was made by this algorithm
+	Identifies(hash string) bool
+	Verify(password string, hash string) (bool, error)
+	// whether the hash was made with other parameters than the configured ones
+	Outdated(hash string) bool
+}
+
+// Hasher hashes new passwords with the configured algorithm and verifies
+// hashes made by any supported one
+type Hasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+}
+
+func NewHasher(current Algorithm, others ...Algorithm) *Hasher {
+	return &Hasher{current: current, algorithms: append([]Algorithm{current}, others...)}
+}
+
+// builds a Hasher using the algorithm from config
+func New(cfg config.PasswordConfig) (*Hasher, error) {
+	bcrypt := &Bcrypt{Cost: cfg.BcryptCost}
+	argon2id := &Argon2id{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+	}
+
+	switch cfg.Algorithm {
+	case "bcrypt":
+		return NewHasher(bcrypt, argon2id), nil
+	case "argon2id":
+		return NewHasher(argon2id, bcrypt), nil
+	}
+	return nil, fmt.Errorf("unknown password algorithm '%s'", cfg.Algorithm)
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.current.Hash(password)
+}
+
+// returns false for wrong passwords and hashes no algorithm identifies
+func (h *Hasher) Verify(password string, hash string) (bool, error) {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Identifies(hash) {
+			return algorithm.Verify(password, hash)
+		}
+	}
+	return false, nil
+}
+
+// whether the hash should be replaced with a new one the next time the password is known
+func (h *Hasher) NeedsRehash(hash string) bool {
+	return !h.current.Identifies(hash) || h.current.Outdated(hash)
+}
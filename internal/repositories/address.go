@@ -0,0 +1,181 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// address book of users, created by NewUserRepository
+func createAddressTable(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["addresses"]
+	if ok {
+		return nil
+	}
+
+	sqlString := `CREATE TABLE addresses
+    (
+        id serial primary key,
+        user_id int not null,
+        name text not null,
+        phone text not null default '',
+        line1 text not null,
+        line2 text not null default '',
+        city text not null,
+        region text not null default '',
+        postal_code text not null default '',
+        country text not null,
+        default_shipping bool not null default false,
+        default_billing bool not null default false,
+        created_at timestamptz not null default now(),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )`
+	_, err := db.Exec(context.Background(), sqlString)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(context.Background(), "CREATE INDEX addresses_user_id_idx ON addresses (user_id)")
+	return err
+}
+
+const addressColumns = `id, user_id, name, phone, line1, line2, city, region, postal_code, country,
+    default_shipping, default_billing`
+
+func scanAddress(row pgx.Row, address *domain.Address) error {
+	return row.Scan(
+		&address.ID, &address.UserID, &address.Name, &address.Phone, &address.Line1, &address.Line2,
+		&address.City, &address.Region, &address.PostalCode, &address.Country,
+		&address.DefaultShipping, &address.DefaultBilling,
+	)
+}
+
+// the first address of a user becomes their default for both shipping and billing
+func (r *UserRepository) CreateAddress(ctx context.Context, address *domain.Address) error {
+	sqlString := `INSERT INTO addresses
+        (user_id, name, phone, line1, line2, city, region, postal_code, country, default_shipping, default_billing)
+    SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9,
+        $10 OR NOT EXISTS (SELECT 1 FROM addresses WHERE user_id = $1 AND default_shipping),
+        $11 OR NOT EXISTS (SELECT 1 FROM addresses WHERE user_id = $1 AND default_billing)
+    RETURNING id, default_shipping, default_billing`
+	err := r.db.QueryRow(
+		ctx, sqlString, address.UserID, address.Name, address.Phone, address.Line1, address.Line2,
+		address.City, address.Region, address.PostalCode, address.Country,
+		address.DefaultShipping, address.DefaultBilling,
+	).Scan(&address.ID, &address.DefaultShipping, &address.DefaultBilling)
+	if err != nil {
+		return err
+	}
+	return r.clearOtherDefaults(ctx, address)
+}
+
+func (r *UserRepository) GetAddresses(ctx context.Context, userID int) (*[]domain.Address, error) {
+	sqlString := "SELECT " + addressColumns + " FROM addresses WHERE user_id = $1 ORDER BY id"
+	rows, err := r.db.Query(ctx, sqlString, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []domain.Address{}
+	for rows.Next() {
+		var address domain.Address
+		err = scanAddress(rows, &address)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return &addresses, rows.Err()
+}
+
+// only finds addresses of the given user
+func (r *UserRepository) GetAddress(ctx context.Context, userID int, id int) (*domain.Address, error) {
+	address := &domain.Address{}
+	sqlString := "SELECT " + addressColumns + " FROM addresses WHERE id = $1 AND user_id = $2"
+	err := scanAddress(r.db.QueryRow(ctx, sqlString, id, userID), address)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrAddressNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// returns the address used by orders that don't reference one,
+// billing falls back to the default shipping address
+func (r *UserRepository) GetDefaultAddress(ctx context.Context, userID int, billing bool) (*domain.Address, error) {
+	address := &domain.Address{}
+	sqlString := "SELECT " + addressColumns + ` FROM addresses
+    WHERE user_id = $1 AND (default_shipping OR ($2 AND default_billing))
+    ORDER BY $2 AND default_billing DESC
+    LIMIT 1`
+	err := scanAddress(r.db.QueryRow(ctx, sqlString, userID, billing), address)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrAddressNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return address, nil
+}
+
+// replaces the address, a default flag can be moved to another address but not unset
+func (r *UserRepository) UpdateAddress(ctx context.Context, address *domain.Address) error {
+	sqlString := `UPDATE addresses
+    SET name = $3, phone = $4, line1 = $5, line2 = $6, city = $7, region = $8, postal_code = $9, country = $10,
+        default_shipping = default_shipping OR $11, default_billing = default_billing OR $12
+    WHERE id = $1 AND user_id = $2
+    RETURNING default_shipping, default_billing`
+	err := r.db.QueryRow(
+		ctx, sqlString, address.ID, address.UserID, address.Name, address.Phone, address.Line1, address.Line2,
+		address.City, address.Region, address.PostalCode, address.Country,
+		address.DefaultShipping, address.DefaultBilling,
+	).Scan(&address.DefaultShipping, &address.DefaultBilling)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrAddressNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return r.clearOtherDefaults(ctx, address)
+}
+
+// the defaults of a deleted address move to the oldest remaining one
+func (r *UserRepository) DeleteAddress(ctx context.Context, userID int, id int) error {
+	var defaultShipping, defaultBilling bool
+	sqlString := `DELETE FROM addresses WHERE id = $1 AND user_id = $2
+    RETURNING default_shipping, default_billing`
+	err := r.db.QueryRow(ctx, sqlString, id, userID).Scan(&defaultShipping, &defaultBilling)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrAddressNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !defaultShipping && !defaultBilling {
+		return nil
+	}
+
+	sqlString = `UPDATE addresses
+    SET default_shipping = default_shipping OR $2, default_billing = default_billing OR $3
+    WHERE id = (SELECT min(id) FROM addresses WHERE user_id = $1)`
+	_, err = r.db.Exec(ctx, sqlString, userID, defaultShipping, defaultBilling)
+	return err
+}
+
+// makes the address the only default of its user for the flags it has set
+func (r *UserRepository) clearOtherDefaults(ctx context.Context, address *domain.Address) error {
+	if !address.DefaultShipping && !address.DefaultBilling {
+		return nil
+	}
+	sqlString := `UPDATE addresses
+    SET default_shipping = default_shipping AND NOT $3, default_billing = default_billing AND NOT $4
+    WHERE user_id = $1 AND id <> $2 AND ((default_shipping AND $3) OR (default_billing AND $4))`
+	_, err := r.db.Exec(ctx, sqlString, address.UserID, address.ID, address.DefaultShipping, address.DefaultBilling)
+	return err
+}
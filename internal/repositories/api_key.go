@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+type APIKeyRepository struct {
+	db Pool
+}
+
+func NewAPIKeyRepository(db Pool, allTables *map[string]struct{}) (*APIKeyRepository, error) {
+	_, ok := (*allTables)["api_keys"]
+	if !ok {
+		sqlString := `CREATE TABLE api_keys
+        (
+            id serial primary key,
+            name text not null,
+            prefix text unique not null,
+            secret_hash text not null,
+            scopes text[] not null,
+            created_by int,
+            created_at timestamptz not null default now(),
+            expires_at timestamptz,
+            last_used_at timestamptz,
+            revoked_at timestamptz,
+            FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &APIKeyRepository{db: db}, nil
+}
+
+const apiKeyColumns = `id, name, prefix, secret_hash, scopes, COALESCE(created_by, 0), created_at,
+    expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row, key *domain.APIKey) error {
+	return row.Scan(
+		&key.ID, &key.Name, &key.Prefix, &key.SecretHash, &key.Scopes, &key.CreatedBy, &key.CreatedAt,
+		&key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt,
+	)
+}
+
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *domain.APIKey) error {
+	sqlString := `INSERT INTO api_keys (name, prefix, secret_hash, scopes, created_by, expires_at)
+    VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+    RETURNING id, created_at`
+	return r.db.QueryRow(ctx, sqlString, key.Name, key.Prefix, key.SecretHash, key.Scopes, key.CreatedBy, key.ExpiresAt).
+		Scan(&key.ID, &key.CreatedAt)
+}
+
+func (r *APIKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	key := &domain.APIKey{}
+	err := scanAPIKey(r.db.QueryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = $1", prefix), key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepository) GetAPIKeys(ctx context.Context) (*[]domain.APIKey, error) {
+	rows, err := r.db.Query(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []domain.APIKey{}
+	for rows.Next() {
+		var key domain.APIKey
+		err = scanAPIKey(rows, &key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return &keys, rows.Err()
+}
+
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id int) error {
+	tag, err := r.db.Exec(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// updates last_used_at at most once a minute, keys used by busy integrations
+// would otherwise cause a write on every request
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id int) error {
+	sqlString := `UPDATE api_keys SET last_used_at = now()
+    WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := r.db.Exec(ctx, sqlString, id)
+	return err
+}
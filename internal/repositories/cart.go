@@ -0,0 +1,196 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"tefsi/internal/domain"
+)
+
+// carts of shoppers who aren't logged in, created by NewUserRepository.
+// the carts of users are kept in items_users
+func createGuestCartTables(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["guest_carts"]
+	if !ok {
+		sqlString := `CREATE TABLE guest_carts
+        (
+            id uuid primary key,
+            updated_at timestamptz not null default now()
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := migrate(db, "CREATE INDEX IF NOT EXISTS guest_carts_updated_at_idx ON guest_carts (updated_at)")
+	if err != nil {
+		return err
+	}
+
+	_, ok = (*allTables)["guest_cart_items"]
+	if !ok {
+		sqlString := `CREATE TABLE guest_cart_items
+        (
+            cart_id uuid not null,
+            item int not null,
+            variant int,
+            amount int not null,
+            FOREIGN KEY (cart_id) REFERENCES guest_carts(id) ON DELETE CASCADE,
+            FOREIGN KEY (item) REFERENCES items(id) ON DELETE CASCADE,
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	// older tables had one line per item as their primary key
+	err = migrateColumn(db, "guest_cart_items", "variant",
+		"ALTER TABLE guest_cart_items ADD COLUMN variant int",
+		`ALTER TABLE guest_cart_items ADD CONSTRAINT guest_cart_items_item_variant_fkey
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE CASCADE`,
+		"ALTER TABLE guest_cart_items DROP CONSTRAINT IF EXISTS guest_cart_items_pkey",
+	)
+	if err != nil {
+		return err
+	}
+
+	// one line per item and variant, items without variants have none
+	return migrate(db,
+		"CREATE UNIQUE INDEX IF NOT EXISTS guest_cart_items_line_idx ON guest_cart_items (cart_id, item, COALESCE(variant, 0))",
+	)
+}
+
+// one line per item and variant in a user's cart. older versions could add the same
+// item twice, those lines are merged before the index is created
+func createUserCartIndex(db Pool) error {
+	exists, err := hasIndex(db, "items_users_line_idx")
+	if err != nil || exists {
+		return err
+	}
+
+	return migrate(db,
+		`UPDATE items_users SET amount = lines.amount
+        FROM (
+            SELECT min(id) AS id, sum(amount) AS amount FROM items_users
+            GROUP BY user_id, item, COALESCE(variant, 0)
+            HAVING count(*) > 1
+        ) AS lines
+        WHERE items_users.id = lines.id`,
+		`DELETE FROM items_users USING items_users AS kept
+        WHERE items_users.user_id = kept.user_id AND items_users.item = kept.item
+            AND COALESCE(items_users.variant, 0) = COALESCE(kept.variant, 0) AND items_users.id > kept.id`,
+		"CREATE UNIQUE INDEX items_users_line_idx ON items_users (user_id, item, COALESCE(variant, 0))",
+	)
+}
+
+// sets the amount of the item in the user's cart, 0 removes it
+func (r *UserRepository) SetUserCartItem(ctx context.Context, userID int, item *domain.ItemWithAmount) error {
+	if item.Amount == 0 {
+		sqlString := "DELETE FROM items_users WHERE user_id = $1 AND item = $2 AND COALESCE(variant, 0) = $3"
+		_, err := r.db.Exec(ctx, sqlString, userID, item.ItemID, item.VariantID)
+		return err
+	}
+
+	err := checkVariant(ctx, r.db, item)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO items_users (item, variant, amount, user_id) VALUES ($1, NULLIF($2, 0), $3, $4)
+    ON CONFLICT (user_id, item, COALESCE(variant, 0)) DO UPDATE SET amount = excluded.amount`
+	_, err = r.db.Exec(ctx, sqlString, item.ItemID, item.VariantID, item.Amount, userID)
+	if isForeignKeyViolation(err, "items_users_item_fkey") {
+		return domain.ErrItemNotFound
+	}
+	return err
+}
+
+// returns an empty cart for unknown carts, they may have expired or been merged
+func (r *UserRepository) GetGuestCart(ctx context.Context, cartID string) (*[]domain.ItemWithAmount, error) {
+	sqlString := `SELECT item, COALESCE(variant, 0), amount FROM guest_cart_items
+    WHERE cart_id = $1
+    ORDER BY item, variant`
+	rows, err := r.db.Query(ctx, sqlString, cartID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []domain.ItemWithAmount{}
+	for rows.Next() {
+		item := domain.ItemWithAmount{}
+		err = rows.Scan(&item.ItemID, &item.VariantID, &item.Amount)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return &items, rows.Err()
+}
+
+// sets the amount of the item in the guest cart, creating the cart if it doesn't exist, 0 removes the item
+func (r *UserRepository) SetGuestCartItem(ctx context.Context, cartID string, item *domain.ItemWithAmount) error {
+	cartSQL := `INSERT INTO guest_carts (id) VALUES ($1)
+    ON CONFLICT (id) DO UPDATE SET updated_at = now()`
+	_, err := r.db.Exec(ctx, cartSQL, cartID)
+	if err != nil {
+		return err
+	}
+
+	if item.Amount == 0 {
+		sqlString := "DELETE FROM guest_cart_items WHERE cart_id = $1 AND item = $2 AND COALESCE(variant, 0) = $3"
+		_, err = r.db.Exec(ctx, sqlString, cartID, item.ItemID, item.VariantID)
+		return err
+	}
+
+	err = checkVariant(ctx, r.db, item)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO guest_cart_items (cart_id, item, variant, amount) VALUES ($1, $2, NULLIF($3, 0), $4)
+    ON CONFLICT (cart_id, item, COALESCE(variant, 0)) DO UPDATE SET amount = excluded.amount`
+	_, err = r.db.Exec(ctx, sqlString, cartID, item.ItemID, item.VariantID, item.Amount)
+	if isForeignKeyViolation(err, "guest_cart_items_item_fkey") {
+		return domain.ErrItemNotFound
+	}
+	return err
+}
+
+// adds the items of the guest cart to the user's cart and deletes the guest cart,
+// amounts of items that are in both carts are added up
+func (r *UserRepository) MergeGuestCart(ctx context.Context, cartID string, userID int) error {
+	// deleting the cart first makes sure concurrent logins merge it only once
+	sqlString := `WITH cart AS (
+        DELETE FROM guest_carts WHERE id = $1 RETURNING id
+    )
+    INSERT INTO items_users (item, variant, amount, user_id)
+    SELECT item, variant, amount, $2 FROM guest_cart_items WHERE cart_id IN (SELECT id FROM cart)
+    ON CONFLICT (user_id, item, COALESCE(variant, 0)) DO UPDATE SET amount = items_users.amount + excluded.amount`
+	_, err := r.db.Exec(ctx, sqlString, cartID, userID)
+	return err
+}
+
+// how many carts DeleteStaleGuestCarts deletes per statement
+const staleGuestCartBatch = 1000
+
+// deletes the carts not changed since before, in batches so a backlog of them
+// doesn't hold locks on the whole table; returns how many were deleted
+func (r *UserRepository) DeleteStaleGuestCarts(ctx context.Context, before time.Time) (int64, error) {
+	sqlString := `DELETE FROM guest_carts
+    WHERE id IN (SELECT id FROM guest_carts WHERE updated_at < $1 LIMIT $2)`
+	var deleted int64
+	for {
+		tag, err := r.db.Exec(ctx, sqlString, before, staleGuestCartBatch)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += tag.RowsAffected()
+		if tag.RowsAffected() < staleGuestCartBatch {
+			return deleted, nil
+		}
+	}
+}
@@ -16,60 +16,261 @@ func NewCategoryRepository(db Pool, allTables *map[string]struct{}) (*CategoryRe
 		sqlString := `CREATE TABLE categories
 		(
 			id serial primary key,
-			title text
+			title text,
+			description text not null default '',
+			slug text UNIQUE,
+			parent_id int,
+			FOREIGN KEY (parent_id) REFERENCES categories(id)
 		)`
 		_, err := db.Exec(context.Background(), sqlString)
 		if err != nil {
 			return nil, err
 		}
 	}
+
+	err := migrate(db,
+		// the foreign key is only created along with the column
+		"ALTER TABLE categories ADD COLUMN IF NOT EXISTS parent_id int REFERENCES categories(id)",
+		"CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id)",
+		"ALTER TABLE categories ADD COLUMN IF NOT EXISTS description text not null default ''",
+		// creates categories_slug_key like the table definition does
+		"ALTER TABLE categories ADD COLUMN IF NOT EXISTS slug text UNIQUE",
+		// for suggestions, see ItemRepository.SuggestItems
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS categories_title_trgm_idx ON categories USING GIN (lower(title) gin_trgm_ops)",
+	)
+	if err != nil {
+		return nil, err
+	}
 	return &CategoryRepository{db: db}, nil
 }
 
+// the category with the path of its ancestors
 func (r *CategoryRepository) GetCategoryByID(ctx context.Context, id int) (*domain.Category, error) {
-	category := &domain.Category{}
-	err := r.db.QueryRow(ctx, "SELECT id, title FROM categories WHERE id = $1", id).Scan(&category.ID, &category.Title)
+	return r.getCategory(ctx, "id = $1", id)
+}
+
+func (r *CategoryRepository) GetCategoryBySlug(ctx context.Context, slug string) (*domain.Category, error) {
+	return r.getCategory(ctx, "slug = $1", slug)
+}
+
+// condition selects the category, its ancestors are found from there
+func (r *CategoryRepository) getCategory(ctx context.Context, condition string, arg any) (*domain.Category, error) {
+	sqlString := `WITH RECURSIVE path AS (
+	    SELECT id, title, description, slug, parent_id, 0 AS depth FROM categories WHERE ` + condition + `
+	    UNION ALL
+	    SELECT categories.id, categories.title, categories.description, categories.slug, categories.parent_id, path.depth + 1
+	    FROM categories
+	    JOIN path ON categories.id = path.parent_id
+	)
+	SELECT id, title, description, COALESCE(slug, ''), COALESCE(parent_id, 0) FROM path ORDER BY depth DESC`
+	rows, err := r.db.Query(ctx, sqlString, arg)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	category := &domain.Category{Path: []domain.CategoryRef{}}
+	for rows.Next() {
+		err := rows.Scan(&category.ID, &category.Title, &category.Description, &category.Slug, &category.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		category.Path = append(category.Path, domain.CategoryRef{ID: category.ID, Title: category.Title, Slug: category.Slug})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// the last row is the category itself
+	if len(category.Path) == 0 {
+		return nil, domain.ErrCategoryNotFound
+	}
 	return category, nil
 }
 
 func (r *CategoryRepository) CreateCategory(ctx context.Context, category *domain.Category) error {
-	_, err := r.db.Exec(ctx, "INSERT INTO categories (title) VALUES ($1)", category.Title)
+	sqlString := `INSERT INTO categories (title, description, slug, parent_id)
+	VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0)) RETURNING id`
+	err := r.db.QueryRow(ctx, sqlString, category.Title, category.Description, category.Slug, category.ParentID).Scan(&category.ID)
+	if isForeignKeyViolation(err, "categories_parent_id_fkey") {
+		return domain.ErrCategoryNotFound
+	}
+	if isUniqueViolation(err, "categories_slug_key") {
+		return domain.ErrSlugTaken
+	}
 	return err
 }
 
+func (r *CategoryRepository) UpdateCategory(ctx context.Context, id int, update *domain.CategoryUpdate) error {
+	sqlString := `UPDATE categories
+	SET title = COALESCE($2, title), description = COALESCE($3, description), slug = COALESCE($4, slug)
+	WHERE id = $1`
+	tag, err := r.db.Exec(ctx, sqlString, id, update.Title, update.Description, update.Slug)
+	if isUniqueViolation(err, "categories_slug_key") {
+		return domain.ErrSlugTaken
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrCategoryNotFound
+	}
+	return nil
+}
+
 func (r *CategoryRepository) GetCategories(ctx context.Context) (*[]domain.Category, error) {
 	var categories []domain.Category
-	sqlString := "SELECT id, title FROM categories"
+	sqlString := "SELECT id, title, description, COALESCE(slug, ''), COALESCE(parent_id, 0) FROM categories ORDER BY id"
 	rows, err := r.db.Query(ctx, sqlString)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var category domain.Category
-		err := rows.Scan(&category.ID, &category.Title)
+		err := rows.Scan(&category.ID, &category.Title, &category.Description, &category.Slug, &category.ParentID)
 		if err != nil {
 			return nil, err
 		}
 
 		categories = append(categories, category)
 	}
-	return &categories, nil
+	return &categories, rows.Err()
 }
 
-func (r *CategoryRepository) DeleteCategory(ctx context.Context, id int) error {
-	deleteCategory := "DELETE FROM categories WHERE id = $1"
-	_, err := r.db.Exec(ctx, deleteCategory, id)
+// arbitrary key of the advisory lock that serializes moves, two concurrent moves
+// could otherwise each pass the cycle check and create a cycle together
+const categoryMoveLock = 7421
+
+// moves the category under parentID, or to the top level if parentID is 0
+func (r *CategoryRepository) MoveCategory(ctx context.Context, id int, parentID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", categoryMoveLock)
 	if err != nil {
 		return err
 	}
 
-	updateItemsSQL := `UPDATE items
-    SET category = 0
-    WHERE category = $1`
-	_, err = r.db.Exec(ctx, updateItemsSQL, id)
+	sqlString := `WITH RECURSIVE ` + categorySubtreeSQL + `
+	UPDATE categories SET parent_id = NULLIF($2, 0)
+	WHERE id = $1 AND $2 NOT IN (SELECT id FROM subtree)`
+	tag, err := tx.Exec(ctx, sqlString, id, parentID)
+	if isForeignKeyViolation(err, "categories_parent_id_fkey") {
+		return domain.ErrCategoryNotFound
+	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return domain.ErrCategoryNotFound
+		}
+		return domain.ErrCategoryCycle
+	}
+	return tx.Commit(ctx)
+}
+
+// category ids of the subtree of $1, for use in WITH RECURSIVE
+const categorySubtreeSQL = `subtree AS (
+	    SELECT id FROM categories WHERE id = $1
+	    UNION
+	    SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+	)`
+
+// deletes the category and handles its items and subcategories as the strategy says, all or nothing
+func (r *CategoryRepository) DeleteCategory(ctx context.Context, id int, strategy *domain.CategoryDelete) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// the subtree can't change while it's being deleted or moved
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", categoryMoveLock)
+	if err != nil {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrCategoryNotFound
+	}
+
+	switch strategy.Strategy {
+	case domain.CategoryDeleteRefuse:
+		var notEmpty bool
+		sqlString := `SELECT EXISTS (SELECT 1 FROM items WHERE category = $1)
+		    OR EXISTS (SELECT 1 FROM categories WHERE parent_id = $1)`
+		err = tx.QueryRow(ctx, sqlString, id).Scan(&notEmpty)
+		if err != nil {
+			return err
+		}
+		if notEmpty {
+			return domain.ErrCategoryNotEmpty
+		}
+
+	case domain.CategoryDeleteReassign:
+		var targetExists, inSubtree bool
+		sqlString := `WITH RECURSIVE ` + categorySubtreeSQL + `
+		SELECT EXISTS (SELECT 1 FROM categories WHERE id = $2), $2 IN (SELECT id FROM subtree)`
+		err = tx.QueryRow(ctx, sqlString, id, strategy.TargetID).Scan(&targetExists, &inSubtree)
+		if err != nil {
+			return err
+		}
+		if !targetExists {
+			return domain.ErrCategoryNotFound
+		}
+		if inSubtree {
+			return domain.ErrCategoryCycle
+		}
+
+		_, err = tx.Exec(ctx, "UPDATE items SET category = $2, version = version + 1 WHERE category = $1", id, strategy.TargetID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE categories SET parent_id = $2 WHERE parent_id = $1", id, strategy.TargetID)
+		if err != nil {
+			return err
+		}
+
+	case domain.CategoryDeleteArchive:
+		sqlString := `WITH RECURSIVE ` + categorySubtreeSQL + `
+		UPDATE items SET hidden = true, category = NULL, version = version + 1
+		WHERE category IN (SELECT id FROM subtree)`
+		_, err = tx.Exec(ctx, sqlString, id)
+		if err != nil {
+			return err
+		}
+		// the foreign keys between the subcategories are checked once the whole statement is done
+		sqlString = `WITH RECURSIVE ` + categorySubtreeSQL + `
+		DELETE FROM categories WHERE id IN (SELECT id FROM subtree)`
+		_, err = tx.Exec(ctx, sqlString, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+
+	default:
+		return domain.ErrInvalidCategory
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
@@ -0,0 +1,51 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// accounts at external identity providers, created by NewUserRepository
+func createIdentityTable(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["user_identities"]
+	if ok {
+		return nil
+	}
+
+	sqlString := `CREATE TABLE user_identities
+    (
+        provider text not null,
+        subject text not null,
+        user_id int not null,
+        email text,
+        created_at timestamptz not null default now(),
+        primary key (provider, subject),
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )`
+	_, err := db.Exec(context.Background(), sqlString)
+	return err
+}
+
+func (r *UserRepository) GetUserByIdentity(ctx context.Context, provider string, subject string) (*domain.User, error) {
+	var userID int
+	sqlString := "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2"
+	err := r.db.QueryRow(ctx, sqlString, provider, subject).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.GetUserByID(ctx, userID)
+}
+
+func (r *UserRepository) LinkIdentity(ctx context.Context, identity *domain.Identity) error {
+	sqlString := `INSERT INTO user_identities (provider, subject, user_id, email)
+    VALUES ($1, $2, $3, NULLIF($4, ''))`
+	_, err := r.db.Exec(ctx, sqlString, identity.Provider, identity.Subject, identity.UserID, identity.Email)
+	return err
+}
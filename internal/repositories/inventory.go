@@ -0,0 +1,346 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tefsi/internal/allocation"
+	"tefsi/internal/domain"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type InventoryRepository struct {
+	db Pool
+}
+
+// needs the items, variants and orders tables
+func NewInventoryRepository(db Pool, allTables *map[string]struct{}) (*InventoryRepository, error) {
+	err := createWarehouseTables(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok := (*allTables)["stock_levels"]
+	if !ok {
+		sqlString := `CREATE TABLE stock_levels
+        (
+            warehouse int not null,
+            item int not null,
+            variant int,
+            quantity int not null CHECK (quantity >= 0),
+            FOREIGN KEY (warehouse) REFERENCES warehouses(id),
+            FOREIGN KEY (item) REFERENCES items(id) ON DELETE CASCADE,
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+
+		sqlString = "CREATE UNIQUE INDEX stock_levels_line_idx ON stock_levels (warehouse, item, COALESCE(variant, 0))"
+		_, err = db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.Exec(context.Background(), "CREATE INDEX stock_levels_item_idx ON stock_levels (item)")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// stock tracked before there were warehouses is in the default one
+	err = migrateColumn(db, "stock_levels", "warehouse",
+		fmt.Sprintf("ALTER TABLE stock_levels ADD COLUMN warehouse int not null default %d REFERENCES warehouses(id)", domain.DefaultWarehouseID),
+		"ALTER TABLE stock_levels ALTER COLUMN warehouse DROP DEFAULT",
+		"DROP INDEX IF EXISTS stock_levels_line_idx",
+		"CREATE UNIQUE INDEX stock_levels_line_idx ON stock_levels (warehouse, item, COALESCE(variant, 0))",
+		"CREATE INDEX IF NOT EXISTS stock_levels_item_idx ON stock_levels (item)",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createTransferTable(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok = (*allTables)["inventory_adjustments"]
+	if !ok {
+		sqlString := `CREATE TABLE inventory_adjustments
+        (
+            id serial primary key,
+            warehouse int not null,
+            item int not null,
+            variant int,
+            delta int not null,
+            reason text not null,
+            note text not null default '',
+            order_id int,
+            transfer_id int,
+            created_at timestamptz not null default now(),
+            FOREIGN KEY (warehouse) REFERENCES warehouses(id),
+            FOREIGN KEY (item) REFERENCES items(id) ON DELETE RESTRICT,
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE RESTRICT,
+            FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE SET NULL,
+            FOREIGN KEY (transfer_id) REFERENCES stock_transfers(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = db.Exec(context.Background(), "CREATE INDEX inventory_adjustments_item_idx ON inventory_adjustments (item, id)")
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.Exec(context.Background(), "CREATE INDEX inventory_adjustments_order_id_idx ON inventory_adjustments (order_id)")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = migrateColumn(db, "inventory_adjustments", "warehouse",
+		fmt.Sprintf("ALTER TABLE inventory_adjustments ADD COLUMN warehouse int not null default %d REFERENCES warehouses(id)", domain.DefaultWarehouseID),
+		"ALTER TABLE inventory_adjustments ALTER COLUMN warehouse DROP DEFAULT",
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = migrate(db,
+		"ALTER TABLE inventory_adjustments ADD COLUMN IF NOT EXISTS transfer_id int REFERENCES stock_transfers(id) ON DELETE CASCADE",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ledger is the history of the stock, items and variants in it can't be deleted
+	err = replaceCascadingForeignKey(db, "inventory_adjustments", "inventory_adjustments_item_fkey",
+		"FOREIGN KEY (item) REFERENCES items(id) ON DELETE RESTRICT")
+	if err != nil {
+		return nil, err
+	}
+	err = replaceCascadingForeignKey(db, "inventory_adjustments", "inventory_adjustments_item_variant_fkey",
+		"FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE RESTRICT")
+	if err != nil {
+		return nil, err
+	}
+
+	return &InventoryRepository{db: db}, nil
+}
+
+// stock levels of the item and its variants in every warehouse, untracked ones are left out
+func (r *InventoryRepository) GetStock(ctx context.Context, itemID int) (*[]domain.StockLevel, error) {
+	return r.getStockLevels(ctx, "item = $1", itemID)
+}
+
+// stock levels of everything in the warehouse
+func (r *InventoryRepository) GetWarehouseStock(ctx context.Context, warehouseID int) (*[]domain.StockLevel, error) {
+	return r.getStockLevels(ctx, "warehouse = $1", warehouseID)
+}
+
+func (r *InventoryRepository) getStockLevels(ctx context.Context, condition string, arg any) (*[]domain.StockLevel, error) {
+	sqlString := `SELECT warehouse, item, COALESCE(variant, 0), quantity FROM stock_levels
+    WHERE ` + condition + `
+    ORDER BY item, variant NULLS FIRST, warehouse`
+	rows, err := r.db.Query(ctx, sqlString, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	levels := []domain.StockLevel{}
+	for rows.Next() {
+		level := domain.StockLevel{}
+		err := rows.Scan(&level.WarehouseID, &level.ItemID, &level.VariantID, &level.Quantity)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+	return &levels, rows.Err()
+}
+
+// changes the stock level by the delta of the adjustment and records it in the ledger.
+// the first adjustment of an item or variant starts tracking its stock
+func (r *InventoryRepository) AdjustStock(ctx context.Context, adjustment *domain.StockAdjustment) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// stock of items with variants is kept per variant, unavailable ones can still be restocked
+	line := &domain.ItemWithAmount{ItemID: adjustment.ItemID, VariantID: adjustment.VariantID}
+	err = checkVariant(ctx, tx, line)
+	if err != nil && !errors.Is(err, domain.ErrVariantUnavailable) {
+		return err
+	}
+
+	err = changeStock(ctx, tx, adjustment)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// adds the delta to the stock level and records the adjustment, in the caller's transaction
+func changeStock(ctx context.Context, tx pgx.Tx, adjustment *domain.StockAdjustment) error {
+	sqlString := `INSERT INTO stock_levels (warehouse, item, variant, quantity) VALUES ($1, $2, NULLIF($3, 0), $4)
+    ON CONFLICT (warehouse, item, COALESCE(variant, 0)) DO UPDATE SET quantity = stock_levels.quantity + excluded.quantity`
+	_, err := tx.Exec(ctx, sqlString, adjustment.WarehouseID, adjustment.ItemID, adjustment.VariantID, adjustment.Delta)
+	switch {
+	case isCheckViolation(err, "stock_levels_quantity_check"):
+		return domain.ErrInsufficientStock
+	case isForeignKeyViolation(err, "stock_levels_warehouse_fkey"):
+		return domain.ErrWarehouseNotFound
+	case isForeignKeyViolation(err, "stock_levels_item_fkey"):
+		return domain.ErrItemNotFound
+	case err != nil:
+		return err
+	}
+
+	sqlString = `INSERT INTO inventory_adjustments (warehouse, item, variant, delta, reason, note, transfer_id)
+    VALUES ($1, $2, NULLIF($3, 0), $4, $5, $6, NULLIF($7, 0)) RETURNING id, created_at`
+	return tx.QueryRow(
+		ctx, sqlString, adjustment.WarehouseID, adjustment.ItemID, adjustment.VariantID, adjustment.Delta,
+		adjustment.Reason, adjustment.Note, adjustment.TransferID,
+	).Scan(&adjustment.ID, &adjustment.CreatedAt)
+}
+
+// the latest entries of the ledger for the item, newest first
+func (r *InventoryRepository) GetAdjustments(ctx context.Context, itemID int, limit int) (*[]domain.StockAdjustment, error) {
+	sqlString := `SELECT id, warehouse, item, COALESCE(variant, 0), delta, reason, note,
+        COALESCE(order_id, 0), COALESCE(transfer_id, 0), created_at
+    FROM inventory_adjustments
+    WHERE item = $1
+    ORDER BY id DESC
+    LIMIT $2`
+	rows, err := r.db.Query(ctx, sqlString, itemID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []domain.StockAdjustment{}
+	for rows.Next() {
+		a := domain.StockAdjustment{}
+		err := rows.Scan(
+			&a.ID, &a.WarehouseID, &a.ItemID, &a.VariantID, &a.Delta, &a.Reason, &a.Note,
+			&a.OrderID, &a.TransferID, &a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, a)
+	}
+	return &adjustments, rows.Err()
+}
+
+// takes the lines of the order out of stock, in the transaction that places the order.
+// the stock levels of the ordered items are locked in a fixed order so concurrent orders
+// neither take the same units nor deadlock, then allocation.Allocate picks the warehouses.
+// all lines that can't be reserved are reported together
+func reserveStock(ctx context.Context, tx pgx.Tx, orderID int, items []domain.ItemWithAmount) error {
+	itemIDs := []int{}
+	for _, item := range items {
+		itemIDs = append(itemIDs, item.ItemID)
+	}
+
+	sqlString := `SELECT warehouse, item, COALESCE(variant, 0), quantity FROM stock_levels
+    WHERE item = ANY($1)
+    ORDER BY warehouse, item, variant
+    FOR UPDATE`
+	rows, err := tx.Query(ctx, sqlString, itemIDs)
+	if err != nil {
+		return err
+	}
+	stock := []domain.StockLevel{}
+	for rows.Next() {
+		level := domain.StockLevel{}
+		err := rows.Scan(&level.WarehouseID, &level.ItemID, &level.VariantID, &level.Quantity)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		stock = append(stock, level)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(stock) == 0 {
+		// none of the items are tracked
+		return nil
+	}
+
+	warehouses, err := getWarehouses(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	allocations, shortages := allocation.Allocate(items, *warehouses, stock)
+	if len(shortages) > 0 {
+		return &domain.StockShortageError{Lines: shortages}
+	}
+
+	reserveSQL := `WITH reserved AS (
+        UPDATE stock_levels SET quantity = quantity - $4
+        WHERE warehouse = $1 AND item = $2 AND COALESCE(variant, 0) = $3
+        RETURNING warehouse, item, variant
+    )
+    INSERT INTO inventory_adjustments (warehouse, item, variant, delta, reason, order_id)
+    SELECT warehouse, item, variant, -$4, $5, $6 FROM reserved`
+	for _, a := range allocations {
+		_, err := tx.Exec(ctx, reserveSQL, a.WarehouseID, a.ItemID, a.VariantID, a.Amount, domain.AdjustmentReasonOrder, orderID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// puts back what the order still has reserved according to the ledger
+func releaseStock(ctx context.Context, tx pgx.Tx, orderID int) error {
+	sqlString := `WITH released AS (
+        SELECT warehouse, item, variant, -SUM(delta) AS amount FROM inventory_adjustments
+        WHERE order_id = $1 AND reason IN ($2, $3)
+        GROUP BY warehouse, item, variant
+        HAVING SUM(delta) <> 0
+    ), restocked AS (
+        UPDATE stock_levels SET quantity = stock_levels.quantity + released.amount
+        FROM released
+        WHERE stock_levels.warehouse = released.warehouse AND stock_levels.item = released.item
+            AND stock_levels.variant IS NOT DISTINCT FROM released.variant
+    )
+    INSERT INTO inventory_adjustments (warehouse, item, variant, delta, reason, order_id)
+    SELECT warehouse, item, variant, amount, $3, $1 FROM released`
+	_, err := tx.Exec(ctx, sqlString, orderID, domain.AdjustmentReasonOrder, domain.AdjustmentReasonOrderReleased)
+	return err
+}
+
+// what the order has reserved in which warehouse, according to the ledger
+func getAllocations(ctx context.Context, db Pool, orderID int) ([]domain.Allocation, error) {
+	sqlString := `SELECT warehouse, item, COALESCE(variant, 0), -SUM(delta) FROM inventory_adjustments
+    WHERE order_id = $1 AND reason IN ($2, $3)
+    GROUP BY warehouse, item, variant
+    HAVING SUM(delta) <> 0
+    ORDER BY item, variant, warehouse`
+	rows, err := db.Query(ctx, sqlString, orderID, domain.AdjustmentReasonOrder, domain.AdjustmentReasonOrderReleased)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	allocations := []domain.Allocation{}
+	for rows.Next() {
+		a := domain.Allocation{}
+		err := rows.Scan(&a.WarehouseID, &a.ItemID, &a.VariantID, &a.Amount)
+		if err != nil {
+			return nil, err
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, rows.Err()
+}
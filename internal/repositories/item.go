@@ -2,83 +2,527 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"tefsi/internal/domain"
+
+	"github.com/jackc/pgx/v4"
 )
 
 type ItemRepository struct {
 	db Pool
+	// text search configuration of the search column, like russian or english
+	language string
 }
 
-func NewItemRepository(db Pool, allTables *map[string]struct{}) (*ItemRepository, error) {
+var languagePattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// definition of the search column, formatted with the language
+const searchColumn = `search tsvector GENERATED ALWAYS AS (
+                setweight(to_tsvector('%[1]s', coalesce(title, '')), 'A') ||
+                setweight(to_tsvector('%[1]s', coalesce(description, '')), 'B')
+            ) STORED`
+
+// the search column is generated with the language,
+// it is recreated on start when the language was changed
+func NewItemRepository(db Pool, allTables *map[string]struct{}, language string) (*ItemRepository, error) {
+	// the language is part of the column definition, it can't be a parameter
+	if !languagePattern.MatchString(language) {
+		return nil, fmt.Errorf("invalid search language '%s'", language)
+	}
+
 	_, ok := (*allTables)["items"]
 
 	if !ok {
-		sqlString := `CREATE TABLE items
+		sqlString := fmt.Sprintf(`CREATE TABLE items
         (
             id serial primary key,
             title text,
             description text,
             price int,
             category int,
+            hidden boolean not null default false,
+            attributes jsonb not null default '{}',
+            version int not null default 1,
+            options jsonb not null default '[]',
+            `+searchColumn+`,
             FOREIGN KEY (category) REFERENCES categories(id)
-        )`
+        )`, language)
 
 		_, err := db.Exec(context.Background(), sqlString)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &ItemRepository{db: db}, nil
+
+	err := migrate(db,
+		fmt.Sprintf("ALTER TABLE items ADD COLUMN IF NOT EXISTS "+searchColumn, language),
+		"CREATE INDEX IF NOT EXISTS items_search_idx ON items USING GIN (search)",
+		"ALTER TABLE items ADD COLUMN IF NOT EXISTS hidden boolean not null default false",
+		// for suggestions, see SuggestItems
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"CREATE INDEX IF NOT EXISTS items_title_trgm_idx ON items USING GIN (lower(title) gin_trgm_ops)",
+		"ALTER TABLE items ADD COLUMN IF NOT EXISTS attributes jsonb not null default '{}'",
+		"ALTER TABLE items ADD COLUMN IF NOT EXISTS version int not null default 1",
+		"ALTER TABLE items ADD COLUMN IF NOT EXISTS options jsonb not null default '[]'",
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = migrateSearchLanguage(db, language)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createVariantTable(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+	return &ItemRepository{db: db, language: language}, nil
 }
 
-func (r *ItemRepository) GetItemByID(ctx context.Context, id int) (*domain.Item, error) {
+// recreates the search column if it was generated with another language,
+// queries stemmed with the new language wouldn't match the words stemmed with the old one
+func migrateSearchLanguage(db Pool, language string) error {
+	var expression string
+	sqlString := `SELECT generation_expression FROM information_schema.columns
+    WHERE table_schema = current_schema() AND table_name = 'items' AND column_name = 'search'`
+	err := db.QueryRow(context.Background(), sqlString).Scan(&expression)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(expression, "'"+language+"'::regconfig") {
+		return nil
+	}
+
+	log.Printf("rebuilding the search column of items for search language '%s'", language)
+	return migrateTx(db,
+		"ALTER TABLE items DROP COLUMN search",
+		fmt.Sprintf("ALTER TABLE items ADD COLUMN "+searchColumn, language),
+		"CREATE INDEX items_search_idx ON items USING GIN (search)",
+	)
+}
+
+const headlineOptions = "StartSel=<b>, StopSel=</b>, MaxFragments=2, MaxWords=20, MinWords=5"
+
+// columns of a single item read by scanItem, in the same order. archived items have no category
+const itemColumns = `items.id, items.title, items.description, items.price, COALESCE(items.category, 0),
+	COALESCE(categories.title, ''), items.hidden, NULLIF(items.attributes, '{}'), items.version,
+	NULLIF(items.options, '[]')`
+
+// reads the item selected with itemColumns and adds its variants
+func (r *ItemRepository) scanItem(ctx context.Context, row pgx.Row) (*domain.Item, error) {
 	item := domain.Item{}
-	sqlString := `SELECT items.id, items.title, items.description, items.price, items.category, categories.title
-	FROM items
-	JOIN categories ON items.category = categories.id
-	WHERE items.id = $1;`
-	err := r.db.QueryRow(ctx, sqlString, id).Scan(
-		&item.ID, &item.Title, &item.Description, &item.Price, &item.CategoryID, &item.CategoryTitle,
+	err := row.Scan(
+		&item.ID, &item.Title, &item.Description, &item.Price, &item.CategoryID, &item.CategoryTitle, &item.Hidden,
+		&item.Attributes, &item.Version, &item.Options,
 	)
 	if err != nil {
 		return nil, err
 	}
+
+	variants, err := r.GetVariants(ctx, item.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(*variants) > 0 {
+		item.Variants = *variants
+	}
 	return &item, nil
 }
 
+func (r *ItemRepository) GetItemByID(ctx context.Context, id int) (*domain.Item, error) {
+	sqlString := `SELECT ` + itemColumns + `
+	FROM items
+	LEFT JOIN categories ON items.category = categories.id
+	WHERE items.id = $1;`
+	item, err := r.scanItem(ctx, r.db.QueryRow(ctx, sqlString, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrItemNotFound
+	}
+	return item, err
+}
+
 func (r *ItemRepository) CreateItem(ctx context.Context, item *domain.Item) error {
 	log.Printf("creating item from domain: %v", *item)
-	sqlString := "INSERT INTO items (title, description, price, category) VALUES ($1, $2, $3, $4)"
-	_, err := r.db.Exec(ctx, sqlString, item.Title, item.Description, item.Price, item.CategoryID)
+	attributes := item.Attributes
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	sqlString := "INSERT INTO items (title, description, price, category, hidden, attributes) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err := r.db.Exec(ctx, sqlString, item.Title, item.Description, item.Price, item.CategoryID, item.Hidden, attributes)
 	return err
 }
 
-// TODO: test that filter works
-func (r *ItemRepository) GetItems(ctx context.Context, filter *domain.Filter) (*[]domain.Item, error) {
-	var items []domain.Item
-	sqlString := `SELECT items.id, items.title, items.description, items.price, items.category, categories.title
-	FROM items
-	JOIN categories ON items.category = categories.id`
+// applies update if the item is still at version and returns the item as it was written,
+// otherwise returns ErrVersionConflict
+func (r *ItemRepository) UpdateItem(ctx context.Context, id int, version int, update *domain.ItemUpdate) (*domain.Item, error) {
+	sqlString := `WITH updated AS (
+	    UPDATE items SET
+	        title = COALESCE($3, title),
+	        description = COALESCE($4, description),
+	        price = COALESCE($5, price),
+	        category = COALESCE($6, category),
+	        hidden = COALESCE($7, hidden),
+	        attributes = COALESCE($8::jsonb, attributes),
+	        version = version + 1
+	    WHERE id = $1 AND version = $2
+	    RETURNING *
+	)
+	SELECT ` + itemColumns + `
+	FROM updated AS items
+	LEFT JOIN categories ON items.category = categories.id`
+	row := r.db.QueryRow(
+		ctx, sqlString, id, version,
+		update.Title, update.Description, update.Price, update.CategoryID, update.Hidden, update.Attributes,
+	)
+	item, err := r.scanItem(ctx, row)
+	if isForeignKeyViolation(err, "items_category_fkey") {
+		return nil, domain.ErrCategoryNotFound
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return item, err
+	}
 
-	sqlString += filter.GenerateString()
+	var exists bool
+	err = r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM items WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, domain.ErrVersionConflict
+	}
+	return nil, domain.ErrItemNotFound
+}
 
-	rows, err := r.db.Query(ctx, sqlString, filter.GetDBParams()...)
+func (r *ItemRepository) GetItems(ctx context.Context, query *domain.ItemQuery) (*[]domain.Item, error) {
+	sqlString, args, tsquery := r.itemsSQL(query)
+	rows, err := r.db.Query(ctx, sqlString, args...)
 	if err != nil {
 		return nil, err
 	}
+	return scanItems(rows, tsquery != "")
+}
+
+// the page of items and the facets of all items matching the filters of query,
+// both queries are sent to the database in one batch
+func (r *ItemRepository) GetItemsWithFacets(ctx context.Context, query *domain.ItemQuery) (*domain.ItemPage, error) {
+	itemsSQL, itemsArgs, tsquery := r.itemsSQL(query)
+	facetsSQL, facetsArgs := r.facetsSQL(query)
 
+	batch := &pgx.Batch{}
+	batch.Queue(itemsSQL, itemsArgs...)
+	batch.Queue(facetsSQL, facetsArgs...)
+	results := r.db.SendBatch(ctx, batch)
+	defer results.Close()
+
+	rows, err := results.Query()
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanItems(rows, tsquery != "")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = results.Query()
+	if err != nil {
+		return nil, err
+	}
+	facets, err := scanFacets(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.ItemPage{Items: *items, Facets: facets}, nil
+}
+
+// adds the conditions of the filters of query, the returned tsquery is empty if there is no search
+func (r *ItemRepository) itemFilter(b *queryBuilder, query *domain.ItemQuery) (tsquery string) {
+	b.where("NOT items.hidden")
+	if query.Search != "" {
+		tsquery = "websearch_to_tsquery(" + b.arg(r.language) + "::regconfig, " + b.arg(query.Search) + ")"
+		b.where("items.search @@ " + tsquery)
+	}
+	if len(query.CategoryIDs) > 0 && query.IncludeSubcategories {
+		b.where(`items.category IN (
+	    WITH RECURSIVE subtree AS (
+	        SELECT id FROM categories WHERE id = ANY(` + b.arg(query.CategoryIDs) + `)
+	        UNION
+	        SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+	    )
+	    SELECT id FROM subtree
+	)`)
+	} else if len(query.CategoryIDs) > 0 {
+		b.where("items.category = ANY(" + b.arg(query.CategoryIDs) + ")")
+	}
+	if query.MinPrice != nil {
+		b.where("items.price >= " + b.arg(*query.MinPrice))
+	}
+	if query.MaxPrice != nil {
+		b.where("items.price <= " + b.arg(*query.MaxPrice))
+	}
+	// sorted so the same query always gives the same sql
+	names := []string{}
+	for name := range query.Attributes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		b.where("items.attributes->>" + b.arg(name) + " = ANY(" + b.arg(query.Attributes[name]) + ")")
+	}
+	return tsquery
+}
+
+func (r *ItemRepository) itemsSQL(query *domain.ItemQuery) (string, []any, string) {
+	b := &queryBuilder{}
+	tsquery := r.itemFilter(b, query)
+
+	columns := `items.id, items.title, items.description, items.price, items.category, categories.title, items.hidden,
+	NULLIF(items.attributes, '{}'), items.version`
+	if tsquery != "" {
+		// the text is escaped before ts_headline so the <b> tags are the only markup
+		language := b.arg(r.language) + "::regconfig"
+		columns += fmt.Sprintf(`, ts_rank(items.search, %[2]s),
+	ts_headline(%[1]s, %[3]s, %[2]s, 'HighlightAll=true'),
+	ts_headline(%[1]s, %[4]s, %[2]s, '%[5]s')`,
+			language, tsquery, escapeHTML("items.title"), escapeHTML("items.description"), headlineOptions)
+	}
+
+	orderBy := itemOrder(b, query.SortKey(), query.Cursor, tsquery)
+
+	sqlString := "SELECT " + columns + `
+	FROM items
+	JOIN categories ON items.category = categories.id` + b.whereClause() + "\nORDER BY " + orderBy
+	if query.Limit > 0 {
+		sqlString += "\nLIMIT " + b.arg(query.Limit)
+	}
+	if query.Offset > 0 {
+		sqlString += "\nOFFSET " + b.arg(query.Offset)
+	}
+	return sqlString, b.args, tsquery
+}
+
+func scanItems(rows pgx.Rows, search bool) (*[]domain.Item, error) {
+	defer rows.Close()
+
+	items := []domain.Item{}
 	for rows.Next() {
 		item := domain.Item{}
-		err := rows.Scan(&item.ID, &item.Title, &item.Description, &item.Price, &item.CategoryID, &item.CategoryTitle)
+		dest := []any{
+			&item.ID, &item.Title, &item.Description, &item.Price, &item.CategoryID, &item.CategoryTitle, &item.Hidden,
+			&item.Attributes, &item.Version,
+		}
+		if search {
+			item.Highlight = &domain.ItemHighlight{}
+			dest = append(dest, &item.Rank, &item.Highlight.Title, &item.Highlight.Description)
+		}
+		err := rows.Scan(dest...)
 		if err != nil {
 			return nil, err
 		}
 		items = append(items, item)
 	}
-	return &items, nil
+	return &items, rows.Err()
+}
+
+// counts of all items matching the filters, ignoring the sort and page,
+// one row of facet, key, value and count per category, price bucket and attribute value
+// plus one with the total
+func (r *ItemRepository) facetsSQL(query *domain.ItemQuery) (string, []any) {
+	b := &queryBuilder{}
+	r.itemFilter(b, query)
+
+	sqlString := `WITH filtered AS (
+	    SELECT categories.id AS category, categories.title AS category_title, items.price, items.attributes
+	    FROM items
+	    JOIN categories ON items.category = categories.id` + b.whereClause() + `
+	)
+	SELECT 'total', '', '', count(*) FROM filtered
+	UNION ALL
+	SELECT 'category', category::text, category_title, count(*) FROM filtered GROUP BY category, category_title
+	UNION ALL
+	SELECT 'price', width_bucket(price, ` + b.arg(domain.PriceBuckets) + `::int[])::text, '', count(*)
+	FROM filtered WHERE price IS NOT NULL GROUP BY 2
+	UNION ALL
+	SELECT 'attribute', attribute.key, attribute.value, count(*)
+	FROM filtered, jsonb_each_text(filtered.attributes) AS attribute
+	GROUP BY attribute.key, attribute.value
+	ORDER BY 4 DESC, 3`
+	return sqlString, b.args
+}
+
+func scanFacets(rows pgx.Rows) (*domain.ItemFacets, error) {
+	defer rows.Close()
+
+	facets := &domain.ItemFacets{Prices: []domain.PriceFacet{}, Attributes: map[string][]domain.AttributeFacet{}}
+	for i, from := range domain.PriceBuckets {
+		facet := domain.PriceFacet{From: from}
+		if i+1 < len(domain.PriceBuckets) {
+			to := domain.PriceBuckets[i+1]
+			facet.To = &to
+		}
+		facets.Prices = append(facets.Prices, facet)
+	}
+	facets.Categories = []domain.CategoryFacet{}
+
+	for rows.Next() {
+		var facet, key, value string
+		var count int
+		err := rows.Scan(&facet, &key, &value, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		switch facet {
+		case "total":
+			facets.Total = count
+		case "category":
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, err
+			}
+			facets.Categories = append(facets.Categories, domain.CategoryFacet{ID: id, Title: value, Count: count})
+		case "price":
+			// bucket 0 is below the first bound, the bounds start at 0 so only negative prices end up there
+			bucket, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, err
+			}
+			if bucket > 0 {
+				facets.Prices[bucket-1].Count = count
+			}
+		case "attribute":
+			facets.Attributes[key] = append(facets.Attributes[key], domain.AttributeFacet{Value: value, Count: count})
+		}
+	}
+	return facets, rows.Err()
+}
+
+// lower than the default of 0.6 so a typo or two in a short word still matches
+const suggestSimilarityThreshold = 0.3
+
+// item titles and category names that contain words similar to what the user has typed,
+// in any of its variants, prefix matches first then by similarity.
+// the query is canceled when the deadline of ctx passes
+func (r *ItemRepository) SuggestItems(ctx context.Context, variants []string, limit int) (*[]domain.Suggestion, error) {
+	suggestions := []domain.Suggestion{}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(context.Background())
+
+	// statement_timeout stops the query on the server too, not only the wait for it
+	timeout := "0"
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)
+	}
+	_, err = tx.Exec(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true),
+	set_config('statement_timeout', $2, true)`,
+		strconv.FormatFloat(suggestSimilarityThreshold, 'f', -1, 64), timeout)
+	if err != nil {
+		return nil, suggestError(err)
+	}
+
+	// <% is the operator of word_similarity that can use the trigram indexes
+	sqlString := `WITH variants AS (SELECT unnest($1::text[]) AS q)
+	SELECT type, id, title FROM (
+	    SELECT 'item' AS type, items.id, items.title,
+	        max(word_similarity(variants.q, lower(items.title)) + starts_with(lower(items.title), variants.q)::int) AS score
+	    FROM items
+	    JOIN variants ON variants.q <% lower(items.title)
+	    WHERE NOT items.hidden
+	    GROUP BY items.id
+	    UNION ALL
+	    SELECT 'category', categories.id, categories.title,
+	        max(word_similarity(variants.q, lower(categories.title)) + starts_with(lower(categories.title), variants.q)::int)
+	    FROM categories
+	    JOIN variants ON variants.q <% lower(categories.title)
+	    GROUP BY categories.id
+	) matches
+	ORDER BY score DESC, title, id
+	LIMIT $2`
+
+	rows, err := tx.Query(ctx, sqlString, variants, limit)
+	if err != nil {
+		return nil, suggestError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		suggestion := domain.Suggestion{}
+		err := rows.Scan(&suggestion.Type, &suggestion.ID, &suggestion.Title)
+		if err != nil {
+			return nil, suggestError(err)
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return &suggestions, suggestError(rows.Err())
+}
+
+func suggestError(err error) error {
+	if isTimeout(err) {
+		return fmt.Errorf("%w: %w", domain.ErrSuggestTimeout, err)
+	}
+	return err
+}
+
+// sql expression of column escaped for html
+func escapeHTML(column string) string {
+	return "replace(replace(replace(coalesce(" + column + ", ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;')"
+}
+
+// returns the ORDER BY of the sort key and adds the keyset condition of the cursor,
+// the id breaks ties so the order is always the same
+func itemOrder(b *queryBuilder, sort string, cursor *domain.ItemCursor, tsquery string) string {
+	var columns []string
+	var after []any
+	switch sort {
+	case domain.ItemSortPrice, domain.ItemSortPriceDesc:
+		columns = []string{"items.price", "items.id"}
+		if cursor != nil {
+			after = []any{cursor.Price, cursor.ID}
+		}
+	case domain.ItemSortTitle, domain.ItemSortTitleDesc:
+		columns = []string{"items.title", "items.id"}
+		if cursor != nil {
+			after = []any{cursor.Title, cursor.ID}
+		}
+	case domain.ItemSortRelevance:
+		columns = []string{"ts_rank(items.search, " + tsquery + ")", "items.id"}
+		if cursor != nil {
+			after = []any{cursor.Rank, cursor.ID}
+		}
+	default:
+		columns = []string{"items.id"}
+		if cursor != nil {
+			after = []any{cursor.ID}
+		}
+	}
+
+	direction, comparison := "ASC", ">"
+	if sort == domain.ItemSortPriceDesc || sort == domain.ItemSortTitleDesc || sort == domain.ItemSortNewest ||
+		sort == domain.ItemSortRelevance {
+		direction, comparison = "DESC", "<"
+	}
+
+	if after != nil {
+		placeholders := []string{}
+		for _, value := range after {
+			placeholders = append(placeholders, b.arg(value))
+		}
+		b.where(fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), comparison, strings.Join(placeholders, ", ")))
+	}
+	return strings.Join(columns, " "+direction+", ") + " " + direction
 }
 
 func (r *ItemRepository) DeleteItem(ctx context.Context, id int) error {
@@ -93,6 +537,9 @@ func (r *ItemRepository) DeleteItem(ctx context.Context, id int) error {
 
 	deleteItemsSQL := "DELETE FROM items WHERE id = $1"
 	_, err = r.db.Exec(ctx, deleteItemsSQL, id)
+	if isForeignKeyViolation(err, "inventory_adjustments_item_fkey") || isForeignKeyViolation(err, "stock_transfers_item_fkey") {
+		return domain.ErrItemInUse
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,108 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// failed logins shared by all instances, the postgres throttle.Store
+type LoginAttemptRepository struct {
+	db Pool
+}
+
+func NewLoginAttemptRepository(db Pool, allTables *map[string]struct{}) (*LoginAttemptRepository, error) {
+	_, ok := (*allTables)["login_attempts"]
+	if !ok {
+		sqlString := `CREATE TABLE login_attempts
+        (
+            key text primary key,
+            failures int not null,
+            last_failure timestamptz not null,
+            locked_until timestamptz
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &LoginAttemptRepository{db: db}, nil
+}
+
+func (r *LoginAttemptRepository) Get(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	attempts := &domain.LoginAttempts{Key: key}
+	var lockedUntil *time.Time
+	sqlString := "SELECT failures, last_failure, locked_until FROM login_attempts WHERE key = $1"
+	err := r.db.QueryRow(ctx, sqlString, key).Scan(&attempts.Failures, &attempts.LastFailure, &lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return attempts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil != nil {
+		attempts.LockedUntil = *lockedUntil
+	}
+	return attempts, nil
+}
+
+// the row is locked while it's read and counted, so parallel attempts for the key are counted one after another
+func (r *LoginAttemptRepository) Reserve(ctx context.Context, key string, now time.Time, window time.Duration) (*domain.LoginAttempts, time.Time, error) {
+	attempts := &domain.LoginAttempts{Key: key}
+	var lockedUntil, previous *time.Time
+	sqlString := `WITH previous AS (SELECT last_failure FROM login_attempts WHERE key = $1 FOR UPDATE)
+    INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 1, $2)
+    ON CONFLICT (key) DO UPDATE SET
+        failures = CASE WHEN login_attempts.last_failure < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+        last_failure = EXCLUDED.last_failure
+    RETURNING failures, last_failure, locked_until, (SELECT last_failure FROM previous)`
+	err := r.db.QueryRow(ctx, sqlString, key, now, now.Add(-window)).Scan(
+		&attempts.Failures, &attempts.LastFailure, &lockedUntil, &previous,
+	)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if lockedUntil != nil {
+		attempts.LockedUntil = *lockedUntil
+	}
+	if previous == nil {
+		return attempts, time.Time{}, nil
+	}
+	return attempts, *previous, nil
+}
+
+func (r *LoginAttemptRepository) Release(ctx context.Context, key string, reserved time.Time, previous time.Time) error {
+	var restored *time.Time
+	if !previous.IsZero() {
+		restored = &previous
+	}
+	sqlString := `UPDATE login_attempts SET failures = GREATEST(failures - 1, 0),
+        last_failure = CASE WHEN last_failure = $2 THEN COALESCE($3, last_failure) ELSE last_failure END
+    WHERE key = $1`
+	_, err := r.db.Exec(ctx, sqlString, key, reserved, restored)
+	return err
+}
+
+func (r *LoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.Exec(ctx, "UPDATE login_attempts SET locked_until = $2 WHERE key = $1", key, until)
+	return err
+}
+
+func (r *LoginAttemptRepository) DeleteExpired(ctx context.Context, before time.Time, now time.Time) (int64, error) {
+	sqlString := "DELETE FROM login_attempts WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < $2)"
+	tag, err := r.db.Exec(ctx, sqlString, before, now)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *LoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
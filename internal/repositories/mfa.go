@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// totp secrets and recovery codes, created by NewUserRepository
+func createMFATables(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["user_mfa"]
+	if !ok {
+		sqlString := `CREATE TABLE user_mfa
+        (
+            user_id int primary key,
+            secret text not null,
+            confirmed bool not null default false,
+            last_step bigint not null default 0,
+            created_at timestamptz not null default now(),
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ok = (*allTables)["mfa_recovery_codes"]
+	if !ok {
+		sqlString := `CREATE TABLE mfa_recovery_codes
+        (
+            code_hash text primary key,
+            user_id int not null,
+            used_at timestamptz,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stores a new unconfirmed secret, replacing an earlier unconfirmed one
+func (r *UserRepository) SaveMFASecret(ctx context.Context, userID int, secret string) error {
+	sqlString := `INSERT INTO user_mfa (user_id, secret) VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = now()
+    WHERE NOT user_mfa.confirmed`
+	tag, err := r.db.Exec(ctx, sqlString, userID, secret)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *UserRepository) GetMFA(ctx context.Context, userID int) (*domain.UserMFA, error) {
+	mfa := &domain.UserMFA{}
+	sqlString := "SELECT user_id, secret, confirmed, last_step FROM user_mfa WHERE user_id = $1"
+	err := r.db.QueryRow(ctx, sqlString, userID).Scan(&mfa.UserID, &mfa.Secret, &mfa.Confirmed, &mfa.LastStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// enables mfa and replaces the recovery codes of the user
+func (r *UserRepository) ConfirmMFA(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	tag, err := tx.Exec(ctx, "UPDATE user_mfa SET confirmed = true WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrMFANotEnrolled
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.Exec(ctx, "INSERT INTO mfa_recovery_codes (code_hash, user_id) VALUES ($1, $2)", hash, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// records that a code for the step was used, returns false if this or a later step already was
+func (r *UserRepository) UseMFAStep(ctx context.Context, userID int, step int64) (bool, error) {
+	tag, err := r.db.Exec(ctx, "UPDATE user_mfa SET last_step = $2 WHERE user_id = $1 AND last_step < $2", userID, step)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// marks the recovery code as used, returns false if it is unknown or was used before
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	sqlString := `UPDATE mfa_recovery_codes SET used_at = now()
+    WHERE code_hash = $1 AND user_id = $2 AND used_at IS NULL`
+	tag, err := r.db.Exec(ctx, sqlString, hash, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (r *UserRepository) DeleteMFA(ctx context.Context, userID int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
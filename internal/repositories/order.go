@@ -2,7 +2,10 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"tefsi/internal/domain"
+
+	"github.com/jackc/pgx/v4"
 )
 
 type OrderRepository struct {
@@ -17,14 +20,26 @@ func NewOrderRepository(db Pool, allTables *map[string]struct{}) (*OrderReposito
                 id serial primary key,
                 title text
             )`
-		db.Exec(context.Background(), "INSERT INTO statuses (id, title) VALUES (1, 'in progress')")
-		db.Exec(context.Background(), "INSERT INTO statuses (id, title) VALUES (2, 'ready')")
 		_, err := db.Exec(context.Background(), sqlString)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	// the statuses the code refers to are added on every start, older databases may miss some
+	sqlString := `INSERT INTO statuses (id, title) VALUES ($1, 'in progress'), ($2, 'ready'), ($3, 'cancelled')
+    ON CONFLICT (id) DO NOTHING`
+	_, err := db.Exec(context.Background(), sqlString, domain.StatusInProgress, domain.StatusReady, domain.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	// the ids were set explicitly, new statuses continue after them
+	sqlString = `SELECT setval('statuses_id_seq', GREATEST((SELECT max(id) FROM statuses), (SELECT last_value FROM statuses_id_seq)))`
+	_, err = db.Exec(context.Background(), sqlString)
+	if err != nil {
+		return nil, err
+	}
+
 	_, ok = (*allTables)["orders"]
 	if !ok {
 		sqlString := `CREATE TABLE orders
@@ -32,6 +47,9 @@ func NewOrderRepository(db Pool, allTables *map[string]struct{}) (*OrderReposito
             id serial primary key,
             status int,
             user_id int,
+            contact_email text,
+            shipping_address jsonb,
+            billing_address jsonb,
             FOREIGN KEY (status) REFERENCES statuses(id),
             FOREIGN KEY (user_id) REFERENCES users(id)
         )`
@@ -41,15 +59,26 @@ func NewOrderRepository(db Pool, allTables *map[string]struct{}) (*OrderReposito
 		}
 	}
 
+	err = migrate(db,
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS contact_email text",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_address jsonb",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS billing_address jsonb",
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	_, ok = (*allTables)["items_orders"]
 	if !ok {
 		sqlString := `CREATE TABLE items_orders
         (
             id serial primary key,
             item int,
+            variant int,
             amount int,
             order_id int,
             FOREIGN KEY (item) REFERENCES items(id),
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id),
             FOREIGN KEY (order_id) REFERENCES orders(id)
         )`
 
@@ -59,26 +88,72 @@ func NewOrderRepository(db Pool, allTables *map[string]struct{}) (*OrderReposito
 		}
 	}
 
+	err = migrateColumn(db, "items_orders", "variant",
+		"ALTER TABLE items_orders ADD COLUMN variant int",
+		`ALTER TABLE items_orders ADD CONSTRAINT items_orders_item_variant_fkey
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return &OrderRepository{db: db}, nil
 }
 
+// columns read by scanOrder, in the same order
+const orderColumns = `orders.id, orders.status, COALESCE(orders.user_id, 0), COALESCE(orders.contact_email, ''),
+    orders.shipping_address, orders.billing_address`
+
+func scanOrder(row pgx.Row, order *domain.Order) error {
+	return row.Scan(
+		&order.ID, &order.StatusID, &order.UserID, &order.ContactEmail,
+		&order.ShippingAddress, &order.BillingAddress,
+	)
+}
+
+// places the order and reserves its items, nothing is saved if any line is out of stock
 func (r *OrderRepository) CreateOrder(ctx context.Context, order *domain.Order) error {
-	orderSQL := "INSERT INTO orders (status, user_id) VALUES ($1, $2) RETURNING id"
-	err := r.db.QueryRow(ctx, orderSQL, order.StatusID, order.UserID).Scan(&order.ID)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(context.Background())
 
-	itemSQL := "INSERT into items_orders (item, order_id, amount) VALUES ($1, $2, $3)"
+	orderSQL := `INSERT INTO orders (status, user_id, contact_email, shipping_address, billing_address)
+    VALUES ($1, NULLIF($2, 0), NULLIF($3, ''), $4, $5) RETURNING id`
+	err = tx.QueryRow(
+		ctx, orderSQL, order.StatusID, order.UserID, order.ContactEmail, order.ShippingAddress, order.BillingAddress,
+	).Scan(&order.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertOrderItems(ctx, tx, order)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// adds the lines of the order and reserves their stock
+func insertOrderItems(ctx context.Context, tx pgx.Tx, order *domain.Order) error {
+	itemSQL := "INSERT into items_orders (item, variant, order_id, amount) VALUES ($1, NULLIF($2, 0), $3, $4)"
 
 	for i := range order.Items {
-		_, err := r.db.Exec(ctx, itemSQL, order.Items[i].ItemID, order.ID, order.Items[i].Amount)
+		err := checkVariant(ctx, tx, &order.Items[i])
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, itemSQL, order.Items[i].ItemID, order.Items[i].VariantID, order.ID, order.Items[i].Amount)
+		if isForeignKeyViolation(err, "items_orders_item_fkey") {
+			return domain.ErrItemNotFound
+		}
 		if err != nil {
 			return err
 		}
 	}
 
-	return err
+	return reserveStock(ctx, tx, order.ID, order.Items)
 }
 
 // s dnem prikolov
@@ -95,7 +170,7 @@ func (r *OrderRepository) getStatusTitleAndItems(ctx context.Context, order *dom
 		return "", nil, err
 	}
 
-	itemsSQL := `SELECT items_orders.item, items_orders.amount
+	itemsSQL := `SELECT items_orders.item, COALESCE(items_orders.variant, 0), items_orders.amount
     FROM items_orders
     WHERE items_orders.order_id = $1`
 
@@ -109,7 +184,7 @@ func (r *OrderRepository) getStatusTitleAndItems(ctx context.Context, order *dom
 	for itemsRows.Next() {
 		item := domain.ItemWithAmount{}
 
-		err := itemsRows.Scan(&item.ItemID, &item.Amount)
+		err := itemsRows.Scan(&item.ItemID, &item.VariantID, &item.Amount)
 
 		if err != nil {
 			return "", nil, err
@@ -124,11 +199,14 @@ func (r *OrderRepository) getStatusTitleAndItems(ctx context.Context, order *dom
 func (r *OrderRepository) GetOrderByID(ctx context.Context, id int) (*domain.Order, error) {
 	order := domain.Order{}
 
-	sqlString := `SELECT orders.id, orders.status, orders.user_id
+	sqlString := "SELECT " + orderColumns + `
     FROM orders
     WHERE orders.id = $1`
 
-	err := r.db.QueryRow(ctx, sqlString, id).Scan(&order.ID, &order.StatusID, &order.UserID)
+	err := scanOrder(r.db.QueryRow(ctx, sqlString, id), &order)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrOrderNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -142,13 +220,18 @@ func (r *OrderRepository) GetOrderByID(ctx context.Context, id int) (*domain.Ord
 	order.StatusTitle = statusTitle
 	order.Items = *items
 
+	order.Allocations, err = getAllocations(ctx, r.db, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return &order, nil
 }
 
 func (r *OrderRepository) GetOrders(ctx context.Context) (*[]domain.Order, error) {
 	var orders []domain.Order
 
-	sqlString := "SELECT orders.id, orders.status, orders.user_id FROM orders"
+	sqlString := "SELECT " + orderColumns + " FROM orders"
 
 	rows, err := r.db.Query(ctx, sqlString)
 	if err != nil {
@@ -157,7 +240,7 @@ func (r *OrderRepository) GetOrders(ctx context.Context) (*[]domain.Order, error
 
 	for rows.Next() {
 		order := domain.Order{}
-		err := rows.Scan(&order.ID, &order.StatusID, &order.UserID)
+		err := scanOrder(rows, &order)
 		if err != nil {
 			return nil, err
 		}
@@ -176,12 +259,28 @@ func (r *OrderRepository) GetOrders(ctx context.Context) (*[]domain.Order, error
 	return &orders, nil
 }
 
+// sets the status and replaces the lines of an order that isn't cancelled,
+// the stock of the old lines is released and the new ones are reserved
 func (r *OrderRepository) UpdateOrder(ctx context.Context, order *domain.Order) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
 	ordersSQL := `UPDATE orders
-    SET orders.status = $1, orders.user_id = $2
-    WHERE orders.id = $3`
+    SET status = COALESCE(NULLIF($1, 0), status)
+    WHERE id = $2 AND status <> $3`
 
-	_, err := r.db.Exec(ctx, ordersSQL, order.StatusID, order.StatusTitle, order.ID)
+	tag, err := tx.Exec(ctx, ordersSQL, order.StatusID, order.ID, domain.StatusCancelled)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return orderNotChanged(ctx, tx, order.ID, domain.ErrOrderCancelled)
+	}
+
+	err = releaseStock(ctx, tx, order.ID)
 	if err != nil {
 		return err
 	}
@@ -189,38 +288,84 @@ func (r *OrderRepository) UpdateOrder(ctx context.Context, order *domain.Order)
 	deleteItemsSQL := `DELETE FROM items_orders
     WHERE order_id = $1`
 
-	_, err = r.db.Exec(ctx, deleteItemsSQL, order.ID)
+	_, err = tx.Exec(ctx, deleteItemsSQL, order.ID)
 	if err != nil {
 		return err
 	}
 
-	addItemSQL := "INSERT into items_orders (item, order_id, amount) VALUES ($1, $2, $3)"
+	err = insertOrderItems(ctx, tx, order)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
 
-	for i := range order.Items {
-		_, err := r.db.Exec(ctx, addItemSQL, order.Items[i].ItemID, order.ID, order.Items[i].Amount)
-		if err != nil {
-			return err
-		}
+// cancels an order in progress and puts its items back in stock
+func (r *OrderRepository) CancelOrder(ctx context.Context, id int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	sqlString := "UPDATE orders SET status = $2 WHERE id = $1 AND status = $3"
+	tag, err := tx.Exec(ctx, sqlString, id, domain.StatusCancelled, domain.StatusInProgress)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return orderNotChanged(ctx, tx, id, domain.ErrOrderNotCancellable)
 	}
 
-	return nil
+	err = releaseStock(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// the error for an order whose update matched no rows, notChanged if the order exists
+func orderNotChanged(ctx context.Context, tx pgx.Tx, id int, notChanged error) error {
+	var exists bool
+	err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrOrderNotFound
+	}
+	return notChanged
 }
 
+// deletes the order, whatever it still has reserved goes back in stock
 func (r *OrderRepository) DeleteOrder(ctx context.Context, id int) error {
-	itemsSQL := "DELETE FROM orders WHERE id = $1"
-	_, err := r.db.Exec(ctx, itemsSQL, id)
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	err = releaseStock(ctx, tx, id)
 	if err != nil {
 		return err
 	}
 
-	itemsOrdersSQL := "DELETE FROM items_orders WHERE item = $1"
-	_, err = r.db.Exec(ctx, itemsOrdersSQL, id)
+	itemsOrdersSQL := "DELETE FROM items_orders WHERE order_id = $1"
+	_, err = tx.Exec(ctx, itemsOrdersSQL, id)
+	if err != nil {
+		return err
+	}
 
-	return err
+	ordersSQL := "DELETE FROM orders WHERE id = $1"
+	_, err = tx.Exec(ctx, ordersSQL, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (r *OrderRepository) GetOrdersByUserID(ctx context.Context, id int) (*[]domain.Order, error) {
-	sqlString := `Select orders.id, orders.status
+	sqlString := "SELECT " + orderColumns + `
     FROM orders
     WHERE orders.user_id = $1`
 
@@ -232,8 +377,8 @@ func (r *OrderRepository) GetOrdersByUserID(ctx context.Context, id int) (*[]dom
 	orders := []domain.Order{}
 
 	for rows.Next() {
-		order := domain.Order{UserID: id}
-		err := rows.Scan(&order.ID, &order.StatusID)
+		order := domain.Order{}
+		err := scanOrder(rows, &order)
 		if err != nil {
 			return nil, err
 		}
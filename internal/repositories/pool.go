@@ -2,6 +2,8 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
@@ -11,4 +13,104 @@ type Pool interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+}
+
+// brings tables created by an older version up to date on every start,
+// so each statement has to be a no-op once it has been applied
+func migrate(db Pool, statements ...string) error {
+	for _, statement := range statements {
+		_, err := db.Exec(context.Background(), statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reports whether the table has the column, for migrations that can't be written idempotently
+func hasColumn(db Pool, table string, column string) (bool, error) {
+	var exists bool
+	sqlString := `SELECT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+    )`
+	err := db.QueryRow(context.Background(), sqlString, table, column).Scan(&exists)
+	return exists, err
+}
+
+// runs the statements in one transaction unless the table already has the column,
+// for columns that come with constraints ADD COLUMN IF NOT EXISTS can't add
+func migrateColumn(db Pool, table string, column string, statements ...string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	return migrateTx(db, statements...)
+}
+
+// runs the statements in one transaction, for migrations that mustn't stop halfway
+func migrateTx(db Pool, statements ...string) error {
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	for _, statement := range statements {
+		_, err := tx.Exec(context.Background(), statement)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(context.Background())
+}
+
+// replaces a foreign key that older versions created with ON DELETE CASCADE,
+// definition is the new one without the constraint name
+func replaceCascadingForeignKey(db Pool, table string, constraint string, definition string) error {
+	var cascades bool
+	sqlString := `SELECT EXISTS (
+        SELECT 1 FROM pg_constraint WHERE conrelid = $1::regclass AND conname = $2 AND confdeltype = 'c'
+    )`
+	err := db.QueryRow(context.Background(), sqlString, table, constraint).Scan(&cascades)
+	if err != nil || !cascades {
+		return err
+	}
+
+	sqlString = fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s, ADD CONSTRAINT %s %s", table, constraint, constraint, definition)
+	_, err = db.Exec(context.Background(), sqlString)
+	return err
+}
+
+// reports whether the index exists, for migrations that have to prepare the data first
+func hasIndex(db Pool, name string) (bool, error) {
+	var exists bool
+	err := db.QueryRow(context.Background(), "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists)
+	return exists, err
+}
+
+// reports whether err is a foreign_key_violation of the given constraint
+func isForeignKeyViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503" && pgErr.ConstraintName == constraint
+}
+
+// reports whether the query was canceled by its context or by statement_timeout
+func isTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return pgconn.Timeout(err) || (errors.As(err, &pgErr) && pgErr.Code == "57014")
+}
+
+// reports whether err is a unique_violation of the given constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// reports whether err is a check_violation of the given constraint
+func isCheckViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23514" && pgErr.ConstraintName == constraint
 }
@@ -0,0 +1,30 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+)
+
+// builds the WHERE part of a query from conditions added one by one,
+// arguments are numbered in the order they are added
+type queryBuilder struct {
+	conditions []string
+	args       []any
+}
+
+// adds an argument and returns its placeholder
+func (b *queryBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *queryBuilder) whereClause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return "\nWHERE " + strings.Join(b.conditions, "\n    AND ")
+}
@@ -5,4 +5,10 @@ type AllRepositories struct {
 	UserRepository     *UserRepository
 	OrderRepository    *OrderRepository
 	CategoryRepository *CategoryRepository
+	TokenRepository    *TokenRepository
+	APIKeyRepository   *APIKeyRepository
+	// stock levels and the inventory ledger
+	InventoryRepository *InventoryRepository
+	// postgres store for throttle.Limiter
+	LoginAttemptRepository *LoginAttemptRepository
 }
@@ -0,0 +1,271 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// roles live next to users, so they are created by NewUserRepository
+func createRoleTables(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["permissions"]
+	if !ok {
+		sqlString := `CREATE TABLE permissions
+        (
+            name text primary key
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ok = (*allTables)["roles"]
+	if !ok {
+		sqlString := `CREATE TABLE roles
+        (
+            id serial primary key,
+            name text unique not null
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+
+		// default roles, more can be created with CreateRole
+		_, err = db.Exec(context.Background(), "INSERT INTO roles (name) VALUES ($1), ('fulfilment'), ('catalog')", domain.RoleAdmin)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ok = (*allTables)["role_permissions"]
+	if !ok {
+		sqlString := `CREATE TABLE role_permissions
+        (
+            role_id int,
+            permission text,
+            primary key (role_id, permission),
+            FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+            FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ok = (*allTables)["user_roles"]
+	if !ok {
+		sqlString := `CREATE TABLE user_roles
+        (
+            user_id int,
+            role_id int,
+            primary key (user_id, role_id),
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+            FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	// permissions are defined in code, so new ones are added on every start
+	for _, permission := range domain.AllPermissions {
+		_, err := db.Exec(context.Background(), "INSERT INTO permissions (name) VALUES ($1) ON CONFLICT DO NOTHING", permission)
+		if err != nil {
+			return err
+		}
+	}
+
+	defaultPermissions := map[string][]string{
+		domain.RoleAdmin: domain.AllPermissions,
+		"fulfilment":     {domain.PermissionOrdersRead, domain.PermissionOrdersFulfil, domain.PermissionInventoryManage},
+		"catalog":        {domain.PermissionCatalogWrite},
+	}
+	for role, permissions := range defaultPermissions {
+		for _, permission := range permissions {
+			sqlString := `INSERT INTO role_permissions (role_id, permission)
+            SELECT id, $2 FROM roles WHERE name = $1
+            ON CONFLICT DO NOTHING`
+			_, err := db.Exec(context.Background(), sqlString, role, permission)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return migrateAdminFlag(db)
+}
+
+// users.is_admin predates roles, admins of older databases get the admin role.
+// the column is dropped in the same transaction, so later demotions are not undone on restart
+func migrateAdminFlag(db Pool) error {
+	exists, err := hasColumn(db, "users", "is_admin")
+	if err != nil || !exists {
+		return err
+	}
+
+	tx, err := db.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	sqlString := `INSERT INTO user_roles (user_id, role_id)
+    SELECT users.id, roles.id FROM users JOIN roles ON roles.name = $1
+    WHERE users.is_admin
+    ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(context.Background(), sqlString, domain.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(context.Background(), "ALTER TABLE users DROP COLUMN is_admin")
+	if err != nil {
+		return err
+	}
+	return tx.Commit(context.Background())
+}
+
+// fills in roles and permissions of the user
+func (r *UserRepository) loadRoles(ctx context.Context, user *domain.User) error {
+	sqlString := `SELECT roles.name
+    FROM user_roles
+    JOIN roles ON user_roles.role_id = roles.id
+    WHERE user_roles.user_id = $1
+    ORDER BY roles.name`
+	rows, err := r.db.Query(ctx, sqlString, user.ID)
+	if err != nil {
+		return err
+	}
+	user.Roles = []string{}
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return err
+		}
+		user.Roles = append(user.Roles, role)
+		if role == domain.RoleAdmin {
+			user.IsAdmin = true
+		}
+	}
+
+	sqlString = `SELECT DISTINCT role_permissions.permission
+    FROM user_roles
+    JOIN role_permissions ON user_roles.role_id = role_permissions.role_id
+    WHERE user_roles.user_id = $1
+    ORDER BY role_permissions.permission`
+	rows, err = r.db.Query(ctx, sqlString, user.ID)
+	if err != nil {
+		return err
+	}
+	user.Permissions = []string{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return err
+		}
+		user.Permissions = append(user.Permissions, permission)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) getRoleID(ctx context.Context, name string) (int, error) {
+	var id int
+	err := r.db.QueryRow(ctx, "SELECT id FROM roles WHERE name = $1", name).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, domain.ErrUnknownRole
+	}
+	return id, err
+}
+
+func (r *UserRepository) AssignRole(ctx context.Context, userID int, role string) error {
+	roleID, err := r.getRoleID(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	sqlString := "INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+	_, err = r.db.Exec(ctx, sqlString, userID, roleID)
+	if isForeignKeyViolation(err, "user_roles_user_id_fkey") {
+		return domain.ErrUserNotFound
+	}
+	return err
+}
+
+func (r *UserRepository) RemoveRole(ctx context.Context, userID int, role string) error {
+	roleID, err := r.getRoleID(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, roleID)
+	return err
+}
+
+func (r *UserRepository) GetRoles(ctx context.Context) (*[]domain.Role, error) {
+	sqlString := `SELECT roles.id, roles.name, COALESCE(array_agg(role_permissions.permission ORDER BY role_permissions.permission)
+        FILTER (WHERE role_permissions.permission IS NOT NULL), '{}')
+    FROM roles
+    LEFT JOIN role_permissions ON roles.id = role_permissions.role_id
+    GROUP BY roles.id
+    ORDER BY roles.id`
+	rows, err := r.db.Query(ctx, sqlString)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []domain.Role{}
+	for rows.Next() {
+		role := domain.Role{}
+		err := rows.Scan(&role.ID, &role.Name, &role.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return &roles, nil
+}
+
+// creates the role with its permissions, a role is never left without them
+func (r *UserRepository) CreateRole(ctx context.Context, role *domain.Role) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	err = tx.QueryRow(ctx, "INSERT INTO roles (name) VALUES ($1) RETURNING id", role.Name).Scan(&role.ID)
+	if isUniqueViolation(err, "roles_name_key") {
+		return domain.ErrRoleExists
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range role.Permissions {
+		sqlString := "INSERT INTO role_permissions (role_id, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING"
+		_, err := tx.Exec(ctx, sqlString, role.ID, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) CountUsersWithRole(ctx context.Context, role string) (int, error) {
+	var count int
+	sqlString := `SELECT count(*)
+    FROM user_roles
+    JOIN roles ON user_roles.role_id = roles.id
+    WHERE roles.name = $1`
+	err := r.db.QueryRow(ctx, sqlString, role).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,270 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+type TokenRepository struct {
+	db Pool
+}
+
+func NewTokenRepository(db Pool, allTables *map[string]struct{}) (*TokenRepository, error) {
+	_, ok := (*allTables)["refresh_tokens"]
+	if !ok {
+		sqlString := `CREATE TABLE refresh_tokens
+        (
+            token_hash text primary key,
+            family_id text not null,
+            user_id int not null,
+            expires_at timestamptz not null,
+            used_at timestamptz,
+            revoked_at timestamptz,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = db.Exec(context.Background(), "CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id)")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, ok = (*allTables)["revoked_tokens"]
+	if !ok {
+		sqlString := `CREATE TABLE revoked_tokens
+        (
+            jti text primary key,
+            expires_at timestamptz not null
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, ok = (*allTables)["sessions"]
+	if !ok {
+		sqlString := `CREATE TABLE sessions
+        (
+            id text primary key,
+            user_id int not null,
+            user_agent text not null default '',
+            ip text not null default '',
+            created_at timestamptz not null default now(),
+            last_seen_at timestamptz not null default now(),
+            expires_at timestamptz not null,
+            revoked_at timestamptz,
+            FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, ok = (*allTables)["oidc_states"]
+	if !ok {
+		sqlString := `CREATE TABLE oidc_states
+        (
+            state_hash text primary key,
+            provider text not null,
+            nonce text not null,
+            code_verifier text not null,
+            expires_at timestamptz not null
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &TokenRepository{db: db}, nil
+}
+
+func (r *TokenRepository) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	sqlString := `INSERT INTO refresh_tokens (token_hash, family_id, user_id, expires_at)
+    VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(ctx, sqlString, token.Hash, token.FamilyID, token.UserID, token.ExpiresAt)
+	return err
+}
+
+func (r *TokenRepository) GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	token := &domain.RefreshToken{}
+	sqlString := `SELECT token_hash, family_id, user_id, expires_at, used_at, revoked_at
+    FROM refresh_tokens
+    WHERE token_hash = $1`
+	err := r.db.QueryRow(ctx, sqlString, hash).Scan(
+		&token.Hash, &token.FamilyID, &token.UserID, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// marks the token as used, returns false if it was already used or revoked,
+// so two concurrent refreshes with the same token can't both succeed
+func (r *TokenRepository) MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error) {
+	sqlString := `UPDATE refresh_tokens
+    SET used_at = now()
+    WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL`
+	tag, err := r.db.Exec(ctx, sqlString, hash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// revokes the refresh tokens and the session of the family,
+// access tokens of the session stop working right away
+func (r *TokenRepository) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	sqlString := `UPDATE refresh_tokens
+    SET revoked_at = now()
+    WHERE family_id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Exec(ctx, sqlString, familyID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "UPDATE sessions SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+// revokes every refresh token and session of the user, logging them out everywhere
+func (r *TokenRepository) RevokeUserTokens(ctx context.Context, userID int) error {
+	sqlString := `UPDATE refresh_tokens
+    SET revoked_at = now()
+    WHERE user_id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Exec(ctx, sqlString, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(ctx, "UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// puts the access token id on the revocation list until the token expires anyway
+func (r *TokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < now()")
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO revoked_tokens (jti, expires_at)
+    VALUES ($1, $2)
+    ON CONFLICT (jti) DO NOTHING`
+	_, err = r.db.Exec(ctx, sqlString, jti, expiresAt)
+	return err
+}
+
+func (r *TokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+	return revoked, err
+}
+
+func (r *TokenRepository) SaveOIDCState(ctx context.Context, state *domain.OIDCState) error {
+	// abandoned logins are cleaned up here instead of in a background job
+	_, err := r.db.Exec(ctx, "DELETE FROM oidc_states WHERE expires_at < now()")
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, expires_at)
+    VALUES ($1, $2, $3, $4, $5)`
+	_, err = r.db.Exec(ctx, sqlString, state.Hash, state.Provider, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+// deletes and returns the state so every login can only be finished once
+func (r *TokenRepository) UseOIDCState(ctx context.Context, hash string) (*domain.OIDCState, error) {
+	state := &domain.OIDCState{}
+	sqlString := `DELETE FROM oidc_states
+    WHERE state_hash = $1 AND expires_at > now()
+    RETURNING state_hash, provider, nonce, code_verifier, expires_at`
+	err := r.db.QueryRow(ctx, sqlString, hash).Scan(
+		&state.Hash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (r *TokenRepository) CreateSession(ctx context.Context, session *domain.Session) error {
+	sqlString := `INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING created_at, last_seen_at`
+	return r.db.QueryRow(ctx, sqlString, session.ID, session.UserID, session.UserAgent, session.IP, session.ExpiresAt).
+		Scan(&session.CreatedAt, &session.LastSeenAt)
+}
+
+const sessionColumns = "id, user_id, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at"
+
+func scanSession(row pgx.Row, session *domain.Session) error {
+	return row.Scan(
+		&session.ID, &session.UserID, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.RevokedAt,
+	)
+}
+
+func (r *TokenRepository) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	session := &domain.Session{}
+	err := scanSession(r.db.QueryRow(ctx, "SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id), session)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// sessions of the user that weren't revoked and haven't expired, most recently used first
+func (r *TokenRepository) GetUserSessions(ctx context.Context, userID int) (*[]domain.Session, error) {
+	sqlString := "SELECT " + sessionColumns + ` FROM sessions
+    WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+    ORDER BY last_seen_at DESC`
+	rows, err := r.db.Query(ctx, sqlString, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var session domain.Session
+		err = scanSession(rows, &session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return &sessions, rows.Err()
+}
+
+// records that the session was used, at most once a minute,
+// expiresAt moves the expiry when the session got a new refresh token
+func (r *TokenRepository) TouchSession(ctx context.Context, id string, expiresAt *time.Time) error {
+	sqlString := `UPDATE sessions
+    SET last_seen_at = now(), expires_at = COALESCE($2, expires_at)
+    WHERE id = $1 AND ($2 IS NOT NULL OR last_seen_at < now() - interval '1 minute')`
+	_, err := r.db.Exec(ctx, sqlString, id, expiresAt)
+	return err
+}
@@ -2,18 +2,40 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jackc/pgx/v4"
 
 	"tefsi/internal/domain"
 )
 
+// implemented by password.Hasher
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, hash string) (bool, error)
+	NeedsRehash(hash string) bool
+}
+
 type UserRepository struct {
-	db Pool
+	db     Pool
+	hasher PasswordHasher
+}
+
+// columns read by scanUser, in the same order
+const userColumns = `users.id, users.login, users.password, COALESCE(users.email, ''), users.email_verified,
+    users.name, users.phone, users.disabled, users.password_reset_required,
+    EXISTS (SELECT 1 FROM user_mfa WHERE user_mfa.user_id = users.id AND user_mfa.confirmed)`
+
+func scanUser(row pgx.Row, user *domain.User) error {
+	return row.Scan(
+		&user.ID, &user.Login, &user.Password, &user.Email, &user.EmailVerified,
+		&user.Name, &user.Phone, &user.Disabled, &user.PasswordResetRequired, &user.MFAEnabled,
+	)
 }
 
-func NewUserRepository(db Pool, allTables *map[string]struct{}) (*UserRepository, error) {
+func NewUserRepository(db Pool, allTables *map[string]struct{}, hasher PasswordHasher) (*UserRepository, error) {
 	_, ok := (*allTables)["users"]
 	if !ok {
 		sqlString := `CREATE TABLE users
@@ -21,7 +43,13 @@ func NewUserRepository(db Pool, allTables *map[string]struct{}) (*UserRepository
 			id serial primary key,
 			login text unique,
 			password text,
-			is_admin bool
+			email text,
+			email_verified bool not null default false,
+			name text not null default '',
+			phone text not null default '',
+			disabled bool not null default false,
+			password_reset_required bool not null default false,
+			created_at timestamptz not null default now()
 		)`
 		_, err := db.Exec(context.Background(), sqlString)
 		if err != nil {
@@ -29,15 +57,59 @@ func NewUserRepository(db Pool, allTables *map[string]struct{}) (*UserRepository
 		}
 	}
 
+	// only verified addresses are unique, so an unverified claim can't lock the owner out
+	err := migrate(db,
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS email text",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified bool not null default false",
+		"ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key",
+		"CREATE INDEX IF NOT EXISTS users_email_idx ON users (email)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS users_verified_email_idx ON users (email) WHERE email_verified",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS name text not null default ''",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS phone text not null default ''",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled bool not null default false",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required bool not null default false",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at timestamptz not null default now()",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createRoleTables(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createUserTokenTable(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createMFATables(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createIdentityTable(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createAddressTable(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
 	_, ok = (*allTables)["items_users"]
 	if !ok {
 		sqlString := `CREATE TABLE items_users
         (
             id serial primary key,
             item int,
+            variant int,
             amount int,
             user_id int,
             FOREIGN KEY (item) REFERENCES items(id),
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE CASCADE,
             FOREIGN KEY (user_id) REFERENCES users(id)
         )`
 		_, err := db.Exec(context.Background(), sqlString)
@@ -46,13 +118,38 @@ func NewUserRepository(db Pool, allTables *map[string]struct{}) (*UserRepository
 		}
 	}
 
-	return &UserRepository{db: db}, nil
+	err = migrateColumn(db, "items_users", "variant",
+		"ALTER TABLE items_users ADD COLUMN variant int",
+		`ALTER TABLE items_users ADD CONSTRAINT items_users_item_variant_fkey
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE CASCADE`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createUserCartIndex(db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createGuestCartTables(db, allTables)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserRepository{db: db, hasher: hasher}, nil
 }
 
 func (r *UserRepository) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
 	user := &domain.User{}
-	err := r.db.QueryRow(ctx, "SELECT id, login, password, is_admin FROM users WHERE id = $1", id).
-		Scan(&user.ID, &user.Login, &user.Password, &user.IsAdmin)
+	err := scanUser(r.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id), user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = r.loadRoles(ctx, user)
 	if err != nil {
 		return nil, err
 	}
@@ -70,14 +167,45 @@ func (r *UserRepository) UserExists(ctx context.Context, login string) error {
 	return nil
 }
 
+// checks the password of the user with the login, a correct password
+// hashed with an outdated algorithm or parameters gets rehashed
 func (r *UserRepository) CheckUserByDomain(ctx context.Context, user *domain.User) error {
+	var id int
 	var correctPassword string
-	err := r.db.QueryRow(ctx, "SELECT password FROM users WHERE login = $1", user.Login).
-		Scan(&correctPassword)
+	err := r.db.QueryRow(ctx, "SELECT id, password FROM users WHERE login = $1", user.Login).
+		Scan(&id, &correctPassword)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+
+	ok, err := r.hasher.Verify(user.Password, correctPassword)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return domain.ErrInvalidCredentials
+	}
+
+	if r.hasher.NeedsRehash(correctPassword) {
+		err = r.rehashPassword(ctx, id, user.Password, correctPassword)
+		// the password was correct, so the login shouldn't fail because of this
+		if err != nil {
+			log.Printf("failed to rehash password of user %d: %s", id, err.Error())
+		}
+	}
+	return nil
+}
+
+// replaces oldHash unless the password was changed concurrently
+func (r *UserRepository) rehashPassword(ctx context.Context, id int, password string, oldHash string) error {
+	hash, err := r.HashPassword(password)
 	if err != nil {
 		return err
 	}
-	err = r.CheckPasswordHash((*user).Password, correctPassword)
+	_, err = r.db.Exec(ctx, "UPDATE users SET password = $3 WHERE id = $1 AND password = $2", id, oldHash, hash)
 	return err
 }
 
@@ -87,27 +215,28 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *domain.User) erro
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec(ctx, "INSERT INTO users (login, password, is_admin) VALUES ($1, $2, $3)", user.Login, user.Password, user.IsAdmin)
-	return err
+	sqlString := "INSERT INTO users (login, password, email) VALUES ($1, $2, NULLIF($3, '')) RETURNING id"
+	return r.db.QueryRow(ctx, sqlString, user.Login, user.Password, user.Email).Scan(&user.ID)
 }
 
-// TODO: check that it works
 func (r *UserRepository) GetUserCartByID(ctx context.Context, id int) (*[]domain.ItemWithAmount, error) {
-	sqlString := `SELECT items_users.item, items_users.amount
+	sqlString := `SELECT items_users.item, COALESCE(items_users.variant, 0), items_users.amount
     FROM items_users
-    WHERE items_users.user = $1`
+    WHERE items_users.user_id = $1
+    ORDER BY items_users.id`
 
 	rows, err := r.db.Query(ctx, sqlString, id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	items := []domain.ItemWithAmount{}
 
 	for rows.Next() {
 		item := domain.ItemWithAmount{}
 
-		err := rows.Scan(&item.ItemID, &item.Amount)
+		err := rows.Scan(&item.ItemID, &item.VariantID, &item.Amount)
 		if err != nil {
 			return nil, err
 		}
@@ -115,7 +244,7 @@ func (r *UserRepository) GetUserCartByID(ctx context.Context, id int) (*[]domain
 		items = append(items, item)
 	}
 
-	return &items, nil
+	return &items, rows.Err()
 }
 
 func (r *UserRepository) DeleteUser(ctx context.Context, id int) error {
@@ -164,26 +293,205 @@ func (r *UserRepository) DeleteUser(ctx context.Context, id int) error {
 
 func (r *UserRepository) UserIsAdmin(ctx context.Context, login string) (bool, error) {
 	var isAdmin bool
-	err := r.db.QueryRow(ctx, "SELECT is_admin FROM users WHERE login = $1", login).Scan(&isAdmin)
+	sqlString := `SELECT EXISTS (
+        SELECT 1
+        FROM users
+        JOIN user_roles ON users.id = user_roles.user_id
+        JOIN roles ON user_roles.role_id = roles.id
+        WHERE users.login = $1 AND roles.name = $2
+    )`
+	err := r.db.QueryRow(ctx, sqlString, login, domain.RoleAdmin).Scan(&isAdmin)
 	return isAdmin, err
 }
 
 func (r *UserRepository) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
 	user := &domain.User{}
-	err := r.db.QueryRow(ctx, "SELECT id, login, password, is_admin FROM users WHERE login = $1", login).
-		Scan(&user.ID, &user.Login, &user.Password, &user.IsAdmin)
+	err := scanUser(r.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE login = $1", login), user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = r.loadRoles(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *UserRepository) HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// lists users whose login contains query.Search, newest first
+func (r *UserRepository) GetUsers(ctx context.Context, query *domain.UserQuery) (*domain.UserList, error) {
+	list := &domain.UserList{Users: []domain.User{}}
+
+	countSQL := "SELECT count(*) FROM users WHERE login ILIKE '%' || $1 || '%'"
+	err := r.db.QueryRow(ctx, countSQL, query.Search).Scan(&list.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlString := `SELECT ` + userColumns + `
+    FROM users
+    WHERE login ILIKE '%' || $1 || '%'
+    ORDER BY created_at DESC, id DESC
+    LIMIT $2 OFFSET $3`
+	rows, err := r.db.Query(ctx, sqlString, query.Search, query.Limit, query.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		user := domain.User{}
+		err := scanUser(rows, &user)
+		if err != nil {
+			return nil, err
+		}
+		list.Users = append(list.Users, user)
+	}
+
+	for i := range list.Users {
+		err := r.loadRoles(ctx, &list.Users[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return list, nil
 }
 
-func (r *UserRepository) CheckPasswordHash(password string, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err
+func (r *UserRepository) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	tag, err := r.db.Exec(ctx, "UPDATE users SET disabled = $2 WHERE id = $1", id, disabled)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetPasswordResetRequired(ctx context.Context, id int, required bool) error {
+	tag, err := r.db.Exec(ctx, "UPDATE users SET password_reset_required = $2 WHERE id = $1", id, required)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+// sets a new password, which also satisfies a forced password reset
+func (r *UserRepository) UpdatePassword(ctx context.Context, id int, password string) error {
+	hash, err := r.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `UPDATE users
+    SET password = $2, password_reset_required = false
+    WHERE id = $1`
+	tag, err := r.db.Exec(ctx, sqlString, id, hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*domain.User, error) {
+	user := &domain.User{}
+	// several users can claim an address, the one who verified it owns it
+	sqlString := "SELECT " + userColumns + " FROM users WHERE email = $1 ORDER BY email_verified DESC, id LIMIT 1"
+	err := scanUser(r.db.QueryRow(ctx, sqlString, email), user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = r.loadRoles(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// a changed email address has to be verified again,
+// links mailed to the old one stop working
+func (r *UserRepository) UpdateProfile(ctx context.Context, id int, update *domain.UserUpdate) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var oldEmail *string
+	err = tx.QueryRow(ctx, "SELECT email FROM users WHERE id = $1 FOR UPDATE", id).Scan(&oldEmail)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	sqlString := `UPDATE users
+    SET name = COALESCE($2, name),
+        phone = COALESCE($3, phone),
+        email = COALESCE($4, email),
+        email_verified = email_verified AND ($4::text IS NULL OR $4 IS NOT DISTINCT FROM email)
+    WHERE id = $1`
+	_, err = tx.Exec(ctx, sqlString, id, update.Name, update.Phone, update.Email)
+	if err != nil {
+		return err
+	}
+
+	if update.Email != nil && (oldEmail == nil || *oldEmail != *update.Email) {
+		sqlString = "DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL"
+		_, err = tx.Exec(ctx, sqlString, id, domain.TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// the user takes the address over from everyone who claimed it without verifying,
+// their pending verification links stop working
+func (r *UserRepository) SetEmailVerified(ctx context.Context, id int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	sqlString := `WITH released AS (
+        UPDATE users SET email = NULL
+        WHERE email = (SELECT email FROM users WHERE id = $1) AND id <> $1 AND NOT email_verified
+        RETURNING id
+    )
+    DELETE FROM user_tokens
+    WHERE user_id IN (SELECT id FROM released) AND purpose = $2 AND used_at IS NULL`
+	_, err = tx.Exec(ctx, sqlString, id, domain.TokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(ctx, "UPDATE users SET email_verified = true WHERE id = $1", id)
+	if isUniqueViolation(err, "users_verified_email_idx") {
+		return domain.ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrUserNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) HashPassword(password string) (string, error) {
+	return r.hasher.Hash(password)
 }
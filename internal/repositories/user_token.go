@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// single use tokens mailed to users, created by NewUserRepository
+func createUserTokenTable(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["user_tokens"]
+	if ok {
+		return nil
+	}
+
+	sqlString := `CREATE TABLE user_tokens
+    (
+        token_hash text primary key,
+        user_id int not null,
+        purpose text not null,
+        expires_at timestamptz not null,
+        used_at timestamptz,
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    )`
+	_, err := db.Exec(context.Background(), sqlString)
+	return err
+}
+
+// stores a new token, unused tokens of the user with the same purpose stop working
+func (r *UserRepository) CreateUserToken(ctx context.Context, token *domain.UserToken) error {
+	invalidateSQL := `UPDATE user_tokens
+    SET used_at = now()
+    WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+	_, err := r.db.Exec(ctx, invalidateSQL, token.UserID, token.Purpose)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+    VALUES ($1, $2, $3, $4)`
+	_, err = r.db.Exec(ctx, sqlString, token.Hash, token.UserID, token.Purpose, token.ExpiresAt)
+	return err
+}
+
+// marks the token as used and returns it,
+// expired, used and unknown tokens all return domain.ErrInvalidToken
+func (r *UserRepository) UseUserToken(ctx context.Context, hash string, purpose string) (*domain.UserToken, error) {
+	token := &domain.UserToken{}
+	sqlString := `UPDATE user_tokens
+    SET used_at = now()
+    WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+    RETURNING token_hash, user_id, purpose, expires_at`
+	err := r.db.QueryRow(ctx, sqlString, hash, purpose).Scan(&token.Hash, &token.UserID, &token.Purpose, &token.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
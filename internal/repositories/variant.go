@@ -0,0 +1,239 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"tefsi/internal/domain"
+)
+
+// variants of items, created by NewItemRepository.
+// cart and order lines reference (item, variant) so a variant can only be bought as its own item
+func createVariantTable(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["variants"]
+	if !ok {
+		sqlString := `CREATE TABLE variants
+        (
+            id serial primary key,
+            item_id int not null,
+            sku text not null UNIQUE,
+            options jsonb not null,
+            price int,
+            available boolean not null default true,
+            UNIQUE (item_id, options),
+            UNIQUE (item_id, id),
+            FOREIGN KEY (item_id) REFERENCES items(id) ON DELETE CASCADE
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// checks that the line can be bought, items with variants need an available one.
+// unknown items are left to the foreign keys
+func checkVariant(ctx context.Context, db queryRower, item *domain.ItemWithAmount) error {
+	var hasVariants bool
+	var available *bool
+	sqlString := `SELECT EXISTS (SELECT 1 FROM variants WHERE item_id = $1),
+        (SELECT available FROM variants WHERE item_id = $1 AND id = $2)`
+	err := db.QueryRow(ctx, sqlString, item.ItemID, item.VariantID).Scan(&hasVariants, &available)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case item.VariantID == 0 && hasVariants:
+		return domain.ErrVariantRequired
+	case item.VariantID != 0 && available == nil:
+		return domain.ErrVariantNotFound
+	case available != nil && !*available:
+		return domain.ErrVariantUnavailable
+	}
+	return nil
+}
+
+// maps the constraint violations of variant writes to domain errors
+func variantError(err error) error {
+	switch {
+	case isUniqueViolation(err, "variants_sku_key"):
+		return domain.ErrSKUTaken
+	case isUniqueViolation(err, "variants_item_id_options_key"):
+		return domain.ErrVariantExists
+	case isForeignKeyViolation(err, "variants_item_id_fkey"):
+		return domain.ErrItemNotFound
+	}
+	return err
+}
+
+// locks the item against changes of its options until the transaction ends and returns them.
+// variants are checked against the options under this lock, so they can't get out of sync
+func lockItemOptions(ctx context.Context, tx pgx.Tx, itemID int, forUpdate bool) ([]domain.ItemOption, error) {
+	sqlString := "SELECT options FROM items WHERE id = $1 FOR SHARE"
+	if forUpdate {
+		sqlString = "SELECT options FROM items WHERE id = $1 FOR UPDATE"
+	}
+	var options []domain.ItemOption
+	err := tx.QueryRow(ctx, sqlString, itemID).Scan(&options)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrItemNotFound
+	}
+	return options, err
+}
+
+// replaces the options of the item, the existing variants have to fit them
+func (r *ItemRepository) SetItemOptions(ctx context.Context, id int, options []domain.ItemOption) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = lockItemOptions(ctx, tx, id, true)
+	if err != nil {
+		return err
+	}
+	variants, err := getVariants(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	for i := range *variants {
+		err := (*variants)[i].Validate(options)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, "UPDATE items SET options = $2, version = version + 1 WHERE id = $1", id, options)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+const variantColumns = "id, item_id, sku, options, price, available"
+
+func scanVariant(row pgx.Row, variant *domain.Variant) error {
+	return row.Scan(&variant.ID, &variant.ItemID, &variant.SKU, &variant.Options, &variant.Price, &variant.Available)
+}
+
+func (r *ItemRepository) GetVariants(ctx context.Context, itemID int) (*[]domain.Variant, error) {
+	return getVariants(ctx, r.db, itemID)
+}
+
+func getVariants(ctx context.Context, db querier, itemID int) (*[]domain.Variant, error) {
+	sqlString := "SELECT " + variantColumns + " FROM variants WHERE item_id = $1 ORDER BY id"
+	rows, err := db.Query(ctx, sqlString, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []domain.Variant{}
+	for rows.Next() {
+		variant := domain.Variant{}
+		err := scanVariant(rows, &variant)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return &variants, rows.Err()
+}
+
+func (r *ItemRepository) GetVariant(ctx context.Context, itemID int, id int) (*domain.Variant, error) {
+	variant := &domain.Variant{}
+	sqlString := "SELECT " + variantColumns + " FROM variants WHERE item_id = $1 AND id = $2"
+	err := scanVariant(r.db.QueryRow(ctx, sqlString, itemID, id), variant)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// the variant has to fit the options of its item
+func (r *ItemRepository) CreateVariant(ctx context.Context, variant *domain.Variant) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	options, err := lockItemOptions(ctx, tx, variant.ItemID, false)
+	if err != nil {
+		return err
+	}
+	err = variant.Validate(options)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `INSERT INTO variants (item_id, sku, options, price, available)
+    VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = tx.QueryRow(
+		ctx, sqlString, variant.ItemID, variant.SKU, variant.Options, variant.Price, variant.Available,
+	).Scan(&variant.ID)
+	if err != nil {
+		return variantError(err)
+	}
+	return tx.Commit(ctx)
+}
+
+// replaces everything but the item of the variant, it has to fit the options of the item
+func (r *ItemRepository) UpdateVariant(ctx context.Context, variant *domain.Variant) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	options, err := lockItemOptions(ctx, tx, variant.ItemID, false)
+	if err != nil {
+		return err
+	}
+	err = variant.Validate(options)
+	if err != nil {
+		return err
+	}
+
+	sqlString := `UPDATE variants SET sku = $3, options = $4, price = $5, available = $6
+    WHERE item_id = $1 AND id = $2`
+	tag, err := tx.Exec(
+		ctx, sqlString, variant.ItemID, variant.ID, variant.SKU, variant.Options, variant.Price, variant.Available,
+	)
+	if err != nil {
+		return variantError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrVariantNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+// variants in carts are removed from them, ordered variants can't be deleted
+func (r *ItemRepository) DeleteVariant(ctx context.Context, itemID int, id int) error {
+	tag, err := r.db.Exec(ctx, "DELETE FROM variants WHERE item_id = $1 AND id = $2", itemID, id)
+	if isForeignKeyViolation(err, "items_orders_item_variant_fkey") ||
+		isForeignKeyViolation(err, "inventory_adjustments_item_variant_fkey") ||
+		isForeignKeyViolation(err, "stock_transfers_item_variant_fkey") {
+		return domain.ErrVariantInUse
+	}
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrVariantNotFound
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/domain"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// warehouses, created by NewInventoryRepository before the stock levels that reference them
+func createWarehouseTables(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["warehouses"]
+	if !ok {
+		sqlString := `CREATE TABLE warehouses
+        (
+            id serial primary key,
+            name text not null UNIQUE,
+            priority int not null default 0,
+            active boolean not null default true
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(context.Background(), "INSERT INTO warehouses (id, name) VALUES ($1, 'main')", domain.DefaultWarehouseID)
+		if err != nil {
+			return err
+		}
+		// the id was set explicitly, new warehouses continue after it
+		_, err = db.Exec(context.Background(), "SELECT setval('warehouses_id_seq', (SELECT max(id) FROM warehouses))")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTransferTable(db Pool, allTables *map[string]struct{}) error {
+	_, ok := (*allTables)["stock_transfers"]
+	if !ok {
+		sqlString := `CREATE TABLE stock_transfers
+        (
+            id serial primary key,
+            from_warehouse int not null,
+            to_warehouse int not null,
+            item int not null,
+            variant int,
+            quantity int not null,
+            note text not null default '',
+            created_at timestamptz not null default now(),
+            FOREIGN KEY (from_warehouse) REFERENCES warehouses(id),
+            FOREIGN KEY (to_warehouse) REFERENCES warehouses(id),
+            FOREIGN KEY (item) REFERENCES items(id) ON DELETE RESTRICT,
+            FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE RESTRICT
+        )`
+		_, err := db.Exec(context.Background(), sqlString)
+		if err != nil {
+			return err
+		}
+	}
+
+	// transfers are part of the stock history like the ledger entries they made
+	err := replaceCascadingForeignKey(db, "stock_transfers", "stock_transfers_item_fkey",
+		"FOREIGN KEY (item) REFERENCES items(id) ON DELETE RESTRICT")
+	if err != nil {
+		return err
+	}
+	return replaceCascadingForeignKey(db, "stock_transfers", "stock_transfers_item_variant_fkey",
+		"FOREIGN KEY (item, variant) REFERENCES variants(item_id, id) ON DELETE RESTRICT")
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func getWarehouses(ctx context.Context, db querier) (*[]domain.Warehouse, error) {
+	rows, err := db.Query(ctx, "SELECT id, name, priority, active FROM warehouses ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := []domain.Warehouse{}
+	for rows.Next() {
+		warehouse := domain.Warehouse{}
+		err := rows.Scan(&warehouse.ID, &warehouse.Name, &warehouse.Priority, &warehouse.Active)
+		if err != nil {
+			return nil, err
+		}
+		warehouses = append(warehouses, warehouse)
+	}
+	return &warehouses, rows.Err()
+}
+
+func (r *InventoryRepository) GetWarehouses(ctx context.Context) (*[]domain.Warehouse, error) {
+	return getWarehouses(ctx, r.db)
+}
+
+func (r *InventoryRepository) CreateWarehouse(ctx context.Context, warehouse *domain.Warehouse) error {
+	sqlString := "INSERT INTO warehouses (name, priority, active) VALUES ($1, $2, $3) RETURNING id"
+	err := r.db.QueryRow(ctx, sqlString, warehouse.Name, warehouse.Priority, warehouse.Active).Scan(&warehouse.ID)
+	if isUniqueViolation(err, "warehouses_name_key") {
+		return domain.ErrWarehouseNameTaken
+	}
+	return err
+}
+
+func (r *InventoryRepository) UpdateWarehouse(ctx context.Context, id int, update *domain.WarehouseUpdate) (*domain.Warehouse, error) {
+	sqlString := `UPDATE warehouses
+    SET name = COALESCE($2, name), priority = COALESCE($3, priority), active = COALESCE($4, active)
+    WHERE id = $1
+    RETURNING id, name, priority, active`
+	warehouse := &domain.Warehouse{}
+	err := r.db.QueryRow(ctx, sqlString, id, update.Name, update.Priority, update.Active).Scan(
+		&warehouse.ID, &warehouse.Name, &warehouse.Priority, &warehouse.Active,
+	)
+	if isUniqueViolation(err, "warehouses_name_key") {
+		return nil, domain.ErrWarehouseNameTaken
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrWarehouseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return warehouse, nil
+}
+
+// moves the stock and records the transfer with an adjustment for each warehouse
+func (r *InventoryRepository) TransferStock(ctx context.Context, transfer *domain.StockTransfer) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// both levels are locked in the same order as orders lock them, so opposite transfers don't deadlock
+	sqlString := `SELECT 1 FROM stock_levels
+    WHERE warehouse IN ($1, $2) AND item = $3 AND COALESCE(variant, 0) = $4
+    ORDER BY warehouse
+    FOR UPDATE`
+	rows, err := tx.Query(ctx, sqlString, transfer.FromWarehouseID, transfer.ToWarehouseID, transfer.ItemID, transfer.VariantID)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	sqlString = `INSERT INTO stock_transfers (from_warehouse, to_warehouse, item, variant, quantity, note)
+    VALUES ($1, $2, $3, NULLIF($4, 0), $5, $6) RETURNING id, created_at`
+	err = tx.QueryRow(
+		ctx, sqlString, transfer.FromWarehouseID, transfer.ToWarehouseID, transfer.ItemID, transfer.VariantID,
+		transfer.Quantity, transfer.Note,
+	).Scan(&transfer.ID, &transfer.CreatedAt)
+	switch {
+	case isForeignKeyViolation(err, "stock_transfers_from_warehouse_fkey"),
+		isForeignKeyViolation(err, "stock_transfers_to_warehouse_fkey"):
+		return domain.ErrWarehouseNotFound
+	case isForeignKeyViolation(err, "stock_transfers_item_fkey"):
+		return domain.ErrItemNotFound
+	case isForeignKeyViolation(err, "stock_transfers_item_variant_fkey"):
+		return domain.ErrVariantNotFound
+	case err != nil:
+		return err
+	}
+
+	// the source has to have the stock already, taking from it never starts tracking
+	var tracked bool
+	sqlString = "SELECT EXISTS (SELECT 1 FROM stock_levels WHERE warehouse = $1 AND item = $2 AND COALESCE(variant, 0) = $3)"
+	err = tx.QueryRow(ctx, sqlString, transfer.FromWarehouseID, transfer.ItemID, transfer.VariantID).Scan(&tracked)
+	if err != nil {
+		return err
+	}
+	if !tracked {
+		return domain.ErrInsufficientStock
+	}
+
+	adjustments := []domain.StockAdjustment{
+		{WarehouseID: transfer.FromWarehouseID, Delta: -transfer.Quantity},
+		{WarehouseID: transfer.ToWarehouseID, Delta: transfer.Quantity},
+	}
+	for _, adjustment := range adjustments {
+		adjustment.ItemID = transfer.ItemID
+		adjustment.VariantID = transfer.VariantID
+		adjustment.Reason = domain.AdjustmentReasonTransfer
+		adjustment.Note = transfer.Note
+		adjustment.TransferID = transfer.ID
+		err = changeStock(ctx, tx, &adjustment)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// the latest transfers, newest first
+func (r *InventoryRepository) GetTransfers(ctx context.Context, limit int) (*[]domain.StockTransfer, error) {
+	sqlString := `SELECT id, from_warehouse, to_warehouse, item, COALESCE(variant, 0), quantity, note, created_at
+    FROM stock_transfers
+    ORDER BY id DESC
+    LIMIT $1`
+	rows, err := r.db.Query(ctx, sqlString, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []domain.StockTransfer{}
+	for rows.Next() {
+		t := domain.StockTransfer{}
+		err := rows.Scan(
+			&t.ID, &t.FromWarehouseID, &t.ToWarehouseID, &t.ItemID, &t.VariantID, &t.Quantity, &t.Note, &t.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return &transfers, rows.Err()
+}
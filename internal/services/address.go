@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+
+	"tefsi/internal/domain"
+)
+
+type AddressRepository interface {
+	CreateAddress(ctx context.Context, address *domain.Address) error
+	GetAddresses(ctx context.Context, userID int) (*[]domain.Address, error)
+	GetAddress(ctx context.Context, userID int, id int) (*domain.Address, error)
+	GetDefaultAddress(ctx context.Context, userID int, billing bool) (*domain.Address, error)
+	UpdateAddress(ctx context.Context, address *domain.Address) error
+	DeleteAddress(ctx context.Context, userID int, id int) error
+}
+
+func (s *UserService) CreateAddress(ctx context.Context, address *domain.Address) error {
+	err := address.Normalize()
+	if err != nil {
+		return err
+	}
+	return s.repo.CreateAddress(ctx, address)
+}
+
+func (s *UserService) GetAddresses(ctx context.Context, userID int) (*[]domain.Address, error) {
+	return s.repo.GetAddresses(ctx, userID)
+}
+
+func (s *UserService) UpdateAddress(ctx context.Context, address *domain.Address) error {
+	err := address.Normalize()
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdateAddress(ctx, address)
+}
+
+func (s *UserService) DeleteAddress(ctx context.Context, userID int, id int) error {
+	return s.repo.DeleteAddress(ctx, userID, id)
+}
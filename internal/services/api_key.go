@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
+)
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *domain.APIKey) error
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error)
+	GetAPIKeys(ctx context.Context) (*[]domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	TouchAPIKey(ctx context.Context, id int) error
+}
+
+type APIKeyService struct {
+	repo APIKeyRepository
+}
+
+func NewDefaultAPIKeyService(repo APIKeyRepository) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// creates a key with the requested scopes, nobody can create a key
+// that is allowed to do more than they are
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, creator *domain.User, request *domain.APIKeyRequest) (*domain.CreatedAPIKey, error) {
+	if strings.TrimSpace(request.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	for _, scope := range request.Scopes {
+		if !domain.IsPermission(scope) {
+			return nil, fmt.Errorf("%w '%s'", domain.ErrUnknownPermission, scope)
+		}
+		if !creator.HasPermission(scope) {
+			return nil, fmt.Errorf("%w '%s'", domain.ErrScopeNotAllowed, scope)
+		}
+	}
+
+	key, prefix, err := tokens.NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	apiKey := domain.APIKey{
+		Name:       request.Name,
+		Prefix:     prefix,
+		SecretHash: tokens.Hash(key),
+		Scopes:     request.Scopes,
+		CreatedBy:  creator.ID,
+		ExpiresAt:  request.ExpiresAt,
+	}
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = []string{}
+	}
+
+	err = s.repo.CreateAPIKey(ctx, &apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.CreatedAPIKey{APIKey: apiKey, Key: key}, nil
+}
+
+func (s *APIKeyService) GetAPIKeys(ctx context.Context) (*[]domain.APIKey, error) {
+	return s.repo.GetAPIKeys(ctx)
+}
+
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int) error {
+	return s.repo.RevokeAPIKey(ctx, id)
+}
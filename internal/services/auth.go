@@ -2,22 +2,96 @@ package services
 
 import (
 	"context"
+	"time"
 
 	"tefsi/internal/domain"
 )
 
 type AuthRepository interface {
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	GetUserByID(ctx context.Context, id int) (*domain.User, error)
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error)
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserTokens(ctx context.Context, userID int) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	CreateSession(ctx context.Context, session *domain.Session) error
+	GetSession(ctx context.Context, id string) (*domain.Session, error)
+	GetUserSessions(ctx context.Context, userID int) (*[]domain.Session, error)
+	TouchSession(ctx context.Context, id string, expiresAt *time.Time) error
 }
 
 type AuthService struct {
-	repo AuthRepository
+	repo       AuthRepository
+	tokenRepo  TokenRepository
+	apiKeyRepo APIKeyRepository
 }
 
-func NewDefaultAuthService(repo AuthRepository) *AuthService {
-	return &AuthService{repo}
+func NewDefaultAuthService(repo AuthRepository, tokenRepo TokenRepository, apiKeyRepo APIKeyRepository) *AuthService {
+	return &AuthService{repo: repo, tokenRepo: tokenRepo, apiKeyRepo: apiKeyRepo}
 }
 
 func (s *AuthService) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
 	return s.repo.GetUserByLogin(ctx, login)
 }
+
+func (s *AuthService) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
+	return s.repo.GetUserByID(ctx, id)
+}
+
+func (s *AuthService) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	return s.tokenRepo.CreateRefreshToken(ctx, token)
+}
+
+func (s *AuthService) GetRefreshToken(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	return s.tokenRepo.GetRefreshToken(ctx, hash)
+}
+
+func (s *AuthService) MarkRefreshTokenUsed(ctx context.Context, hash string) (bool, error) {
+	return s.tokenRepo.MarkRefreshTokenUsed(ctx, hash)
+}
+
+func (s *AuthService) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	return s.tokenRepo.RevokeTokenFamily(ctx, familyID)
+}
+
+func (s *AuthService) RevokeUserTokens(ctx context.Context, userID int) error {
+	return s.tokenRepo.RevokeUserTokens(ctx, userID)
+}
+
+func (s *AuthService) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.tokenRepo.RevokeAccessToken(ctx, jti, expiresAt)
+}
+
+func (s *AuthService) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.tokenRepo.IsAccessTokenRevoked(ctx, jti)
+}
+
+func (s *AuthService) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	return s.apiKeyRepo.GetAPIKeyByPrefix(ctx, prefix)
+}
+
+func (s *AuthService) TouchAPIKey(ctx context.Context, id int) error {
+	return s.apiKeyRepo.TouchAPIKey(ctx, id)
+}
+
+func (s *AuthService) CreateSession(ctx context.Context, session *domain.Session) error {
+	return s.tokenRepo.CreateSession(ctx, session)
+}
+
+func (s *AuthService) GetSession(ctx context.Context, id string) (*domain.Session, error) {
+	return s.tokenRepo.GetSession(ctx, id)
+}
+
+func (s *AuthService) GetUserSessions(ctx context.Context, userID int) (*[]domain.Session, error) {
+	return s.tokenRepo.GetUserSessions(ctx, userID)
+}
+
+func (s *AuthService) TouchSession(ctx context.Context, id string, expiresAt *time.Time) error {
+	return s.tokenRepo.TouchSession(ctx, id, expiresAt)
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tefsi/internal/domain"
+)
+
+type CartRepository interface {
+	GetUserCartByID(ctx context.Context, id int) (*[]domain.ItemWithAmount, error)
+	SetUserCartItem(ctx context.Context, userID int, item *domain.ItemWithAmount) error
+	GetGuestCart(ctx context.Context, cartID string) (*[]domain.ItemWithAmount, error)
+	SetGuestCartItem(ctx context.Context, cartID string, item *domain.ItemWithAmount) error
+	MergeGuestCart(ctx context.Context, cartID string, userID int) error
+	DeleteStaleGuestCarts(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (s *UserService) SetUserCartItem(ctx context.Context, userID int, item *domain.ItemWithAmount) error {
+	if item.Amount < 0 {
+		return domain.ErrInvalidAmount
+	}
+	return s.repo.SetUserCartItem(ctx, userID, item)
+}
+
+func (s *UserService) GetGuestCart(ctx context.Context, cartID string) (*[]domain.ItemWithAmount, error) {
+	return s.repo.GetGuestCart(ctx, cartID)
+}
+
+// changes the guest cart with the id, or a new one if id is empty,
+// every change pushes back the expiry of the cart
+func (s *UserService) SetGuestCartItem(ctx context.Context, cartID string, item *domain.ItemWithAmount) (*domain.GuestCart, error) {
+	if item.Amount < 0 {
+		return nil, domain.ErrInvalidAmount
+	}
+
+	if cartID == "" {
+		cartID = uuid.NewString()
+	}
+
+	err := s.repo.SetGuestCartItem(ctx, cartID, item)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.GuestCart{ID: cartID, ExpiresAt: time.Now().Add(s.cfg.GuestCartTTL)}, nil
+}
+
+// deletes the guest carts nobody changed for GuestCartTTL, run periodically by inits.StartJobs
+func (s *UserService) DeleteStaleGuestCarts(ctx context.Context) error {
+	deleted, err := s.repo.DeleteStaleGuestCarts(ctx, time.Now().Add(-s.cfg.GuestCartTTL))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("deleted %d stale guest carts", deleted)
+	}
+	return nil
+}
+
+func (s *UserService) MergeGuestCart(ctx context.Context, cartID string, userID int) error {
+	return s.repo.MergeGuestCart(ctx, cartID, userID)
+}
@@ -2,6 +2,9 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
 	"tefsi/internal/domain"
 )
@@ -10,7 +13,10 @@ type CategoryRepository interface {
 	CreateCategory(ctx context.Context, category *domain.Category) error
 	GetCategoryByID(ctx context.Context, id int) (*domain.Category, error)
 	GetCategories(ctx context.Context) (*[]domain.Category, error)
-	DeleteCategory(ctx context.Context, id int) error
+	GetCategoryBySlug(ctx context.Context, slug string) (*domain.Category, error)
+	UpdateCategory(ctx context.Context, id int, update *domain.CategoryUpdate) error
+	DeleteCategory(ctx context.Context, id int, strategy *domain.CategoryDelete) error
+	MoveCategory(ctx context.Context, id int, parentID int) error
 }
 
 type CategoryService struct {
@@ -21,8 +27,48 @@ func NewDefaultCategoryService(repo CategoryRepository) *CategoryService {
 	return &CategoryService{repo: repo}
 }
 
+// categories with the same title get generated slugs with a number,
+// giving up after this many
+const maxSlugAttempts = 10
+
 func (s *CategoryService) CreateCategory(ctx context.Context, category *domain.Category) error {
-	return s.repo.CreateCategory(ctx, category)
+	if strings.TrimSpace(category.Title) == "" {
+		return domain.ErrInvalidCategory
+	}
+	if category.Slug != "" {
+		if err := (&domain.CategoryUpdate{Slug: &category.Slug}).Validate(); err != nil {
+			return err
+		}
+		return s.repo.CreateCategory(ctx, category)
+	}
+
+	slug := domain.Slugify(category.Title)
+	for i := 1; ; i++ {
+		category.Slug = slug
+		if i > 1 {
+			category.Slug = fmt.Sprintf("%s-%d", slug, i)
+		}
+		err := s.repo.CreateCategory(ctx, category)
+		if !errors.Is(err, domain.ErrSlugTaken) || i == maxSlugAttempts {
+			return err
+		}
+	}
+}
+
+func (s *CategoryService) GetCategoryBySlug(ctx context.Context, slug string) (*domain.Category, error) {
+	return s.repo.GetCategoryBySlug(ctx, slug)
+}
+
+func (s *CategoryService) UpdateCategory(ctx context.Context, id int, update *domain.CategoryUpdate) (*domain.Category, error) {
+	err := update.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.UpdateCategory(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetCategoryByID(ctx, id)
 }
 
 func (s *CategoryService) GetCategoryByID(ctx context.Context, id int) (*domain.Category, error) {
@@ -33,6 +79,28 @@ func (s *CategoryService) GetCategories(ctx context.Context) (*[]domain.Category
 	return s.repo.GetCategories(ctx)
 }
 
-func (s *CategoryService) DeleteCategory(ctx context.Context, id int) error {
-	return s.repo.DeleteCategory(ctx, id)
+func (s *CategoryService) GetCategoryTree(ctx context.Context) ([]domain.CategoryNode, error) {
+	categories, err := s.repo.GetCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return domain.BuildCategoryTree(*categories), nil
+}
+
+func (s *CategoryService) MoveCategory(ctx context.Context, id int, move *domain.CategoryMove) error {
+	if move.ParentID == id {
+		return domain.ErrCategoryCycle
+	}
+	return s.repo.MoveCategory(ctx, id, move.ParentID)
+}
+
+func (s *CategoryService) DeleteCategory(ctx context.Context, id int, strategy *domain.CategoryDelete) error {
+	err := strategy.Validate()
+	if err != nil {
+		return err
+	}
+	if strategy.Strategy == domain.CategoryDeleteReassign && strategy.TargetID == id {
+		return domain.ErrCategoryCycle
+	}
+	return s.repo.DeleteCategory(ctx, id, strategy)
 }
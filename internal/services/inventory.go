@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+
+	"tefsi/internal/domain"
+)
+
+type InventoryRepository interface {
+	GetStock(ctx context.Context, itemID int) (*[]domain.StockLevel, error)
+	AdjustStock(ctx context.Context, adjustment *domain.StockAdjustment) error
+	GetAdjustments(ctx context.Context, itemID int, limit int) (*[]domain.StockAdjustment, error)
+	GetWarehouseStock(ctx context.Context, warehouseID int) (*[]domain.StockLevel, error)
+	GetWarehouses(ctx context.Context) (*[]domain.Warehouse, error)
+	CreateWarehouse(ctx context.Context, warehouse *domain.Warehouse) error
+	UpdateWarehouse(ctx context.Context, id int, update *domain.WarehouseUpdate) (*domain.Warehouse, error)
+	TransferStock(ctx context.Context, transfer *domain.StockTransfer) error
+	GetTransfers(ctx context.Context, limit int) (*[]domain.StockTransfer, error)
+}
+
+type InventoryService struct {
+	repo InventoryRepository
+}
+
+func NewDefaultInventoryService(repo InventoryRepository) *InventoryService {
+	return &InventoryService{repo: repo}
+}
+
+func (s *InventoryService) GetStock(ctx context.Context, itemID int) (*[]domain.StockLevel, error) {
+	return s.repo.GetStock(ctx, itemID)
+}
+
+// manual changes of stock, orders reserve and release their stock themselves
+func (s *InventoryService) AdjustStock(ctx context.Context, adjustment *domain.StockAdjustment) error {
+	err := adjustment.Validate()
+	if err != nil {
+		return err
+	}
+	if adjustment.WarehouseID == 0 {
+		adjustment.WarehouseID = domain.DefaultWarehouseID
+	}
+	return s.repo.AdjustStock(ctx, adjustment)
+}
+
+func (s *InventoryService) GetAdjustments(ctx context.Context, itemID int, limit int) (*[]domain.StockAdjustment, error) {
+	return s.repo.GetAdjustments(ctx, itemID, limit)
+}
+
+func (s *InventoryService) GetWarehouseStock(ctx context.Context, warehouseID int) (*[]domain.StockLevel, error) {
+	return s.repo.GetWarehouseStock(ctx, warehouseID)
+}
+
+func (s *InventoryService) GetWarehouses(ctx context.Context) (*[]domain.Warehouse, error) {
+	return s.repo.GetWarehouses(ctx)
+}
+
+func (s *InventoryService) CreateWarehouse(ctx context.Context, warehouse *domain.Warehouse) error {
+	err := warehouse.Validate()
+	if err != nil {
+		return err
+	}
+	return s.repo.CreateWarehouse(ctx, warehouse)
+}
+
+func (s *InventoryService) UpdateWarehouse(ctx context.Context, id int, update *domain.WarehouseUpdate) (*domain.Warehouse, error) {
+	err := update.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateWarehouse(ctx, id, update)
+}
+
+func (s *InventoryService) TransferStock(ctx context.Context, transfer *domain.StockTransfer) error {
+	err := transfer.Validate()
+	if err != nil {
+		return err
+	}
+	return s.repo.TransferStock(ctx, transfer)
+}
+
+func (s *InventoryService) GetTransfers(ctx context.Context, limit int) (*[]domain.StockTransfer, error) {
+	return s.repo.GetTransfers(ctx, limit)
+}
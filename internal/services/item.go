@@ -2,6 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
 
 	"tefsi/internal/domain"
 )
@@ -9,16 +13,26 @@ import (
 type ItemRepository interface {
 	CreateItem(ctx context.Context, item *domain.Item) error
 	GetItemByID(ctx context.Context, id int) (*domain.Item, error)
-	GetItems(ctx context.Context, filter *domain.Filter) (*[]domain.Item, error)
+	GetItems(ctx context.Context, query *domain.ItemQuery) (*[]domain.Item, error)
+	GetItemsWithFacets(ctx context.Context, query *domain.ItemQuery) (*domain.ItemPage, error)
+	UpdateItem(ctx context.Context, id int, version int, update *domain.ItemUpdate) (*domain.Item, error)
 	DeleteItem(ctx context.Context, id int) error
+	SuggestItems(ctx context.Context, variants []string, limit int) (*[]domain.Suggestion, error)
+	SetItemOptions(ctx context.Context, id int, options []domain.ItemOption) error
+	GetVariants(ctx context.Context, itemID int) (*[]domain.Variant, error)
+	CreateVariant(ctx context.Context, variant *domain.Variant) error
+	UpdateVariant(ctx context.Context, variant *domain.Variant) error
+	DeleteVariant(ctx context.Context, itemID int, id int) error
 }
 
 type ItemService struct {
 	repo ItemRepository
+	// suggestions that take longer are dropped, the user has typed on already
+	suggestTimeout time.Duration
 }
 
-func NewDefaultItemService(repo ItemRepository) *ItemService {
-	return &ItemService{repo: repo}
+func NewDefaultItemService(repo ItemRepository, suggestTimeout time.Duration) *ItemService {
+	return &ItemService{repo: repo, suggestTimeout: suggestTimeout}
 }
 
 func (s *ItemService) GetItemByID(ctx context.Context, id int) (*domain.Item, error) {
@@ -29,10 +43,92 @@ func (s *ItemService) CreateItem(ctx context.Context, item *domain.Item) error {
 	return s.repo.CreateItem(ctx, item)
 }
 
-func (s *ItemService) GetItems(ctx context.Context, filter *domain.Filter) (*[]domain.Item, error) {
-	return s.repo.GetItems(ctx, filter)
+// version is the one the change was based on, the updated item is returned
+func (s *ItemService) UpdateItem(ctx context.Context, id int, version int, update *domain.ItemUpdate) (*domain.Item, error) {
+	err := update.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateItem(ctx, id, version, update)
+}
+
+func (s *ItemService) GetItems(ctx context.Context, query *domain.ItemQuery) (*[]domain.Item, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetItems(ctx, query)
+}
+
+// queries shorter than 2 characters match too much to be useful and get no suggestions,
+// neither do queries that don't finish in time
+func (s *ItemService) SuggestItems(ctx context.Context, query *domain.SuggestQuery) (*[]domain.Suggestion, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+	variants := query.Variants()
+	if utf8.RuneCountInString(variants[0]) < 2 {
+		return &[]domain.Suggestion{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.suggestTimeout)
+	defer cancel()
+	suggestions, err := s.repo.SuggestItems(ctx, variants, query.Limit)
+	if errors.Is(err, domain.ErrSuggestTimeout) {
+		log.Printf("suggestions for '%s' timed out", query.Query)
+		return &[]domain.Suggestion{}, nil
+	}
+	return suggestions, err
+}
+
+func (s *ItemService) GetItemsWithFacets(ctx context.Context, query *domain.ItemQuery) (*domain.ItemPage, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+	page, err := s.repo.GetItemsWithFacets(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = query.NextCursor(page.Items)
+	return page, nil
 }
 
 func (s *ItemService) DeleteItem(ctx context.Context, id int) error {
 	return s.repo.DeleteItem(ctx, id)
 }
+
+// the existing variants of the item have to fit the new options
+func (s *ItemService) SetItemOptions(ctx context.Context, id int, options []domain.ItemOption) (*domain.Item, error) {
+	err := domain.ValidateItemOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.SetItemOptions(ctx, id, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetItemByID(ctx, id)
+}
+
+func (s *ItemService) GetVariants(ctx context.Context, itemID int) (*[]domain.Variant, error) {
+	_, err := s.repo.GetItemByID(ctx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetVariants(ctx, itemID)
+}
+
+// the variant is checked against the options of its item by the repository
+func (s *ItemService) CreateVariant(ctx context.Context, variant *domain.Variant) error {
+	return s.repo.CreateVariant(ctx, variant)
+}
+
+func (s *ItemService) UpdateVariant(ctx context.Context, variant *domain.Variant) error {
+	return s.repo.UpdateVariant(ctx, variant)
+}
+
+func (s *ItemService) DeleteVariant(ctx context.Context, itemID int, id int) error {
+	return s.repo.DeleteVariant(ctx, itemID, id)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
+	"tefsi/internal/totp"
+)
+
+const recoveryCodeCount = 10
+
+// starts mfa enrollment, the secret only protects logins after ConfirmMFA
+func (s *UserService) EnrollMFA(ctx context.Context, user *domain.User) (*domain.MFAEnrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.SaveMFASecret(ctx, user.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.MFAEnrollment{Secret: secret, URI: totp.URI(s.cfg.MFA.Issuer, user.Login, secret)}, nil
+}
+
+// enables mfa once the user proves their authenticator works, returns new recovery codes
+func (s *UserService) ConfirmMFA(ctx context.Context, userID int, code string) (*domain.MFARecoveryCodes, error) {
+	mfa, err := s.repo.GetMFA(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Confirmed {
+		return nil, domain.ErrMFAAlreadyEnabled
+	}
+	err = s.checkTOTP(ctx, mfa, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = tokens.Hash(normalizeRecoveryCode(codes[i]))
+	}
+
+	err = s.repo.ConfirmMFA(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.MFARecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// checks the second factor of a user with mfa enabled,
+// every totp code and recovery code works only once
+func (s *UserService) VerifyMFA(ctx context.Context, userID int, code *domain.MFACode) error {
+	mfa, err := s.repo.GetMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !mfa.Confirmed {
+		return domain.ErrMFANotEnrolled
+	}
+
+	if code.RecoveryCode != "" {
+		ok, err := s.repo.UseRecoveryCode(ctx, userID, tokens.Hash(normalizeRecoveryCode(code.RecoveryCode)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return domain.ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	return s.checkTOTP(ctx, mfa, code.Code)
+}
+
+func (s *UserService) DisableMFA(ctx context.Context, userID int) error {
+	return s.repo.DeleteMFA(ctx, userID)
+}
+
+func (s *UserService) checkTOTP(ctx context.Context, mfa *domain.UserMFA, code string) error {
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		return domain.ErrInvalidMFACode
+	}
+	ok, err := s.repo.UseMFAStep(ctx, mfa.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return domain.ErrInvalidMFACode
+	}
+	return nil
+}
+
+// 10 random base32 characters split in two groups, like "k4f2a-7xq3m"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
+)
+
+// how long a user has to log in at the provider and come back
+const oidcStateTTL = 10 * time.Minute
+
+type OIDCRepository interface {
+	GetUserByIdentity(ctx context.Context, provider string, subject string) (*domain.User, error)
+	LinkIdentity(ctx context.Context, identity *domain.Identity) error
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	GetUserByID(ctx context.Context, id int) (*domain.User, error)
+	CreateUser(ctx context.Context, user *domain.User) error
+	SetEmailVerified(ctx context.Context, id int) error
+}
+
+type OIDCStateRepository interface {
+	SaveOIDCState(ctx context.Context, state *domain.OIDCState) error
+	UseOIDCState(ctx context.Context, hash string) (*domain.OIDCState, error)
+}
+
+type OIDCProvider interface {
+	Name() string
+	LinkByEmail() bool
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error)
+	Authenticate(ctx context.Context, code string, codeVerifier string, nonce string) (*domain.OIDCClaims, error)
+}
+
+type OIDCService struct {
+	repo      OIDCRepository
+	stateRepo OIDCStateRepository
+	providers map[string]OIDCProvider
+}
+
+func NewDefaultOIDCService(repo OIDCRepository, stateRepo OIDCStateRepository, providers []OIDCProvider) *OIDCService {
+	byName := make(map[string]OIDCProvider)
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+	return &OIDCService{repo: repo, stateRepo: stateRepo, providers: byName}
+}
+
+// starts a login at the provider and returns the url to send the user to
+func (s *OIDCService) StartLogin(ctx context.Context, providerName string) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", domain.ErrUnknownProvider
+	}
+
+	state, err := tokens.New()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := tokens.New()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := tokens.New()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.stateRepo.SaveOIDCState(ctx, &domain.OIDCState{
+		Hash:         tokens.Hash(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+}
+
+// finishes a login when the provider redirects back and returns the user it belongs to,
+// users logging in for the first time are linked or signed up
+func (s *OIDCService) FinishLogin(ctx context.Context, providerName string, state string, code string) (*domain.User, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+
+	savedState, err := s.stateRepo.UseOIDCState(ctx, tokens.Hash(state))
+	if err != nil {
+		return nil, err
+	}
+	if savedState.Provider != providerName {
+		return nil, domain.ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Authenticate(ctx, code, savedState.CodeVerifier, savedState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByIdentity(ctx, providerName, claims.Subject)
+	if !errors.Is(err, domain.ErrIdentityNotFound) {
+		return user, err
+	}
+	return s.linkIdentity(ctx, provider, claims)
+}
+
+func (s *OIDCService) linkIdentity(ctx context.Context, provider OIDCProvider, claims *domain.OIDCClaims) (*domain.User, error) {
+	email := ""
+	if claims.EmailVerified {
+		email, _ = domain.NormalizeEmail(claims.Email)
+	}
+
+	var user *domain.User
+	if email != "" {
+		existing, err := s.repo.GetUserByEmail(ctx, email)
+		switch {
+		case err == nil && provider.LinkByEmail() && !existing.EmailVerified:
+			// anyone can claim an address here, only its verified owner gets linked
+			return nil, domain.ErrLoginToLink
+		case err == nil && provider.LinkByEmail():
+			user = existing
+		case err == nil:
+			// someone else owns the address here, the new account goes without it
+			email = ""
+		case !errors.Is(err, domain.ErrUserNotFound):
+			return nil, err
+		}
+	}
+
+	if user == nil {
+		var err error
+		user, err = s.signUp(ctx, provider.Name(), claims.Subject, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := s.repo.LinkIdentity(ctx, &domain.Identity{
+		Provider: provider.Name(),
+		Subject:  claims.Subject,
+		UserID:   user.ID,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("linked %s identity %s to user with id %d", provider.Name(), claims.Subject, user.ID)
+
+	return s.repo.GetUserByID(ctx, user.ID)
+}
+
+// creates a user without a usable password, they can set one with a password reset
+func (s *OIDCService) signUp(ctx context.Context, providerName string, subject string, email string) (*domain.User, error) {
+	login := email
+	if login == "" {
+		login = fmt.Sprintf("%s:%s", providerName, subject)
+	} else if _, err := s.repo.GetUserByLogin(ctx, login); err == nil {
+		login = fmt.Sprintf("%s:%s", providerName, subject)
+	}
+
+	password, err := tokens.New()
+	if err != nil {
+		return nil, err
+	}
+	user := &domain.User{Login: login, Password: password, Email: email}
+	err = s.repo.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if email != "" {
+		err = s.repo.SetEmailVerified(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
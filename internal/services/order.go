@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 
 	"tefsi/internal/domain"
 )
@@ -13,29 +14,112 @@ type OrderRepository interface {
 	GetOrders(ctx context.Context) (*[]domain.Order, error)
 	DeleteOrder(ctx context.Context, id int) error
 	GetOrdersByUserID(ctx context.Context, id int) (*[]domain.Order, error)
+	CancelOrder(ctx context.Context, id int) error
 }
 
 type OrderService struct {
-	repo OrderRepository
+	repo        OrderRepository
+	addressRepo AddressRepository
 }
 
-func NewDefaultOrderService(repo OrderRepository) *OrderService {
-	return &OrderService{repo: repo}
+func NewDefaultOrderService(repo OrderRepository, addressRepo AddressRepository) *OrderService {
+	return &OrderService{repo: repo, addressRepo: addressRepo}
 }
 
 func (s *OrderService) GetOrderByID(ctx context.Context, id int) (*domain.Order, error) {
 	return s.repo.GetOrderByID(ctx, id)
 }
 
+// copies the referenced addresses of the user, or their default ones, onto the order.
+// guests have no address book, their orders carry the addresses and a contact email themselves
 func (s *OrderService) CreateOrder(ctx context.Context, order *domain.Order) error {
+	err := validateOrderItems(order)
+	if err != nil {
+		return err
+	}
+	if order.UserID == 0 {
+		return s.createGuestOrder(ctx, order)
+	}
+
+	order.ShippingAddress, err = s.orderAddress(ctx, order.UserID, order.ShippingAddressID, false)
+	if err != nil {
+		return err
+	}
+	order.BillingAddress, err = s.orderAddress(ctx, order.UserID, order.BillingAddressID, true)
+	if err != nil {
+		return err
+	}
+	return s.repo.CreateOrder(ctx, order)
+}
+
+func (s *OrderService) createGuestOrder(ctx context.Context, order *domain.Order) error {
+	email, err := domain.NormalizeEmail(order.ContactEmail)
+	if err != nil {
+		return err
+	}
+	order.ContactEmail = email
+
+	if order.ShippingAddress == nil {
+		return domain.ErrInvalidAddress
+	}
+	err = order.ShippingAddress.Normalize()
+	if err != nil {
+		return err
+	}
+	if order.BillingAddress != nil {
+		err = order.BillingAddress.Normalize()
+		if err != nil {
+			return err
+		}
+	}
 	return s.repo.CreateOrder(ctx, order)
 }
 
+// a referenced address has to exist, users without a default address place orders without one
+func (s *OrderService) orderAddress(ctx context.Context, userID int, id int, billing bool) (*domain.PostalAddress, error) {
+	var address *domain.Address
+	var err error
+	if id != 0 {
+		address, err = s.addressRepo.GetAddress(ctx, userID, id)
+	} else {
+		address, err = s.addressRepo.GetDefaultAddress(ctx, userID, billing)
+		if errors.Is(err, domain.ErrAddressNotFound) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address.PostalAddress, nil
+}
+
 func (s *OrderService) GetOrders(ctx context.Context) (*[]domain.Order, error) {
 	return s.repo.GetOrders(ctx)
 }
 
+// replaces the status and lines of the order, cancelling it goes through CancelOrder
 func (s *OrderService) UpdateOrder(ctx context.Context, order *domain.Order) error {
+	if order.StatusID == domain.StatusCancelled {
+		return s.repo.CancelOrder(ctx, order.ID)
+	}
+	err := validateOrderItems(order)
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdateOrder(ctx, order)
+}
+
+// cancels an order in progress, its reserved stock is released
+func (s *OrderService) CancelOrder(ctx context.Context, id int) error {
+	return s.repo.CancelOrder(ctx, id)
+}
+
+func validateOrderItems(order *domain.Order) error {
+	for _, item := range order.Items {
+		if item.Amount < 0 {
+			return domain.ErrInvalidAmount
+		}
+	}
 	return nil
 }
 
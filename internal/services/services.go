@@ -6,4 +6,8 @@ type AllServices struct {
 	ItemService     *ItemService
 	OrderService    *OrderService
 	CategoryService *CategoryService
+	OIDCService     *OIDCService
+	APIKeyService   *APIKeyService
+	// stock levels and manual adjustments, orders reserve stock through OrderService
+	InventoryService *InventoryService
 }
@@ -2,36 +2,189 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
+	"tefsi/internal/config"
 	"tefsi/internal/domain"
+	"tefsi/internal/tokens"
 )
 
 type UserRepository interface {
 	GetUserByID(ctx context.Context, id int) (*domain.User, error)
 	CreateUser(ctx context.Context, user *domain.User) error
-	GetUserCartByID(ctx context.Context, id int) (*[]domain.ItemWithAmount, error)
 	DeleteUser(ctx context.Context, id int) error
 	CheckUserByDomain(ctx context.Context, user *domain.User) error
 	UserExists(ctx context.Context, login string) error
 	UserIsAdmin(ctx context.Context, login string) (bool, error)
 	GetUserByLogin(ctx context.Context, login string) (*domain.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*domain.User, error)
+	SetEmailVerified(ctx context.Context, id int) error
+	UpdateProfile(ctx context.Context, id int, update *domain.UserUpdate) error
+	AssignRole(ctx context.Context, userID int, role string) error
+	RemoveRole(ctx context.Context, userID int, role string) error
+	GetRoles(ctx context.Context) (*[]domain.Role, error)
+	CreateRole(ctx context.Context, role *domain.Role) error
+	CountUsersWithRole(ctx context.Context, role string) (int, error)
+	GetUsers(ctx context.Context, query *domain.UserQuery) (*domain.UserList, error)
+	SetUserDisabled(ctx context.Context, id int, disabled bool) error
+	SetPasswordResetRequired(ctx context.Context, id int, required bool) error
+	UpdatePassword(ctx context.Context, id int, password string) error
+	CreateUserToken(ctx context.Context, token *domain.UserToken) error
+	UseUserToken(ctx context.Context, hash string, purpose string) (*domain.UserToken, error)
+	SaveMFASecret(ctx context.Context, userID int, secret string) error
+	GetMFA(ctx context.Context, userID int) (*domain.UserMFA, error)
+	ConfirmMFA(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	UseMFAStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+	DeleteMFA(ctx context.Context, userID int) error
+	AddressRepository
+	CartRepository
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *domain.MailMessage) error
 }
 
 // Реализация сервиса
 type UserService struct {
-	repo UserRepository
+	repo   UserRepository
+	mailer Mailer
+	cfg    *config.Config
 }
 
-func NewDefaultUserService(repo UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewDefaultUserService(repo UserRepository, mailer Mailer, cfg *config.Config) *UserService {
+	return &UserService{repo: repo, mailer: mailer, cfg: cfg}
 }
 
 func (s *UserService) GetUserByID(ctx context.Context, id int) (*domain.User, error) {
 	return s.repo.GetUserByID(ctx, id)
 }
 
+// signs a user up and mails them a link to confirm their email address
 func (s *UserService) CreateUser(ctx context.Context, user *domain.User) error {
-	return s.repo.CreateUser(ctx, user)
+	email, err := domain.NormalizeEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	user.Email = email
+
+	err = s.repo.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	// the account exists either way, the user can ask for another link
+	err = s.SendEmailVerification(ctx, user)
+	if err != nil {
+		log.Printf("can't send email verification to user with id %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+func (s *UserService) SendEmailVerification(ctx context.Context, user *domain.User) error {
+	if user.Email == "" {
+		return domain.ErrInvalidEmail
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := s.createUserToken(ctx, user.ID, domain.TokenPurposeVerifyEmail, s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.cfg.PublicURL + "/users/verify?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, &domain.MailMessage{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Thanks for signing up.\n\n"+
+				"Open this link to confirm your email address, it is valid for %s:\n%s\n\n"+
+				"If you didn't sign up, ignore this message.\n",
+			s.cfg.EmailVerificationTTL, link,
+		),
+	})
+}
+
+// confirms the email address using a token from SendEmailVerification, returns the id of the user
+func (s *UserService) VerifyEmail(ctx context.Context, token string) (int, error) {
+	userToken, err := s.repo.UseUserToken(ctx, tokens.Hash(token), domain.TokenPurposeVerifyEmail)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.repo.SetEmailVerified(ctx, userToken.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return userToken.UserID, nil
+}
+
+func (s *UserService) createUserToken(ctx context.Context, userID int, purpose string, ttl time.Duration) (string, error) {
+	token, err := tokens.New()
+	if err != nil {
+		return "", err
+	}
+	err = s.repo.CreateUserToken(ctx, &domain.UserToken{
+		Hash:      tokens.Hash(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// updates the profile of a user and returns it, a new email address
+// has to be verified again and gets a verification link
+func (s *UserService) UpdateUser(ctx context.Context, id int, update *domain.UserUpdate) (*domain.User, error) {
+	if update.Email != nil {
+		email, err := domain.NormalizeEmail(*update.Email)
+		if err != nil {
+			return nil, err
+		}
+		update.Email = &email
+	}
+	if update.Phone != nil {
+		phone, err := domain.NormalizePhone(*update.Phone)
+		if err != nil {
+			return nil, err
+		}
+		update.Phone = &phone
+	}
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		update.Name = &name
+	}
+
+	before, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = s.repo.UpdateProfile(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Email != before.Email {
+		err = s.SendEmailVerification(ctx, user)
+		if err != nil {
+			log.Printf("can't send email verification to user with id %d: %v", user.ID, err)
+		}
+	}
+	return user, nil
 }
 
 func (s *UserService) GetUserCartByID(ctx context.Context, id int) (*[]domain.ItemWithAmount, error) {
@@ -57,3 +210,191 @@ func (s *UserService) UserIsAdmin(ctx context.Context, login string) (bool, erro
 func (s *UserService) GetUserByLogin(ctx context.Context, login string) (*domain.User, error) {
 	return s.repo.GetUserByLogin(ctx, login)
 }
+
+func (s *UserService) AssignRole(ctx context.Context, userID int, role string) error {
+	return s.repo.AssignRole(ctx, userID, role)
+}
+
+func (s *UserService) RemoveRole(ctx context.Context, userID int, role string) error {
+	return s.repo.RemoveRole(ctx, userID, role)
+}
+
+func (s *UserService) GetRoles(ctx context.Context) (*[]domain.Role, error) {
+	return s.repo.GetRoles(ctx)
+}
+
+func (s *UserService) CreateRole(ctx context.Context, role *domain.Role) error {
+	for _, permission := range role.Permissions {
+		if !domain.IsPermission(permission) {
+			return fmt.Errorf("%w '%s'", domain.ErrUnknownPermission, permission)
+		}
+	}
+	return s.repo.CreateRole(ctx, role)
+}
+
+func (s *UserService) GetUsers(ctx context.Context, query *domain.UserQuery) (*domain.UserList, error) {
+	return s.repo.GetUsers(ctx, query)
+}
+
+func (s *UserService) PromoteUser(ctx context.Context, id int) error {
+	_, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.repo.AssignRole(ctx, id, domain.RoleAdmin)
+}
+
+// removes the admin role, refusing to leave the shop without any admins
+func (s *UserService) DemoteUser(ctx context.Context, id int) error {
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin {
+		return nil
+	}
+
+	admins, err := s.repo.CountUsersWithRole(ctx, domain.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins <= 1 {
+		return domain.ErrLastAdmin
+	}
+	return s.repo.RemoveRole(ctx, id, domain.RoleAdmin)
+}
+
+func (s *UserService) SetUserDisabled(ctx context.Context, id int, disabled bool) error {
+	return s.repo.SetUserDisabled(ctx, id, disabled)
+}
+
+func (s *UserService) SetPasswordResetRequired(ctx context.Context, id int, required bool) error {
+	return s.repo.SetPasswordResetRequired(ctx, id, required)
+}
+
+// changes the password of a user who knows their current one,
+// works for users that have to reset their password and therefore can't log in
+func (s *UserService) ChangePassword(ctx context.Context, change *domain.PasswordChange) (*domain.User, error) {
+	err := s.repo.CheckUserByDomain(ctx, &domain.User{Login: change.Login, Password: change.Password})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByLogin(ctx, change.Login)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, domain.ErrUserDisabled
+	}
+
+	err = s.repo.UpdatePassword(ctx, user.ID, change.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// creates the first admin so a fresh shop can be managed at all,
+// does nothing if there already is an admin. an existing account is never promoted,
+// otherwise anyone who registered the login first would become the admin
+func (s *UserService) EnsureAdmin(ctx context.Context, login string, password string) error {
+	admins, err := s.repo.CountUsersWithRole(ctx, domain.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	_, err = s.repo.GetUserByLogin(ctx, login)
+	if err == nil {
+		err = s.repo.CheckUserByDomain(ctx, &domain.User{Login: login, Password: password})
+		if errors.Is(err, domain.ErrInvalidCredentials) {
+			return fmt.Errorf("admin account '%s' already exists with a different password", login)
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("admin account '%s' already exists, it is not promoted", login)
+		return nil
+	}
+	if !errors.Is(err, domain.ErrUserNotFound) {
+		return err
+	}
+
+	user := &domain.User{Login: login, Password: password}
+	err = s.repo.CreateUser(ctx, user)
+	if err != nil {
+		return err
+	}
+	return s.repo.AssignRole(ctx, user.ID, domain.RoleAdmin)
+}
+
+// mails a password reset link to the user found by login or email, unknown and disabled users
+// are silently ignored so the endpoint can't be used to find out who has an account
+func (s *UserService) RequestPasswordReset(ctx context.Context, request *domain.ForgotPasswordRequest) error {
+	var user *domain.User
+	var err error
+	if request.Email != "" {
+		email, emailErr := domain.NormalizeEmail(request.Email)
+		if emailErr != nil {
+			return emailErr
+		}
+		user, err = s.repo.GetUserByEmail(ctx, email)
+	} else {
+		user, err = s.repo.GetUserByLogin(ctx, request.Login)
+	}
+	if errors.Is(err, domain.ErrUserNotFound) {
+		log.Printf("password reset requested for unknown user '%s%s'", request.Login, request.Email)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Disabled {
+		log.Printf("password reset requested for disabled user with id %d", user.ID)
+		return nil
+	}
+
+	if user.Email == "" {
+		log.Printf("can't mail password reset to user with id %d, they have no email address", user.ID)
+		return nil
+	}
+
+	token, err := s.createUserToken(ctx, user.ID, domain.TokenPurposePasswordReset, s.cfg.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.cfg.PublicURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, &domain.MailMessage{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your account.\n\n"+
+				"Open this link to choose a new password, it is valid for %s:\n%s\n\n"+
+				"If it wasn't you, ignore this message.\n",
+			s.cfg.PasswordResetTTL, link,
+		),
+	})
+	// a failure has to look like any other request, or it tells who has an account
+	if err != nil {
+		log.Printf("failed to mail password reset to user with id %d: %s", user.ID, err.Error())
+	}
+	return nil
+}
+
+// sets a new password using a token from RequestPasswordReset, returns the id of the user
+func (s *UserService) ResetPassword(ctx context.Context, token string, newPassword string) (int, error) {
+	userToken, err := s.repo.UseUserToken(ctx, tokens.Hash(token), domain.TokenPurposePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.repo.UpdatePassword(ctx, userToken.UserID, newPassword)
+	if err != nil {
+		return 0, err
+	}
+	return userToken.UserID, nil
+}
@@ -0,0 +1,102 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tefsi/internal/domain"
+)
+
+// MemoryStore keeps attempts in process, for single instance deployments and tests
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]domain.LoginAttempts
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: make(map[string]domain.LoginAttempts)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	attempts.Key = key
+	return &attempts, nil
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key string, now time.Time, window time.Duration) (*domain.LoginAttempts, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now, window)
+
+	attempts := s.attempts[key]
+	previous := attempts.LastFailure
+	if now.Sub(attempts.LastFailure) > window {
+		attempts.Failures = 0
+	}
+	attempts.Key = key
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+	return &attempts, previous, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string, reserved time.Time, previous time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	attempts.Failures = max(attempts.Failures-1, 0)
+	if attempts.LastFailure.Equal(reserved) && !previous.IsZero() {
+		attempts.LastFailure = previous
+	}
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key]
+	attempts.LockedUntil = until
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, before time.Time, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deleteExpired(before, now), nil
+}
+
+// drops forgotten entries once per window so the map doesn't grow forever
+func (s *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.deleteExpired(now.Add(-window), now)
+	s.lastSweep = now
+}
+
+func (s *MemoryStore) deleteExpired(before time.Time, now time.Time) int64 {
+	var deleted int64
+	for key, attempts := range s.attempts {
+		if attempts.LastFailure.Before(before) && now.After(attempts.LockedUntil) {
+			delete(s.attempts, key)
+			deleted++
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,167 @@
+// slows down and locks out repeated failed logins
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+)
+
+type Store interface {
+	// returns the attempts for the key, a zero value if there are none
+	Get(ctx context.Context, key string) (*domain.LoginAttempts, error)
+	// counts a failure at now in one step, starting over if the last one is older than window,
+	// returns the attempts including it and when the key failed before, zero if it didn't
+	Reserve(ctx context.Context, key string, now time.Time, window time.Duration) (*domain.LoginAttempts, time.Time, error)
+	// takes back a failure counted by Reserve at reserved,
+	// the last failure goes back to previous if no other failure was counted since
+	Release(ctx context.Context, key string, reserved time.Time, previous time.Time) error
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// deletes the keys that last failed before the given time and aren't locked at now
+	DeleteExpired(ctx context.Context, before time.Time, now time.Time) (int64, error)
+}
+
+type Limiter struct {
+	store Store
+	cfg   config.LoginThrottleConfig
+}
+
+func NewLimiter(store Store, cfg config.LoginThrottleConfig) *Limiter {
+	return &Limiter{store: store, cfg: cfg}
+}
+
+// picks the store implementation from config
+func NewStore(cfg config.LoginThrottleConfig, postgres Store) (Store, error) {
+	switch cfg.Store {
+	case "postgres":
+		return postgres, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown login throttle store '%s'", cfg.Store)
+}
+
+// counts an attempt to log in before the password is checked and returns how long
+// the caller has to wait, 0 if they can go ahead and check it
+//
+// the attempt counts as a failure right away, so parallel requests see each other
+// and can't all get past the limits before the first of them fails,
+// it has to be reported back with Fail or Release, throttled attempts are taken back here
+//
+// the backoff only applies to logins, ips are just locked out once they have too many failures
+// so customers behind a shared address don't slow each other down
+func (l *Limiter) Attempt(ctx context.Context, login string, ip string) (*domain.PendingLogin, time.Duration, error) {
+	attempt := &domain.PendingLogin{Login: login, Time: time.Now()}
+	attempts, previous, err := l.store.Reserve(ctx, loginKey(login), attempt.Time, l.cfg.Window)
+	if err != nil {
+		return nil, 0, err
+	}
+	attempt.PreviousLoginFailure = previous
+	wait := l.wait(attempts, previous, l.cfg.MaxFailures, attempt.Time)
+	if failures := attempts.Failures - 1; failures > 0 {
+		wait = max(wait, previous.Add(l.delay(failures)).Sub(attempt.Time))
+	}
+
+	if ip != "" {
+		attempts, previous, err = l.store.Reserve(ctx, ipKey(ip), attempt.Time, l.cfg.Window)
+		if err != nil {
+			l.Release(ctx, attempt)
+			return nil, 0, err
+		}
+		attempt.IP = ip
+		attempt.PreviousIPFailure = previous
+		wait = max(wait, l.wait(attempts, previous, l.cfg.IPMaxFailures, attempt.Time))
+	}
+
+	if wait > 0 {
+		err = l.Release(ctx, attempt)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return attempt, wait, nil
+}
+
+// keeps a failed attempt counted and locks the login or ip once they have too many failures
+func (l *Limiter) Fail(ctx context.Context, attempt *domain.PendingLogin) error {
+	now := time.Now()
+	for key, maxFailures := range l.limits(attempt) {
+		attempts, err := l.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if attempts.Failures >= maxFailures {
+			err = l.store.Lock(ctx, key, now.Add(l.cfg.LockoutDuration))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// takes back an attempt that didn't fail, for right passwords and throttled attempts
+func (l *Limiter) Release(ctx context.Context, attempt *domain.PendingLogin) error {
+	err := l.store.Release(ctx, loginKey(attempt.Login), attempt.Time, attempt.PreviousLoginFailure)
+	if err != nil || attempt.IP == "" {
+		return err
+	}
+	return l.store.Release(ctx, ipKey(attempt.IP), attempt.Time, attempt.PreviousIPFailure)
+}
+
+// forgets the failures of the login, the ip keeps its count
+// so one working account can't be used to keep guessing others
+func (l *Limiter) Succeed(ctx context.Context, login string) error {
+	return l.store.Reset(ctx, loginKey(login))
+}
+
+// deletes the failures older than the window, run periodically by inits.StartJobs
+func (l *Limiter) DeleteExpired(ctx context.Context) (int64, error) {
+	now := time.Now()
+	return l.store.DeleteExpired(ctx, now.Add(-l.cfg.Window), now)
+}
+
+// how long an attempt counted at now has to wait for a lock,
+// previous is when the key failed before the attempt
+func (l *Limiter) wait(attempts *domain.LoginAttempts, previous time.Time, maxFailures int, now time.Time) time.Duration {
+	wait := max(0, attempts.LockedUntil.Sub(now))
+	// the lock is only set once a failure is reported,
+	// attempts counted in the meantime already wait for it
+	if attempts.Failures-1 >= maxFailures && attempts.LockedUntil.Before(previous) {
+		wait = max(wait, previous.Add(l.cfg.LockoutDuration).Sub(now))
+	}
+	return wait
+}
+
+// backoff after the given number of failures
+func (l *Limiter) delay(failures int) time.Duration {
+	if failures < l.cfg.FreeAttempts {
+		return 0
+	}
+	delay := l.cfg.BaseDelay
+	for i := l.cfg.FreeAttempts; i < failures && delay < l.cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.cfg.MaxDelay)
+}
+
+// the keys the attempt was counted for and their failure limits
+func (l *Limiter) limits(attempt *domain.PendingLogin) map[string]int {
+	limits := map[string]int{loginKey(attempt.Login): l.cfg.MaxFailures}
+	if attempt.IP != "" {
+		limits[ipKey(attempt.IP)] = l.cfg.IPMaxFailures
+	}
+	return limits
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,54 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// returns a random url safe token, only its Hash should be stored
+func New() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const apiKeyPrefix = "tefsi_"
+
+// returns a new api key "tefsi_<prefix>_<secret>" and its prefix,
+// only the Hash of the whole key should be stored
+func NewAPIKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix := hex.EncodeToString(b)
+
+	secret, err := New()
+	if err != nil {
+		return "", "", err
+	}
+	return apiKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// returns the prefix of an api key, false if it isn't one
+func APIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"reflect"
+	"tefsi/internal/allocation"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	warehouses := []domain.Warehouse{
+		{ID: 1, Name: "main", Priority: 0, Active: true},
+		{ID: 2, Name: "north", Priority: 10, Active: true},
+		{ID: 3, Name: "closed", Priority: 20, Active: false},
+	}
+	stock := []domain.StockLevel{
+		{WarehouseID: 1, ItemID: 1, Quantity: 3},
+		{WarehouseID: 1, ItemID: 2, Quantity: 1},
+		{WarehouseID: 2, ItemID: 1, Quantity: 2},
+		{WarehouseID: 2, ItemID: 2, VariantID: 7, Quantity: 5},
+		{WarehouseID: 3, ItemID: 3, Quantity: 10},
+	}
+
+	cases := []struct {
+		name        string
+		lines       []domain.ItemWithAmount
+		allocations []domain.Allocation
+		shortages   []domain.StockShortage
+	}{
+		{
+			name:        "preferred warehouse has everything",
+			lines:       []domain.ItemWithAmount{{ItemID: 1, Amount: 2}},
+			allocations: []domain.Allocation{{WarehouseID: 2, ItemID: 1, Amount: 2}},
+		},
+		{
+			name:  "one parcel beats priority",
+			lines: []domain.ItemWithAmount{{ItemID: 1, Amount: 1}, {ItemID: 2, Amount: 1}},
+			allocations: []domain.Allocation{
+				{WarehouseID: 1, ItemID: 1, Amount: 1},
+				{WarehouseID: 1, ItemID: 2, Amount: 1},
+			},
+		},
+		{
+			name:  "split between warehouses",
+			lines: []domain.ItemWithAmount{{ItemID: 1, Amount: 4}, {ItemID: 2, Amount: 1}},
+			allocations: []domain.Allocation{
+				{WarehouseID: 2, ItemID: 1, Amount: 2},
+				{WarehouseID: 1, ItemID: 1, Amount: 2},
+				{WarehouseID: 1, ItemID: 2, Amount: 1},
+			},
+		},
+		{
+			name:        "same line twice",
+			lines:       []domain.ItemWithAmount{{ItemID: 1, Amount: 2}, {ItemID: 1, Amount: 3}},
+			allocations: []domain.Allocation{{WarehouseID: 2, ItemID: 1, Amount: 2}, {WarehouseID: 1, ItemID: 1, Amount: 3}},
+		},
+		{
+			name:        "variants are separate lines",
+			lines:       []domain.ItemWithAmount{{ItemID: 2, VariantID: 7, Amount: 5}},
+			allocations: []domain.Allocation{{WarehouseID: 2, ItemID: 2, VariantID: 7, Amount: 5}},
+		},
+		{
+			name:        "untracked items get no allocation",
+			lines:       []domain.ItemWithAmount{{ItemID: 4, Amount: 100}},
+			allocations: []domain.Allocation{},
+		},
+		{
+			name:  "shortages",
+			lines: []domain.ItemWithAmount{{ItemID: 1, Amount: 6}, {ItemID: 2, Amount: 1}, {ItemID: 3, Amount: 1}},
+			shortages: []domain.StockShortage{
+				{ItemID: 1, Requested: 6, Available: 5},
+				{ItemID: 3, Requested: 1, Available: 0},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		allocations, shortages := allocation.Allocate(c.lines, warehouses, stock)
+		if !reflect.DeepEqual(allocations, c.allocations) {
+			t.Errorf("%s: expected allocations %+v, got %+v", c.name, c.allocations, allocations)
+		}
+		if len(shortages) != 0 || len(c.shortages) != 0 {
+			if !reflect.DeepEqual(shortages, c.shortages) {
+				t.Errorf("%s: expected shortages %+v, got %+v", c.name, c.shortages, shortages)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"reflect"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestBuildCategoryTree(t *testing.T) {
+	categories := []domain.Category{
+		{ID: 1, Title: "Pets"},
+		{ID: 2, Title: "Cats", ParentID: 1},
+		{ID: 3, Title: "Cars"},
+		{ID: 4, Title: "Persian", ParentID: 2},
+		{ID: 5, Title: "Dogs", ParentID: 1},
+	}
+
+	expected := []domain.CategoryNode{
+		{ID: 1, Title: "Pets", Children: []domain.CategoryNode{
+			{ID: 2, Title: "Cats", Children: []domain.CategoryNode{
+				{ID: 4, Title: "Persian", Children: []domain.CategoryNode{}},
+			}},
+			{ID: 5, Title: "Dogs", Children: []domain.CategoryNode{}},
+		}},
+		{ID: 3, Title: "Cars", Children: []domain.CategoryNode{}},
+	}
+	if tree := domain.BuildCategoryTree(categories); !reflect.DeepEqual(tree, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, tree)
+	}
+
+	if tree := domain.BuildCategoryTree(nil); tree == nil || len(tree) != 0 {
+		t.Fatal("expected an empty tree, got", tree)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Кошки и собаки":        "koshki-i-sobaki",
+		"  T-Shirts & Hoodies!": "t-shirts-hoodies",
+		"Объектив 50mm":         "obektiv-50mm",
+		"???":                   "category",
+	}
+	for title, expected := range cases {
+		if slug := domain.Slugify(title); slug != expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", expected, title, slug)
+		}
+	}
+}
+
+func TestCategoryDeleteValidate(t *testing.T) {
+	invalid := []domain.CategoryDelete{
+		{Strategy: "drop"},
+		{Strategy: domain.CategoryDeleteReassign},
+	}
+	for _, strategy := range invalid {
+		if strategy.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", strategy)
+		}
+	}
+	if err := (&domain.CategoryDelete{Strategy: domain.CategoryDeleteArchive}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+	"time"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := mail.NewMemoryMailer()
+	service := services.NewDefaultUserService(repos.UserRepository, mailer, &config.Config{
+		PublicURL:            "http://shop.example.com",
+		EmailVerificationTTL: time.Hour,
+	})
+
+	user := domain.User{Login: "user1", Password: "password", Email: "user@example.com"}
+	err = service.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.VerifyEmail(context.Background(), tokenFromMail(t, mailer.Messages()[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, phone := "  Jane Doe ", "+7 (999) 123-45-67"
+	updated, err := service.UpdateUser(context.Background(), user.ID, &domain.UserUpdate{Name: &name, Phone: &phone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "Jane Doe" || updated.Phone != "+79991234567" {
+		t.Fatalf("expected normalized name and phone, got '%s' and '%s'", updated.Name, updated.Phone)
+	}
+	if !updated.EmailVerified {
+		t.Fatal("expected email to stay verified when it isn't changed")
+	}
+
+	invalid := "call me"
+	_, err = service.UpdateUser(context.Background(), user.ID, &domain.UserUpdate{Phone: &invalid})
+	if !errors.Is(err, domain.ErrInvalidPhone) {
+		t.Fatal("expected invalid phone to be rejected, got", err)
+	}
+
+	email := "New@Example.com"
+	updated, err = service.UpdateUser(context.Background(), user.ID, &domain.UserUpdate{Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Email != "new@example.com" || updated.EmailVerified {
+		t.Fatalf("expected unverified new@example.com, got %s verified = %t", updated.Email, updated.EmailVerified)
+	}
+	if updated.Name != "Jane Doe" {
+		t.Fatal("expected name to be left as it was, got", updated.Name)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 2 || messages[1].To != "new@example.com" {
+		t.Fatal("expected a verification mail to the new address, got", messages)
+	}
+
+	// the link to an address the user gave up must not verify the current one
+	email = "other@example.com"
+	_, err = service.UpdateUser(context.Background(), user.ID, &domain.UserUpdate{Email: &email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.VerifyEmail(context.Background(), tokenFromMail(t, messages[1]))
+	if !errors.Is(err, domain.ErrInvalidToken) {
+		t.Fatal("expected the link to the old address to be invalid, got", err)
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewDefaultUserService(repos.UserRepository, mail.NewMemoryMailer(), &config.Config{})
+	orderService := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+
+	user := domain.User{Login: "user1", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := domain.User{Login: "user2", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = service.CreateAddress(context.Background(), &domain.Address{UserID: user.ID})
+	if !errors.Is(err, domain.ErrInvalidAddress) {
+		t.Fatal("expected an empty address to be rejected, got", err)
+	}
+
+	home := domain.Address{UserID: user.ID, PostalAddress: domain.PostalAddress{
+		Name: "Jane Doe", Line1: "1 Main St", City: "Springfield", Country: "us",
+	}}
+	err = service.CreateAddress(context.Background(), &home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !home.DefaultShipping || !home.DefaultBilling || home.Country != "US" {
+		t.Fatal("expected the first address to be the default one, got", home)
+	}
+
+	office := domain.Address{UserID: user.ID, DefaultShipping: true, PostalAddress: domain.PostalAddress{
+		Name: "Jane Doe", Line1: "2 Office Rd", City: "Springfield", Country: "US",
+	}}
+	err = service.CreateAddress(context.Background(), &office)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses, err := service.GetAddresses(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*addresses) != 2 {
+		t.Fatal("expected 2 addresses, got", len(*addresses))
+	}
+	if (*addresses)[0].DefaultShipping || !(*addresses)[0].DefaultBilling || !(*addresses)[1].DefaultShipping {
+		t.Fatal("expected the default shipping address to move to the office, got", *addresses)
+	}
+
+	// the defaults are used when the order doesn't reference an address
+	order := domain.Order{StatusID: 1, UserID: user.ID}
+	err = orderService.CreateOrder(context.Background(), &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.ShippingAddress == nil || order.ShippingAddress.Line1 != "2 Office Rd" {
+		t.Fatal("expected the office as shipping address, got", order.ShippingAddress)
+	}
+	if order.BillingAddress == nil || order.BillingAddress.Line1 != "1 Main St" {
+		t.Fatal("expected home as billing address, got", order.BillingAddress)
+	}
+
+	// the order keeps its copy when the address changes
+	office.Line1 = "3 New Office Rd"
+	err = service.UpdateAddress(context.Background(), &office)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := orderService.GetOrderByID(context.Background(), order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ShippingAddress == nil || saved.ShippingAddress.Line1 != "2 Office Rd" {
+		t.Fatal("expected the order to keep the old address, got", saved.ShippingAddress)
+	}
+
+	err = orderService.CreateOrder(context.Background(), &domain.Order{StatusID: 1, UserID: other.ID, ShippingAddressID: home.ID})
+	if !errors.Is(err, domain.ErrAddressNotFound) {
+		t.Fatal("expected other users' addresses to be rejected, got", err)
+	}
+
+	err = service.DeleteAddress(context.Background(), user.ID, office.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err = service.GetAddresses(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*addresses) != 1 || !(*addresses)[0].DefaultShipping {
+		t.Fatal("expected the default shipping address to move back home, got", *addresses)
+	}
+}
@@ -0,0 +1,74 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/domain"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestAuth(t, repos)
+	service := services.NewDefaultAPIKeyService(repos.APIKeyRepository)
+
+	admin := &domain.User{ID: 0, Permissions: []string{domain.PermissionOrdersRead, domain.PermissionAPIKeysManage}}
+	_, err = service.CreateAPIKey(context.Background(), admin, &domain.APIKeyRequest{
+		Name:   "erp",
+		Scopes: []string{domain.PermissionUsersManage},
+	})
+	if !errors.Is(err, domain.ErrScopeNotAllowed) {
+		t.Fatal("expected scope the creator doesn't have to be rejected, got", err)
+	}
+
+	key, err := service.CreateAPIKey(context.Background(), admin, &domain.APIKeyRequest{
+		Name:   "erp",
+		Scopes: []string{domain.PermissionOrdersRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := a.GetUserFromAPIKey(context.Background(), key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.HasPermission(domain.PermissionOrdersRead) || user.HasPermission(domain.PermissionAPIKeysManage) {
+		t.Fatalf("expected the key to have only its scopes, got %v", user.Permissions)
+	}
+
+	_, err = a.GetUserFromAPIKey(context.Background(), key.Key+"x")
+	if !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Fatal("expected wrong secret to be rejected, got", err)
+	}
+
+	list, err := service.GetAPIKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*list) != 1 || (*list)[0].LastUsedAt == nil {
+		t.Fatalf("expected 1 used key, got %+v", *list)
+	}
+
+	err = service.RevokeAPIKey(context.Background(), key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.GetUserFromAPIKey(context.Background(), key.Key)
+	if !errors.Is(err, domain.ErrInvalidAPIKey) {
+		t.Fatal("expected revoked key to be rejected, got", err)
+	}
+}
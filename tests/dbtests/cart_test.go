@@ -0,0 +1,205 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// creates items in a new category and returns their ids
+func createTestItems(t *testing.T, db *pgxpool.Pool, titles ...string) []int {
+	var categoryID int
+	err := db.QueryRow(context.Background(), "INSERT INTO categories (title) VALUES ('cat') RETURNING id").Scan(&categoryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{}
+	for _, title := range titles {
+		var id int
+		sqlString := "INSERT INTO items (title, description, price, category) VALUES ($1, '', 100, $2) RETURNING id"
+		err = db.QueryRow(context.Background(), sqlString, title, categoryID).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestGuestCartMerge(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewDefaultUserService(repos.UserRepository, mail.NewMemoryMailer(), &config.Config{
+		GuestCartTTL: time.Hour,
+	})
+	a := newTestAuth(t, repos)
+	items := createTestItems(t, db, "item1", "item2")
+
+	user := domain.User{Login: "user1", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.SetUserCartItem(context.Background(), user.ID, &domain.ItemWithAmount{ItemID: items[0], Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cart, err := service.SetGuestCartItem(context.Background(), "", &domain.ItemWithAmount{ItemID: items[0], Amount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.SetGuestCartItem(context.Background(), cart.ID, &domain.ItemWithAmount{ItemID: items[1], Amount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.SetGuestCartItem(context.Background(), cart.ID, &domain.ItemWithAmount{ItemID: 1000, Amount: 1})
+	if !errors.Is(err, domain.ErrItemNotFound) {
+		t.Fatal("expected unknown item to be rejected, got", err)
+	}
+
+	token, err := a.IssueCartToken(cart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cartID, err := a.ParseCartToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cartID != cart.ID {
+		t.Fatalf("expected cart id %s, got %s", cart.ID, cartID)
+	}
+	_, err = a.ParseCartToken(token + "x")
+	if !errors.Is(err, domain.ErrInvalidCartToken) {
+		t.Fatal("expected tampered token to be rejected, got", err)
+	}
+
+	err = service.MergeGuestCart(context.Background(), cartID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userCart, err := repos.UserRepository.GetUserCartByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []domain.ItemWithAmount{{ItemID: items[0], Amount: 3}, {ItemID: items[1], Amount: 3}}
+	if len(*userCart) != len(expected) || (*userCart)[0] != expected[0] || (*userCart)[1] != expected[1] {
+		t.Fatalf("expected merged cart %v, got %v", expected, *userCart)
+	}
+
+	guestCart, err := service.GetGuestCart(context.Background(), cartID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*guestCart) != 0 {
+		t.Fatal("expected the guest cart to be gone after the merge, got", *guestCart)
+	}
+
+	// merging again doesn't add the items twice
+	err = service.MergeGuestCart(context.Background(), cartID, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCart, err = repos.UserRepository.GetUserCartByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*userCart)[0].Amount != 3 {
+		t.Fatal("expected the cart to be merged once, got", *userCart)
+	}
+
+	err = service.SetUserCartItem(context.Background(), user.ID, &domain.ItemWithAmount{ItemID: items[1], Amount: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCart, err = repos.UserRepository.GetUserCartByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*userCart) != 2 || (*userCart)[1].Amount != 5 {
+		t.Fatal("expected the amount of the existing line to be replaced, got", *userCart)
+	}
+
+	stale, err := service.SetGuestCartItem(context.Background(), "", &domain.ItemWithAmount{ItemID: items[0], Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := repos.UserRepository.DeleteStaleGuestCarts(context.Background(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatal("expected 1 stale cart to be deleted, got", deleted)
+	}
+	guestCart, err = service.GetGuestCart(context.Background(), stale.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*guestCart) != 0 {
+		t.Fatal("expected the stale cart to be gone, got", *guestCart)
+	}
+}
+
+func TestGuestOrder(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderService := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+	items := createTestItems(t, db, "item1")
+
+	address := &domain.PostalAddress{Name: "Guest", Line1: "1 Main St", City: "Springfield", Country: "us"}
+	order := domain.Order{StatusID: 1, Items: []domain.ItemWithAmount{{ItemID: items[0], Amount: 1}}, ShippingAddress: address}
+	err = orderService.CreateOrder(context.Background(), &order)
+	if !errors.Is(err, domain.ErrInvalidEmail) {
+		t.Fatal("expected a guest order without email to be rejected, got", err)
+	}
+
+	order.ContactEmail = "Guest@Example.com"
+	err = orderService.CreateOrder(context.Background(), &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := orderService.GetOrderByID(context.Background(), order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UserID != 0 || saved.ContactEmail != "guest@example.com" {
+		t.Fatalf("expected a guest order for guest@example.com, got user %d and %s", saved.UserID, saved.ContactEmail)
+	}
+	if saved.ShippingAddress == nil || saved.ShippingAddress.Country != "US" {
+		t.Fatal("expected the guest's shipping address, got", saved.ShippingAddress)
+	}
+	if len(saved.Items) != 1 {
+		t.Fatal("expected 1 item, got", len(saved.Items))
+	}
+}
@@ -2,8 +2,11 @@ package dbtests
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"tefsi/internal/domain"
+	"tefsi/internal/services"
 	"tefsi/tests"
 	"testing"
 )
@@ -124,9 +127,12 @@ func TestGetCategoryByID(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if *cat != cats[0] {
+	if cat.ID != cats[0].ID || cat.Title != cats[0].Title || cat.ParentID != 0 {
 		t.Fatalf("expected 2 equal categories, got: %+v and %+v", *cat, cats[0])
 	}
+	if len(cat.Path) != 1 || cat.Path[0].ID != id {
+		t.Fatalf("expected the path to be only the category, got %+v", cat.Path)
+	}
 }
 
 func TestDeleteCategory(t *testing.T) {
@@ -159,7 +165,7 @@ func TestDeleteCategory(t *testing.T) {
 	}
 
 	id := cats[0].ID
-	err = repos.CategoryRepository.DeleteCategory(context.Background(), id)
+	err = repos.CategoryRepository.DeleteCategory(context.Background(), id, &domain.CategoryDelete{Strategy: domain.CategoryDeleteRefuse})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -173,3 +179,243 @@ func TestDeleteCategory(t *testing.T) {
 		t.Fatal("the category wasnt deleted")
 	}
 }
+
+func TestCategoryTree(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pets := domain.Category{Title: "Pets"}
+	err = repos.CategoryRepository.CreateCategory(context.Background(), &pets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cats := domain.Category{Title: "Cats", ParentID: pets.ID}
+	err = repos.CategoryRepository.CreateCategory(context.Background(), &cats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	persian := domain.Category{Title: "Persian", ParentID: cats.ID}
+	err = repos.CategoryRepository.CreateCategory(context.Background(), &persian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repos.CategoryRepository.CreateCategory(context.Background(), &domain.Category{Title: "Cars", ParentID: persian.ID + 100})
+	if !errors.Is(err, domain.ErrCategoryNotFound) {
+		t.Fatal("expected a missing parent to be rejected, got", err)
+	}
+
+	category, err := repos.CategoryRepository.GetCategoryByID(context.Background(), persian.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []domain.CategoryRef{{ID: pets.ID, Title: "Pets"}, {ID: cats.ID, Title: "Cats"}, {ID: persian.ID, Title: "Persian"}}
+	if category.ParentID != cats.ID || !reflect.DeepEqual(category.Path, expected) {
+		t.Fatalf("expected the path %+v, got %+v", expected, *category)
+	}
+	_, err = repos.CategoryRepository.GetCategoryByID(context.Background(), persian.ID+100)
+	if !errors.Is(err, domain.ErrCategoryNotFound) {
+		t.Fatal("expected a missing category, got", err)
+	}
+
+	// items of the subcategories show up when browsing Pets
+	_, err = db.Exec(context.Background(), "INSERT INTO items (title, description, price, category) VALUES ('kitten', '', 1, $1)", persian.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := domain.ItemQuery{CategoryIDs: []int{pets.ID}}
+	items, err := repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 0 {
+		t.Fatal("expected no items directly in Pets, got", len(*items))
+	}
+	query.IncludeSubcategories = true
+	items, err = repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 1 {
+		t.Fatal("expected the kitten, got", len(*items))
+	}
+
+	err = repos.CategoryRepository.MoveCategory(context.Background(), pets.ID, persian.ID)
+	if !errors.Is(err, domain.ErrCategoryCycle) {
+		t.Fatal("expected moving a category under its descendant to fail, got", err)
+	}
+	err = repos.CategoryRepository.MoveCategory(context.Background(), persian.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category, err = repos.CategoryRepository.GetCategoryByID(context.Background(), persian.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.ParentID != 0 || len(category.Path) != 1 {
+		t.Fatalf("expected Persian to be a top level category, got %+v", *category)
+	}
+	err = repos.CategoryRepository.MoveCategory(context.Background(), pets.ID, persian.ID)
+	if err != nil {
+		t.Fatal("expected Pets to be movable under the former descendant, got", err)
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := services.NewDefaultCategoryService(repos.CategoryRepository)
+
+	first := domain.Category{Title: "Кошки"}
+	second := domain.Category{Title: "кошки!"}
+	for _, category := range []*domain.Category{&first, &second} {
+		err = service.CreateCategory(context.Background(), category)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.Slug != "koshki" || second.Slug != "koshki-2" {
+		t.Fatal("expected generated slugs koshki and koshki-2, got", first.Slug, second.Slug)
+	}
+
+	title, description, slug := "Cats", "all the cats", "cats"
+	updated, err := service.UpdateCategory(context.Background(), first.ID, &domain.CategoryUpdate{
+		Title: &title, Description: &description, Slug: &slug,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "Cats" || updated.Description != "all the cats" || updated.Slug != "cats" {
+		t.Fatalf("expected the category to be updated, got %+v", *updated)
+	}
+
+	category, err := service.GetCategoryBySlug(context.Background(), "cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.ID != first.ID {
+		t.Fatal("expected the renamed category, got", category.ID)
+	}
+
+	_, err = service.UpdateCategory(context.Background(), second.ID, &domain.CategoryUpdate{Slug: &slug})
+	if !errors.Is(err, domain.ErrSlugTaken) {
+		t.Fatal("expected the slug to be taken, got", err)
+	}
+	invalid := "Cats & Dogs"
+	_, err = service.UpdateCategory(context.Background(), second.ID, &domain.CategoryUpdate{Slug: &invalid})
+	if !errors.Is(err, domain.ErrInvalidSlug) {
+		t.Fatal("expected an invalid slug, got", err)
+	}
+}
+
+func TestDeleteCategoryStrategies(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := services.NewDefaultCategoryService(repos.CategoryRepository)
+
+	create := func(title string, parentID int) int {
+		category := domain.Category{Title: title, ParentID: parentID}
+		err := service.CreateCategory(context.Background(), &category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return category.ID
+	}
+	createItem := func(title string, categoryID int) int {
+		var id int
+		sqlString := "INSERT INTO items (title, description, price, category) VALUES ($1, '', 1, $2) RETURNING id"
+		err := db.QueryRow(context.Background(), sqlString, title, categoryID).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	pets := create("Pets", 0)
+	cats := create("Cats", pets)
+	persian := create("Persian", cats)
+	animals := create("Animals", 0)
+	kitten := createItem("kitten", cats)
+	fluffy := createItem("fluffy", persian)
+
+	refuse := &domain.CategoryDelete{Strategy: domain.CategoryDeleteRefuse}
+	err = service.DeleteCategory(context.Background(), cats, refuse)
+	if !errors.Is(err, domain.ErrCategoryNotEmpty) {
+		t.Fatal("expected a category with items to be kept, got", err)
+	}
+
+	err = service.DeleteCategory(context.Background(), pets, &domain.CategoryDelete{Strategy: domain.CategoryDeleteReassign, TargetID: persian})
+	if !errors.Is(err, domain.ErrCategoryCycle) {
+		t.Fatal("expected reassigning to a subcategory to fail, got", err)
+	}
+
+	// Cats goes away, its item and Persian move to Animals
+	err = service.DeleteCategory(context.Background(), cats, &domain.CategoryDelete{Strategy: domain.CategoryDeleteReassign, TargetID: animals})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := repos.ItemRepository.GetItemByID(context.Background(), kitten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.CategoryID != animals {
+		t.Fatal("expected the kitten to be in Animals, got", item.CategoryID)
+	}
+	category, err := service.GetCategoryByID(context.Background(), persian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if category.ParentID != animals {
+		t.Fatal("expected Persian to be under Animals, got", category.ParentID)
+	}
+
+	// Animals and Persian go away, their items are hidden but still there
+	err = service.DeleteCategory(context.Background(), animals, &domain.CategoryDelete{Strategy: domain.CategoryDeleteArchive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{kitten, fluffy} {
+		item, err := repos.ItemRepository.GetItemByID(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !item.Hidden || item.CategoryID != 0 {
+			t.Fatalf("expected %s to be archived, got %+v", item.Title, *item)
+		}
+	}
+	_, err = service.GetCategoryByID(context.Background(), persian)
+	if !errors.Is(err, domain.ErrCategoryNotFound) {
+		t.Fatal("expected Persian to be deleted with Animals, got", err)
+	}
+
+	err = service.DeleteCategory(context.Background(), pets, refuse)
+	if err != nil {
+		t.Fatal("expected the empty category to be deleted, got", err)
+	}
+}
@@ -0,0 +1,304 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"tefsi/internal/domain"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+)
+
+func guestOrder(items ...domain.ItemWithAmount) *domain.Order {
+	return &domain.Order{
+		StatusID:        domain.StatusInProgress,
+		ContactEmail:    "guest@example.com",
+		Items:           items,
+		ShippingAddress: &domain.PostalAddress{Name: "Guest", Line1: "1 Main St", City: "Springfield", Country: "us"},
+	}
+}
+
+func TestStockReservation(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := services.NewDefaultInventoryService(repos.InventoryRepository)
+	orders := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+	items := createTestItems(t, db, "tracked", "scarce", "untracked")
+	ctx := context.Background()
+
+	for _, adjustment := range []domain.StockAdjustment{
+		{ItemID: items[0], Delta: 5, Reason: domain.AdjustmentReasonRestock},
+		{ItemID: items[1], Delta: 1, Reason: domain.AdjustmentReasonRestock},
+	} {
+		err = inventory.AdjustStock(ctx, &adjustment)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = inventory.AdjustStock(ctx, &domain.StockAdjustment{ItemID: items[1], Delta: -2, Reason: domain.AdjustmentReasonDamaged})
+	if !errors.Is(err, domain.ErrInsufficientStock) {
+		t.Fatal("expected stock to stay positive, got", err)
+	}
+
+	order := guestOrder(
+		domain.ItemWithAmount{ItemID: items[0], Amount: 2},
+		domain.ItemWithAmount{ItemID: items[0], Amount: 4},
+		domain.ItemWithAmount{ItemID: items[1], Amount: 2},
+		domain.ItemWithAmount{ItemID: items[2], Amount: 100},
+	)
+	err = orders.CreateOrder(ctx, order)
+	var shortage *domain.StockShortageError
+	if !errors.As(err, &shortage) {
+		t.Fatal("expected a shortage, got", err)
+	}
+	expected := []domain.StockShortage{
+		{ItemID: items[0], Requested: 6, Available: 5},
+		{ItemID: items[1], Requested: 2, Available: 1},
+	}
+	if len(shortage.Lines) != 2 || shortage.Lines[0] != expected[0] || shortage.Lines[1] != expected[1] {
+		t.Fatalf("expected shortages %+v, got %+v", expected, shortage.Lines)
+	}
+	all, err := orders.GetOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*all) != 0 {
+		t.Fatal("expected the order not to be saved, got", *all)
+	}
+
+	order = guestOrder(
+		domain.ItemWithAmount{ItemID: items[0], Amount: 5},
+		domain.ItemWithAmount{ItemID: items[2], Amount: 100},
+	)
+	err = orders.CreateOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stock, err := inventory.GetStock(ctx, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*stock) != 1 || (*stock)[0].Quantity != 0 {
+		t.Fatal("expected the stock to be reserved, got", *stock)
+	}
+
+	err = orders.CancelOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = orders.CancelOrder(ctx, order.ID)
+	if !errors.Is(err, domain.ErrOrderNotCancellable) {
+		t.Fatal("expected a cancelled order not to be cancelled again, got", err)
+	}
+	stock, err = inventory.GetStock(ctx, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*stock)[0].Quantity != 5 {
+		t.Fatal("expected the stock to be released, got", (*stock)[0].Quantity)
+	}
+
+	ledger, err := inventory.GetAdjustments(ctx, items[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := []string{}
+	for _, adjustment := range *ledger {
+		reasons = append(reasons, adjustment.Reason)
+	}
+	if len(*ledger) != 3 || (*ledger)[0].Delta != 5 || (*ledger)[0].OrderID != order.ID ||
+		reasons[0] != domain.AdjustmentReasonOrderReleased || reasons[1] != domain.AdjustmentReasonOrder ||
+		reasons[2] != domain.AdjustmentReasonRestock {
+		t.Fatalf("expected restock, order and release in the ledger, got %+v", *ledger)
+	}
+
+	err = orders.CancelOrder(ctx, 1000)
+	if !errors.Is(err, domain.ErrOrderNotFound) {
+		t.Fatal("expected an unknown order, got", err)
+	}
+
+	err = repos.ItemRepository.DeleteItem(ctx, items[1])
+	if !errors.Is(err, domain.ErrItemInUse) {
+		t.Fatal("expected the ledger to keep the item from being deleted, got", err)
+	}
+}
+
+func TestConcurrentStockReservation(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := services.NewDefaultInventoryService(repos.InventoryRepository)
+	orders := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+	items := createTestItems(t, db, "first", "second")
+	ctx := context.Background()
+
+	for _, item := range items {
+		err = inventory.AdjustStock(ctx, &domain.StockAdjustment{ItemID: item, Delta: 3, Reason: domain.AdjustmentReasonRestock})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lines in both orders so concurrent orders lock the same rows in different order
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lines := []domain.ItemWithAmount{{ItemID: items[0], Amount: 1}, {ItemID: items[1], Amount: 1}}
+			if i%2 == 1 {
+				lines[0], lines[1] = lines[1], lines[0]
+			}
+			results <- orders.CreateOrder(ctx, guestOrder(lines...))
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	placed := 0
+	for err := range results {
+		if err == nil {
+			placed++
+		} else if !errors.Is(err, domain.ErrInsufficientStock) {
+			t.Fatal(err)
+		}
+	}
+	if placed != 3 {
+		t.Fatal("expected 3 orders to get the 3 units, got", placed)
+	}
+}
+
+func TestWarehouses(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory := services.NewDefaultInventoryService(repos.InventoryRepository)
+	orders := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+	items := createTestItems(t, db, "lamp")
+	ctx := context.Background()
+
+	north := domain.Warehouse{Name: "north", Priority: 10, Active: true}
+	err = inventory.CreateWarehouse(ctx, &north)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = inventory.CreateWarehouse(ctx, &domain.Warehouse{Name: " north "})
+	if !errors.Is(err, domain.ErrWarehouseNameTaken) {
+		t.Fatal("expected the name to be taken, got", err)
+	}
+
+	err = inventory.AdjustStock(ctx, &domain.StockAdjustment{ItemID: items[0], Delta: 5, Reason: domain.AdjustmentReasonRestock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := domain.StockTransfer{FromWarehouseID: domain.DefaultWarehouseID, ToWarehouseID: north.ID, ItemID: items[0], Quantity: 2}
+	err = inventory.TransferStock(ctx, &transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer.Quantity = 4
+	err = inventory.TransferStock(ctx, &transfer)
+	if !errors.Is(err, domain.ErrInsufficientStock) {
+		t.Fatal("expected a transfer of more than is in stock to be rejected, got", err)
+	}
+	err = inventory.TransferStock(ctx, &domain.StockTransfer{FromWarehouseID: north.ID, ToWarehouseID: 1000, ItemID: items[0], Quantity: 1})
+	if !errors.Is(err, domain.ErrWarehouseNotFound) {
+		t.Fatal("expected an unknown warehouse, got", err)
+	}
+
+	stock, err := inventory.GetStock(ctx, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []domain.StockLevel{
+		{WarehouseID: domain.DefaultWarehouseID, ItemID: items[0], Quantity: 3},
+		{WarehouseID: north.ID, ItemID: items[0], Quantity: 2},
+	}
+	if len(*stock) != 2 || (*stock)[0] != expected[0] || (*stock)[1] != expected[1] {
+		t.Fatalf("expected stock %+v, got %+v", expected, *stock)
+	}
+	transfers, err := inventory.GetTransfers(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*transfers) != 1 || (*transfers)[0].ID != transfer.ID {
+		t.Fatal("expected the transfer, got", *transfers)
+	}
+
+	// north has priority but only 2, so the order is split
+	order := guestOrder(domain.ItemWithAmount{ItemID: items[0], Amount: 4})
+	err = orders.CreateOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := orders.GetOrderByID(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocations := []domain.Allocation{
+		{WarehouseID: domain.DefaultWarehouseID, ItemID: items[0], Amount: 2},
+		{WarehouseID: north.ID, ItemID: items[0], Amount: 2},
+	}
+	if len(saved.Allocations) != 2 || saved.Allocations[0] != allocations[0] || saved.Allocations[1] != allocations[1] {
+		t.Fatalf("expected allocations %+v, got %+v", allocations, saved.Allocations)
+	}
+
+	inactive := false
+	_, err = inventory.UpdateWarehouse(ctx, domain.DefaultWarehouseID, &domain.WarehouseUpdate{Active: &inactive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = orders.CreateOrder(ctx, guestOrder(domain.ItemWithAmount{ItemID: items[0], Amount: 1}))
+	if !errors.Is(err, domain.ErrInsufficientStock) {
+		t.Fatal("expected the stock of inactive warehouses not to be used, got", err)
+	}
+
+	err = orders.CancelOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levels, err := inventory.GetWarehouseStock(ctx, north.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*levels) != 1 || (*levels)[0].Quantity != 2 {
+		t.Fatal("expected the stock to go back to north, got", *levels)
+	}
+	saved, err = orders.GetOrderByID(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Allocations) != 0 {
+		t.Fatal("expected a cancelled order to have no allocations, got", saved.Allocations)
+	}
+}
@@ -2,17 +2,24 @@ package dbtests
 
 import (
 	"context"
-	"log"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
 	"tefsi/internal/domain"
+	"tefsi/internal/inits"
+	"tefsi/internal/repositories"
+	"tefsi/internal/services"
 	"tefsi/tests"
 	"testing"
+	"time"
 )
 
-// tests item equality without IDs
+// tests item equality without IDs and search results
 func itemEq(item1 domain.Item, item2 domain.Item) bool {
-	item1.ID = 0
-	item2.ID = 0
-	return item1 == item2
+	item1.ID, item1.Rank, item1.Highlight, item1.Version = 0, 0, nil, 0
+	item2.ID, item2.Rank, item2.Highlight, item2.Version = 0, 0, nil, 0
+	return reflect.DeepEqual(item1, item2)
 }
 
 func TestCreateItem(t *testing.T) {
@@ -130,19 +137,19 @@ func TestGetItems(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	filterCat := domain.Filter{
-		CategoryID: catID,
+	filterCat := domain.ItemQuery{
+		CategoryIDs: []int{catID},
 	}
-	filterCar := domain.Filter{
-		CategoryID: carID,
+	filterCar := domain.ItemQuery{
+		CategoryIDs: []int{carID},
 	}
-	filter1 := domain.Filter{
-		SearchString: "1",
+	filter1 := domain.ItemQuery{
+		Search: "meow or car",
 	}
-	filterMashina := domain.Filter{
-		SearchString: "mashina",
+	filterMashina := domain.ItemQuery{
+		Search: "mashina1",
 	}
-	filterAll := domain.Filter{}
+	filterAll := domain.ItemQuery{}
 
 	catItems, err := repos.ItemRepository.GetItems(context.Background(), &filterCat)
 	if err != nil {
@@ -154,7 +161,6 @@ func TestGetItems(t *testing.T) {
 	}
 	oneItems, err := repos.ItemRepository.GetItems(context.Background(), &filter1)
 	if err != nil {
-		log.Println(filter1.GenerateString())
 		t.Fatal(err)
 	}
 	mashinaItems, err := repos.ItemRepository.GetItems(context.Background(), &filterMashina)
@@ -183,8 +189,10 @@ func TestGetItems(t *testing.T) {
 	if len(*oneItems) != 2 {
 		t.Fatal("expected 2 oneitems, got", len(*oneItems))
 	}
-	if !itemEq((*oneItems)[0], cat1) || !itemEq((*oneItems)[1], car1) {
-		t.Fatalf("expected %+v and %+v, got %+v and %+v", cat1, car1, (*oneItems)[0], (*oneItems)[1])
+	// both match in the description so they rank the same, the order doesn't matter
+	if !(itemEq((*oneItems)[0], cat2) && itemEq((*oneItems)[1], car1)) &&
+		!(itemEq((*oneItems)[0], car1) && itemEq((*oneItems)[1], cat2)) {
+		t.Fatalf("expected %+v and %+v, got %+v and %+v", cat2, car1, (*oneItems)[0], (*oneItems)[1])
 	}
 
 	if len(*mashinaItems) != 1 {
@@ -243,7 +251,7 @@ func TestGetItemByID(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	filterAll := domain.Filter{}
+	filterAll := domain.ItemQuery{}
 
 	allItems, err := repos.ItemRepository.GetItems(context.Background(), &filterAll)
 	if err != nil {
@@ -305,7 +313,7 @@ func TestDeleteItem(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	filterAll := domain.Filter{}
+	filterAll := domain.ItemQuery{}
 
 	allItems, err := repos.ItemRepository.GetItems(context.Background(), &filterAll)
 	if err != nil {
@@ -322,3 +330,396 @@ func TestDeleteItem(t *testing.T) {
 		t.Fatal("expected 0 items, got", len(*newItems))
 	}
 }
+
+func TestItemQuery(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := createTestItems(t, db, "free", "cheap", "mid", "expensive")
+	prices := []int{0, 10, 50, 500}
+	for i, id := range ids {
+		_, err = db.Exec(context.Background(), "UPDATE items SET price = $2 WHERE id = $1", id, prices[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a price of 0 is a filter, not a missing one
+	zero, fifty := 0, 50
+	items, err := repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{MaxPrice: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 1 || (*items)[0].Title != "free" {
+		t.Fatal("expected only the free item, got", *items)
+	}
+
+	items, err = repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{
+		MinPrice: &zero, MaxPrice: &fifty, Sort: domain.ItemSortPriceDesc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*items); titles != "mid,cheap,free" {
+		t.Fatal("expected mid,cheap,free, got", titles)
+	}
+
+	// pages of 2 by price, the second one continues after the cursor of the first
+	query := domain.ItemQuery{Sort: domain.ItemSortPrice, Limit: 2}
+	page, err := repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*page); titles != "free,cheap" {
+		t.Fatal("expected free,cheap, got", titles)
+	}
+	query.Cursor, err = domain.ParseItemCursor(query.NextCursor(*page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*page); titles != "mid,expensive" {
+		t.Fatal("expected mid,expensive, got", titles)
+	}
+
+	items, err = repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{Sort: domain.ItemSortNewest, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*items); titles != "mid" {
+		t.Fatal("expected mid, got", titles)
+	}
+}
+
+func itemTitles(items []domain.Item) string {
+	titles := []string{}
+	for _, item := range items {
+		titles = append(titles, item.Title)
+	}
+	return strings.Join(titles, ",")
+}
+
+func TestItemSearch(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := createTestItems(t, db, "Красная машина", "Игрушка", "Blue <Cars>")
+	descriptions := []string{"быстрая", "похожа на машину", "toy cars & trucks"}
+	for i, id := range ids {
+		_, err = db.Exec(context.Background(), "UPDATE items SET description = $2 WHERE id = $1", id, descriptions[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// matches in the title rank higher than in the description, "машины" is stemmed to match both
+	items, err := repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{Search: "машины"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*items); titles != "Красная машина,Игрушка" {
+		t.Fatal("expected the title match first, got", titles)
+	}
+	if (*items)[0].Highlight == nil || (*items)[0].Highlight.Title != "Красная <b>машина</b>" {
+		t.Fatalf("expected the title to be highlighted, got %+v", (*items)[0].Highlight)
+	}
+	if (*items)[1].Highlight.Description != "похожа на <b>машину</b>" {
+		t.Fatal("expected the description to be highlighted, got", (*items)[1].Highlight.Description)
+	}
+
+	// english words are stemmed too and the rest of the text is escaped
+	items, err = repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{Search: "car -truck"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 0 {
+		t.Fatal("expected the excluded word to filter out the item, got", itemTitles(*items))
+	}
+	items, err = repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{Search: "car"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 1 || (*items)[0].Highlight.Title != "Blue &lt;<b>Cars</b>&gt;" {
+		t.Fatalf("expected an escaped highlight, got %+v", *items)
+	}
+
+	// relevance pages continue after the rank of the cursor
+	query := domain.ItemQuery{Search: "машина", Limit: 1}
+	page, err := repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.Cursor, err = domain.ParseItemCursor(query.NextCursor(*page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = repos.ItemRepository.GetItems(context.Background(), &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*page); titles != "Игрушка" {
+		t.Fatal("expected the description match on the second page, got", titles)
+	}
+}
+
+func TestSuggestItems(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := services.NewDefaultItemService(repos.ItemRepository, time.Second)
+
+	ids := createTestItems(t, db, "Машина красная", "Mashina toy", "Машинка секретная")
+	_, err = db.Exec(context.Background(), "UPDATE items SET hidden = true WHERE id = $1", ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repos.CategoryRepository.CreateCategory(context.Background(), &domain.Category{Title: "Машины"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suggest := func(q string) []domain.Suggestion {
+		suggestions, err := service.SuggestItems(context.Background(), &domain.SuggestQuery{Query: q, Limit: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *suggestions
+	}
+
+	// typed in the english layout, the hidden item isn't suggested
+	suggestions := suggest("vfibyf")
+	if len(suggestions) != 2 {
+		t.Fatal("expected the visible item and the category, got", suggestions)
+	}
+	for _, suggestion := range suggestions {
+		if suggestion.Title == "Машинка секретная" {
+			t.Fatal("expected the hidden item to not be suggested")
+		}
+	}
+	if suggestions[0].Type != domain.SuggestionItem || suggestions[0].ID != ids[0] {
+		t.Fatal("expected the prefix match first, got", suggestions)
+	}
+
+	// a typo
+	suggestions = suggest("mashna")
+	if len(suggestions) != 1 || suggestions[0].Title != "Mashina toy" {
+		t.Fatal("expected the misspelled item, got", suggestions)
+	}
+
+	if suggestions := suggest("x"); len(suggestions) != 0 {
+		t.Fatal("expected no suggestions for one character, got", suggestions)
+	}
+
+	// hidden items aren't listed either
+	items, err := repos.ItemRepository.GetItems(context.Background(), &domain.ItemQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*items); titles != "Машина красная,Mashina toy" {
+		t.Fatal("expected the visible items, got", titles)
+	}
+}
+
+func TestItemFacets(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := createTestItems(t, db, "red shirt", "blue shirt", "red car", "hidden shirt")
+	var carCategory int
+	err = db.QueryRow(context.Background(), "INSERT INTO categories (title) VALUES ('cars') RETURNING id").Scan(&carCategory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := []string{
+		`UPDATE items SET price = 300, attributes = '{"color": "red", "size": "m"}' WHERE id = $1`,
+		`UPDATE items SET price = 700, attributes = '{"color": "blue", "size": "m"}' WHERE id = $1`,
+		`UPDATE items SET price = 60000, attributes = '{"color": "red"}', category = ` + strconv.Itoa(carCategory) + ` WHERE id = $1`,
+		`UPDATE items SET hidden = true, attributes = '{"color": "red"}' WHERE id = $1`,
+	}
+	for i, update := range updates {
+		_, err = db.Exec(context.Background(), update, ids[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := repos.ItemRepository.GetItemsWithFacets(context.Background(), &domain.ItemQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Facets.Total != 3 {
+		t.Fatalf("expected one item of 3, got %d of %d", len(page.Items), page.Facets.Total)
+	}
+	if len(page.Facets.Categories) != 2 || page.Facets.Categories[0].Count != 2 || page.Facets.Categories[1].ID != carCategory {
+		t.Fatalf("expected 2 shirts and a car, got %+v", page.Facets.Categories)
+	}
+	counts := []int{}
+	for _, price := range page.Facets.Prices {
+		counts = append(counts, price.Count)
+	}
+	if !reflect.DeepEqual(counts, []int{1, 1, 0, 0, 0, 1}) {
+		t.Fatal("expected one item in the first, second and last price bucket, got", counts)
+	}
+	expected := map[string][]domain.AttributeFacet{
+		"color": {{Value: "red", Count: 2}, {Value: "blue", Count: 1}},
+		"size":  {{Value: "m", Count: 2}},
+	}
+	if !reflect.DeepEqual(page.Facets.Attributes, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, page.Facets.Attributes)
+	}
+
+	// the facets follow the filters
+	page, err = repos.ItemRepository.GetItemsWithFacets(context.Background(), &domain.ItemQuery{
+		Attributes: map[string][]string{"color": {"red"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(page.Items); titles != "red shirt,red car" || page.Facets.Total != 2 {
+		t.Fatal("expected the red items, got", titles)
+	}
+	if page.Facets.Attributes["color"][0].Count != 2 || len(page.Facets.Attributes["size"]) != 1 {
+		t.Fatalf("expected the facets of the red items, got %+v", page.Facets.Attributes)
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := services.NewDefaultItemService(repos.ItemRepository, time.Second)
+
+	id := createTestItems(t, db, "shrit")[0]
+	item, err := service.GetItemByID(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two admins edit the same version, the second one has to reload first
+	title, price := "shirt", 250
+	updated, err := service.UpdateItem(context.Background(), id, item.Version, &domain.ItemUpdate{Title: &title})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Title != "shirt" || updated.Price != 100 || updated.Version != item.Version+1 {
+		t.Fatalf("expected only the title to change, got %+v", *updated)
+	}
+	_, err = service.UpdateItem(context.Background(), id, item.Version, &domain.ItemUpdate{Price: &price})
+	if !errors.Is(err, domain.ErrVersionConflict) {
+		t.Fatal("expected a version conflict, got", err)
+	}
+
+	// a replacement sets everything
+	replacement := domain.Item{Title: "t-shirt", Price: 300, CategoryID: updated.CategoryID, Attributes: map[string]string{"size": "l"}}
+	updated, err = service.UpdateItem(context.Background(), id, updated.Version, domain.ReplaceItem(&replacement))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !itemEq(*updated, domain.Item{
+		Title: "t-shirt", Price: 300, CategoryID: updated.CategoryID, CategoryTitle: "cat", Attributes: map[string]string{"size": "l"},
+	}) {
+		t.Fatalf("expected the item to be replaced, got %+v", *updated)
+	}
+
+	missing := updated.CategoryID + 100
+	_, err = service.UpdateItem(context.Background(), id, updated.Version, &domain.ItemUpdate{CategoryID: &missing})
+	if !errors.Is(err, domain.ErrCategoryNotFound) {
+		t.Fatal("expected an unknown category to be rejected, got", err)
+	}
+	_, err = service.UpdateItem(context.Background(), id+1, 1, &domain.ItemUpdate{Title: &title})
+	if !errors.Is(err, domain.ErrItemNotFound) {
+		t.Fatal("expected a missing item, got", err)
+	}
+}
+
+func TestItemSearchLanguageChange(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	_, err = tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createTestItems(t, db, "Running shoes")
+
+	// starting with another language rebuilds the vectors with it
+	tables, err := inits.GetAllTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemRepo, err := repositories.NewItemRepository(db, &tables, "simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// simple doesn't stem, the vectors stemmed by russian would still have matched
+	items, err := itemRepo.GetItems(context.Background(), &domain.ItemQuery{Search: "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*items) != 0 {
+		t.Fatal("expected no match for the stem, got", itemTitles(*items))
+	}
+	items, err = itemRepo.GetItems(context.Background(), &domain.ItemQuery{Search: "running"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if titles := itemTitles(*items); titles != "Running shoes" {
+		t.Fatal("expected the whole word to match, got", titles)
+	}
+
+	// starting again with the same language keeps the column
+	_, err = repositories.NewItemRepository(db, &tables, "simple")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,67 @@
+package dbtests
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"tefsi/internal/config"
+	"tefsi/internal/throttle"
+	"tefsi/tests"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptsConcurrent(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.LoginThrottleConfig{
+		Store:           "postgres",
+		FreeAttempts:    2,
+		BaseDelay:       time.Hour,
+		MaxDelay:        time.Hour,
+		MaxFailures:     5,
+		IPMaxFailures:   10,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+	limiter := throttle.NewLimiter(repos.LoginAttemptRepository, cfg)
+
+	var checked atomic.Int64
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, wait, err := limiter.Attempt(context.Background(), "user", "10.0.0.1")
+			if err != nil || wait > 0 {
+				return
+			}
+			checked.Add(1)
+			time.Sleep(10 * time.Millisecond)
+			limiter.Fail(context.Background(), attempt)
+		}()
+	}
+	wg.Wait()
+	if checked.Load() != 2 {
+		t.Fatal("expected 2 password checks, got", checked.Load())
+	}
+
+	// throttled attempts were taken back
+	attempts, err := repos.LoginAttemptRepository.Get(context.Background(), "login:user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != 2 {
+		t.Fatal("expected 2 failures, got", attempts.Failures)
+	}
+}
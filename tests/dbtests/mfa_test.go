@@ -0,0 +1,95 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"tefsi/internal/services"
+	"tefsi/internal/totp"
+	"tefsi/tests"
+	"testing"
+	"time"
+)
+
+func TestMFA(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewDefaultUserService(repos.UserRepository, mail.NewMemoryMailer(), &config.Config{
+		MFA: config.MFAConfig{Issuer: "tefsi"},
+	})
+
+	user := domain.User{Login: "admin", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enrollment, err := service.EnrollMFA(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := service.ConfirmMFA(context.Background(), user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes.RecoveryCodes) == 0 {
+		t.Fatal("expected recovery codes")
+	}
+
+	loaded, err := repos.UserRepository.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.MFAEnabled {
+		t.Fatal("expected mfa to be enabled")
+	}
+
+	// the code used for confirming can't be replayed
+	err = service.VerifyMFA(context.Background(), user.ID, &domain.MFACode{Code: code})
+	if !errors.Is(err, domain.ErrInvalidMFACode) {
+		t.Fatal("expected used code to be rejected, got", err)
+	}
+
+	recoveryCode := &domain.MFACode{RecoveryCode: codes.RecoveryCodes[0]}
+	err = service.VerifyMFA(context.Background(), user.ID, recoveryCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.VerifyMFA(context.Background(), user.ID, recoveryCode)
+	if !errors.Is(err, domain.ErrInvalidMFACode) {
+		t.Fatal("expected used recovery code to be rejected, got", err)
+	}
+
+	_, err = service.EnrollMFA(context.Background(), &user)
+	if !errors.Is(err, domain.ErrMFAAlreadyEnabled) {
+		t.Fatal("expected enrolling twice to fail, got", err)
+	}
+
+	err = service.DisableMFA(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = repos.UserRepository.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.MFAEnabled {
+		t.Fatal("expected mfa to be disabled")
+	}
+}
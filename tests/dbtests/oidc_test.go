@@ -0,0 +1,108 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"tefsi/internal/domain"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+)
+
+// provider that skips the redirects and hands out fixed claims
+type fakeProvider struct {
+	linkByEmail bool
+	claims      domain.OIDCClaims
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) LinkByEmail() bool { return p.linkByEmail }
+
+func (p *fakeProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	return "http://idp.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (p *fakeProvider) Authenticate(ctx context.Context, code string, codeVerifier string, nonce string) (*domain.OIDCClaims, error) {
+	claims := p.claims
+	return &claims, nil
+}
+
+func TestOIDCLinkIdentity(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := domain.User{Login: "user1", Password: "password", Email: "user@example.com"}
+	err = repos.UserRepository.CreateUser(context.Background(), &existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &fakeProvider{
+		linkByEmail: true,
+		claims:      domain.OIDCClaims{Subject: "sub-1", Email: "User@example.com", EmailVerified: true},
+	}
+	service := services.NewDefaultOIDCService(repos.UserRepository, repos.TokenRepository, []services.OIDCProvider{provider})
+
+	login := func() (*domain.User, error) {
+		authURL, err := service.StartLogin(context.Background(), "fake")
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return nil, err
+		}
+		return service.FinishLogin(context.Background(), "fake", u.Query().Get("state"), "code")
+	}
+
+	_, err = login()
+	if !errors.Is(err, domain.ErrLoginToLink) {
+		t.Fatal("expected an unverified address to not be linked, got", err)
+	}
+
+	err = repos.UserRepository.SetEmailVerified(context.Background(), existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != existing.ID {
+		t.Fatalf("expected identity to be linked to user %d, got %d", existing.ID, user.ID)
+	}
+
+	// a new subject with an unverified email gets a new account
+	provider.claims = domain.OIDCClaims{Subject: "sub-2", Email: "user@example.com"}
+	user, err = login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == existing.ID || user.Email != "" {
+		t.Fatalf("expected a new user without email, got %+v", user)
+	}
+
+	again, err := login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Fatalf("expected the linked user %d, got %d", user.ID, again.ID)
+	}
+
+	_, err = service.FinishLogin(context.Background(), "fake", "unknown state", "code")
+	if !errors.Is(err, domain.ErrInvalidOIDCState) {
+		t.Fatal("expected unknown state to be rejected, got", err)
+	}
+}
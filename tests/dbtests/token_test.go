@@ -0,0 +1,199 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/auth"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/repositories"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repos.UserRepository.CreateUser(context.Background(), &domain.User{Login: "user1", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := repos.UserRepository.GetUserByLogin(context.Background(), "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hash := range []string{"hash1", "hash2"} {
+		err = repos.TokenRepository.CreateRefreshToken(context.Background(), &domain.RefreshToken{
+			Hash:      hash,
+			FamilyID:  "family1",
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok, err := repos.TokenRepository.MarkRefreshTokenUsed(context.Background(), "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected unused token to be marked as used")
+	}
+
+	ok, err = repos.TokenRepository.MarkRefreshTokenUsed(context.Background(), "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected used token to not be marked as used again")
+	}
+
+	err = repos.TokenRepository.RevokeTokenFamily(context.Background(), "family1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := repos.TokenRepository.GetRefreshToken(context.Background(), "hash2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RevokedAt == nil {
+		t.Fatal("expected the whole family to be revoked")
+	}
+
+	_, err = repos.TokenRepository.GetRefreshToken(context.Background(), "unknown")
+	if !errors.Is(err, domain.ErrInvalidRefreshToken) {
+		t.Fatal("expected ErrInvalidRefreshToken, got", err)
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repos.TokenRepository.RevokeAccessToken(context.Background(), "jti1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := repos.TokenRepository.IsAccessTokenRevoked(context.Background(), "jti1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("expected jti1 to be revoked")
+	}
+
+	revoked, err = repos.TokenRepository.IsAccessTokenRevoked(context.Background(), "jti2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked {
+		t.Fatal("expected jti2 to not be revoked")
+	}
+}
+
+func newTestAuth(t *testing.T, repos *repositories.AllRepositories) *auth.Auth {
+	jwtCfg := config.JWTConfig{
+		Issuer:          "tefsi",
+		Keys:            []config.JWTKey{{ID: "test", Algorithm: "HS256", Secret: "0123456789abcdef0123456789abcdef"}},
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+	}
+	keys, err := auth.NewKeyManager(jwtCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authService := services.NewDefaultAuthService(repos.UserRepository, repos.TokenRepository, repos.APIKeyRepository)
+	return auth.NewAuth(authService, keys, jwtCfg, config.MFAConfig{})
+}
+
+func TestSessions(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuth(t, repos)
+
+	user := domain.User{Login: "user1", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	phone, err := a.IssueTokens(context.Background(), &user, &domain.SessionInfo{UserAgent: "phone", IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := a.IssueTokens(context.Background(), &user, &domain.SessionInfo{UserAgent: "laptop", IP: "10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := a.GetSessions(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*sessions) != 2 {
+		t.Fatal("expected 2 sessions, got", len(*sessions))
+	}
+
+	var phoneSession string
+	for _, session := range *sessions {
+		if session.UserAgent == "phone" {
+			phoneSession = session.ID
+		}
+	}
+	err = a.RevokeSession(context.Background(), user.ID+1, phoneSession)
+	if !errors.Is(err, domain.ErrSessionNotFound) {
+		t.Fatal("expected session of another user to be rejected, got", err)
+	}
+	err = a.RevokeSession(context.Background(), user.ID, phoneSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.GetUserFromJWT("Bearer " + phone.Token)
+	if !errors.Is(err, domain.ErrSessionRevoked) {
+		t.Fatal("expected token of the revoked session to be rejected, got", err)
+	}
+	_, err = a.Refresh(context.Background(), phone.RefreshToken)
+	if err == nil {
+		t.Fatal("expected refresh token of the revoked session to be rejected")
+	}
+	_, err = a.GetUserFromJWT("Bearer " + laptop.Token)
+	if err != nil {
+		t.Fatal("expected the other session to keep working:", err)
+	}
+}
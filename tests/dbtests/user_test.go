@@ -2,9 +2,17 @@ package dbtests
 
 import (
 	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"tefsi/internal/config"
 	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"tefsi/internal/password"
+	"tefsi/internal/services"
 	"tefsi/tests"
 	"testing"
+	"time"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -24,3 +32,396 @@ func TestCreateUser(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUserRoles(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := domain.User{Login: "packer", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repos.UserRepository.AssignRole(context.Background(), user.ID, "fulfilment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packer, err := repos.UserRepository.GetUserByLogin(context.Background(), "packer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !packer.HasPermission(domain.PermissionOrdersFulfil) {
+		t.Fatalf("expected packer to have %s, got %v", domain.PermissionOrdersFulfil, packer.Permissions)
+	}
+	if packer.HasPermission(domain.PermissionCatalogWrite) || packer.IsAdmin {
+		t.Fatalf("expected packer to not be able to edit the catalog, got %v", packer.Permissions)
+	}
+
+	err = repos.UserRepository.RemoveRole(context.Background(), user.ID, "fulfilment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packer, err = repos.UserRepository.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packer.Permissions) != 0 {
+		t.Fatal("expected no permissions, got", packer.Permissions)
+	}
+
+	err = repos.UserRepository.AssignRole(context.Background(), user.ID, "nonexistent")
+	if !errors.Is(err, domain.ErrUnknownRole) {
+		t.Fatal("expected ErrUnknownRole, got", err)
+	}
+
+	err = repos.UserRepository.AssignRole(context.Background(), user.ID+1000, "fulfilment")
+	if !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatal("expected ErrUserNotFound, got", err)
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, login := range []string{"alice", "bob", "alina"} {
+		err = repos.UserRepository.CreateUser(context.Background(), &domain.User{Login: login, Password: "password"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := repos.UserRepository.GetUsers(context.Background(), &domain.UserQuery{Search: "AL", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Total != 2 {
+		t.Fatal("expected 2 users matching 'AL', got", list.Total)
+	}
+	if len(list.Users) != 1 || list.Users[0].Login != "alina" {
+		t.Fatalf("expected the newest user alina on the first page, got %+v", list.Users)
+	}
+
+	list, err = repos.UserRepository.GetUsers(context.Background(), &domain.UserQuery{Search: "AL", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Users) != 1 || list.Users[0].Login != "alice" {
+		t.Fatalf("expected alice on the second page, got %+v", list.Users)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := domain.User{Login: "user1", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repos.UserRepository.SetUserDisabled(context.Background(), user.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled, err := repos.UserRepository.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(disabled.CanLogIn(), domain.ErrUserDisabled) {
+		t.Fatal("expected disabled user to not be able to log in")
+	}
+
+	err = repos.UserRepository.SetUserDisabled(context.Background(), user.ID+1, true)
+	if !errors.Is(err, domain.ErrUserNotFound) {
+		t.Fatal("expected ErrUserNotFound, got", err)
+	}
+}
+
+func TestEnsureAdmin(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := services.NewDefaultUserService(repos.UserRepository, mail.NewMemoryMailer(), &config.Config{})
+
+	squatter := domain.User{Login: "admin", Password: "squatter password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &squatter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = service.EnsureAdmin(context.Background(), "admin", "admin password")
+	if err == nil {
+		t.Fatal("expected an error for an existing account with a different password")
+	}
+	err = service.EnsureAdmin(context.Background(), "admin", "squatter password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := repos.UserRepository.GetUserByID(context.Background(), squatter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsAdmin {
+		t.Fatal("expected the existing account to not be promoted")
+	}
+
+	err = service.EnsureAdmin(context.Background(), "root", "admin password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = repos.UserRepository.GetUserByLogin(context.Background(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsAdmin {
+		t.Fatal("expected the created account to be an admin")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := mail.NewMemoryMailer()
+	service := services.NewDefaultUserService(repos.UserRepository, mailer, &config.Config{
+		PublicURL:        "http://shop.example.com",
+		PasswordResetTTL: time.Hour,
+	})
+
+	user := domain.User{Login: "user1", Password: "old password", Email: "user@example.com"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = service.RequestPasswordReset(context.Background(), &domain.ForgotPasswordRequest{Email: "nobody@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.RequestPasswordReset(context.Background(), &domain.ForgotPasswordRequest{Login: "user1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatal("expected 1 message, got", len(messages))
+	}
+	if messages[0].To != user.Email {
+		t.Fatalf("expected message to %s, got %s", user.Email, messages[0].To)
+	}
+	token := tokenFromMail(t, messages[0])
+
+	userID, err := service.ResetPassword(context.Background(), token, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != user.ID {
+		t.Fatalf("expected user id %d, got %d", user.ID, userID)
+	}
+
+	err = repos.UserRepository.CheckUserByDomain(context.Background(), &domain.User{Login: user.Login, Password: "new password"})
+	if err != nil {
+		t.Fatal("expected new password to work:", err)
+	}
+
+	_, err = service.ResetPassword(context.Background(), token, "another password")
+	if !errors.Is(err, domain.ErrInvalidToken) {
+		t.Fatal("expected used token to be invalid, got", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := mail.NewMemoryMailer()
+	service := services.NewDefaultUserService(repos.UserRepository, mailer, &config.Config{
+		PublicURL:            "http://shop.example.com",
+		EmailVerificationTTL: time.Hour,
+	})
+
+	err = service.CreateUser(context.Background(), &domain.User{Login: "user0", Password: "password", Email: "not an email"})
+	if !errors.Is(err, domain.ErrInvalidEmail) {
+		t.Fatal("expected invalid email to be rejected, got", err)
+	}
+
+	user := domain.User{Login: "user1", Password: "password", Email: "  User@Example.COM "}
+	err = service.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "user@example.com" {
+		t.Fatal("expected normalized email, got", user.Email)
+	}
+
+	// an unverified claim doesn't block whoever can read the mailbox
+	owner := domain.User{Login: "user2", Password: "password", Email: "USER@example.com"}
+	err = service.CreateUser(context.Background(), &owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 2 {
+		t.Fatal("expected 2 messages, got", len(messages))
+	}
+	userID, err := service.VerifyEmail(context.Background(), tokenFromMail(t, messages[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != owner.ID {
+		t.Fatalf("expected user id %d, got %d", owner.ID, userID)
+	}
+
+	_, err = service.VerifyEmail(context.Background(), tokenFromMail(t, messages[0]))
+	if !errors.Is(err, domain.ErrInvalidToken) {
+		t.Fatal("expected the link of the released claim to be invalid, got", err)
+	}
+	released, err := repos.UserRepository.GetUserByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released.Email != "" {
+		t.Fatal("expected the unverified claim to be released, got", released.Email)
+	}
+
+	verified, err := repos.UserRepository.GetUserByEmail(context.Background(), "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.EmailVerified || verified.ID != owner.ID {
+		t.Fatalf("expected email to be verified by user %d, got %+v", owner.ID, verified)
+	}
+}
+
+// extracts the token from the link in a mail
+func tokenFromMail(t *testing.T, msg domain.MailMessage) string {
+	_, link, found := strings.Cut(msg.Body, "token=")
+	if !found {
+		t.Fatalf("expected a link with a token, got:\n%s", msg.Body)
+	}
+	token, _, _ := strings.Cut(link, "\n")
+	token, err := url.QueryUnescape(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPasswordRehashOnLogin(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := domain.User{Login: "legacy", Password: "password"}
+	err = repos.UserRepository.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a hash made before argon2id was configured
+	cfg := tests.TestPasswordConfig
+	cfg.Algorithm = "bcrypt"
+	bcryptHasher, err := password.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash, err := bcryptHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(context.Background(), "UPDATE users SET password = $2 WHERE id = $1", user.ID, legacyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repos.UserRepository.CheckUserByDomain(context.Background(), &domain.User{Login: "legacy", Password: "wrong"})
+	if !errors.Is(err, domain.ErrInvalidCredentials) {
+		t.Fatal("expected invalid credentials, got", err)
+	}
+
+	err = repos.UserRepository.CheckUserByDomain(context.Background(), &domain.User{Login: "legacy", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hash string
+	err = db.QueryRow(context.Background(), "SELECT password FROM users WHERE id = $1", user.ID).Scan(&hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Fatal("expected the password to be rehashed with argon2id, got", hash)
+	}
+
+	err = repos.UserRepository.CheckUserByDomain(context.Background(), &domain.User{Login: "legacy", Password: "password"})
+	if err != nil {
+		t.Fatal("expected the rehashed password to work:", err)
+	}
+}
@@ -0,0 +1,162 @@
+package dbtests
+
+import (
+	"context"
+	"errors"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"tefsi/internal/services"
+	"tefsi/tests"
+	"testing"
+	"time"
+)
+
+func TestItemVariants(t *testing.T) {
+	container, db, err := tests.CreateContainer("test-db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	defer container.Terminate(context.Background())
+
+	repos, err := tests.CreateRepos(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewDefaultItemService(repos.ItemRepository, time.Second)
+	items := createTestItems(t, db, "t-shirt", "mug")
+	ctx := context.Background()
+
+	options := []domain.ItemOption{
+		{Name: "size", Values: []string{"S", "M"}},
+		{Name: "color", Values: []string{"red"}},
+	}
+	item, err := service.SetItemOptions(ctx, items[0], options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(item.Options) != 2 || item.Version != 2 {
+		t.Fatalf("expected 2 options and version 2, got %+v", item)
+	}
+
+	price := 150
+	small := domain.Variant{ItemID: items[0], SKU: "TS-S", Options: map[string]string{"size": "S", "color": "red"}, Available: true}
+	medium := domain.Variant{ItemID: items[0], SKU: "TS-M", Options: map[string]string{"size": "M", "color": "red"}, Price: &price}
+	for _, variant := range []*domain.Variant{&small, &medium} {
+		err = service.CreateVariant(ctx, variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	duplicate := domain.Variant{ItemID: items[0], SKU: "TS-S", Options: map[string]string{"size": "M", "color": "red"}}
+	err = service.CreateVariant(ctx, &duplicate)
+	if !errors.Is(err, domain.ErrSKUTaken) {
+		t.Fatal("expected a taken SKU to be rejected, got", err)
+	}
+	duplicate.SKU = "TS-M2"
+	err = service.CreateVariant(ctx, &duplicate)
+	if !errors.Is(err, domain.ErrVariantExists) {
+		t.Fatal("expected the same options to be rejected, got", err)
+	}
+	invalid := domain.Variant{ItemID: items[0], SKU: "TS-XL", Options: map[string]string{"size": "XL", "color": "red"}}
+	err = service.CreateVariant(ctx, &invalid)
+	if !errors.Is(err, domain.ErrInvalidVariant) {
+		t.Fatal("expected an unknown option value to be rejected, got", err)
+	}
+
+	_, err = service.SetItemOptions(ctx, items[0], []domain.ItemOption{{Name: "size", Values: []string{"S", "M"}}})
+	if !errors.Is(err, domain.ErrInvalidVariant) {
+		t.Fatal("expected options that don't fit the variants to be rejected, got", err)
+	}
+
+	item, err = service.GetItemByID(ctx, items[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(item.Variants) != 2 || item.Variants[0].SKU != "TS-S" || *item.Variants[1].Price != 150 {
+		t.Fatalf("expected the variants in the item, got %+v", item.Variants)
+	}
+	item, err = service.GetItemByID(ctx, items[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Options != nil || item.Variants != nil {
+		t.Fatalf("expected no options or variants, got %+v", item)
+	}
+
+	userService := services.NewDefaultUserService(repos.UserRepository, mail.NewMemoryMailer(), &config.Config{
+		GuestCartTTL: time.Hour,
+	})
+	_, err = userService.SetGuestCartItem(ctx, "", &domain.ItemWithAmount{ItemID: items[0], Amount: 1})
+	if !errors.Is(err, domain.ErrVariantRequired) {
+		t.Fatal("expected an item with variants to need one, got", err)
+	}
+	_, err = userService.SetGuestCartItem(ctx, "", &domain.ItemWithAmount{ItemID: items[1], VariantID: small.ID, Amount: 1})
+	if !errors.Is(err, domain.ErrVariantNotFound) {
+		t.Fatal("expected a variant of another item to be rejected, got", err)
+	}
+	_, err = userService.SetGuestCartItem(ctx, "", &domain.ItemWithAmount{ItemID: items[0], VariantID: medium.ID, Amount: 1})
+	if !errors.Is(err, domain.ErrVariantUnavailable) {
+		t.Fatal("expected an unavailable variant to be rejected, got", err)
+	}
+
+	cart, err := userService.SetGuestCartItem(ctx, "", &domain.ItemWithAmount{ItemID: items[0], VariantID: small.ID, Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	medium.Available = true
+	err = service.UpdateVariant(ctx, &medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = userService.SetGuestCartItem(ctx, cart.ID, &domain.ItemWithAmount{ItemID: items[0], VariantID: medium.ID, Amount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guestCart, err := repos.UserRepository.GetGuestCart(ctx, cart.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*guestCart) != 2 || (*guestCart)[0].VariantID != small.ID || (*guestCart)[1].Amount != 2 {
+		t.Fatalf("expected a line per variant, got %+v", *guestCart)
+	}
+
+	orderService := services.NewDefaultOrderService(repos.OrderRepository, repos.UserRepository)
+	address := &domain.PostalAddress{Name: "Guest", Line1: "1 Main St", City: "Springfield", Country: "us"}
+	order := domain.Order{
+		StatusID:        1,
+		ContactEmail:    "guest@example.com",
+		Items:           []domain.ItemWithAmount{{ItemID: items[0], VariantID: medium.ID, Amount: 1}},
+		ShippingAddress: address,
+	}
+	err = orderService.CreateOrder(ctx, &order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := orderService.GetOrderByID(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Items) != 1 || saved.Items[0].VariantID != medium.ID {
+		t.Fatalf("expected the ordered variant, got %+v", saved.Items)
+	}
+
+	err = service.DeleteVariant(ctx, items[0], medium.ID)
+	if !errors.Is(err, domain.ErrVariantInUse) {
+		t.Fatal("expected an ordered variant to be kept, got", err)
+	}
+	err = service.DeleteVariant(ctx, items[0], small.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guestCart, err = repos.UserRepository.GetGuestCart(ctx, cart.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*guestCart) != 1 {
+		t.Fatal("expected the deleted variant to leave the cart, got", *guestCart)
+	}
+}
@@ -3,6 +3,7 @@ package tests
 import (
 	"context"
 	"fmt"
+	"tefsi/internal/config"
 	"tefsi/internal/inits"
 	"tefsi/internal/repositories"
 
@@ -56,13 +57,22 @@ func CreateContainer(dbName string) (testcontainers.Container, *pgxpool.Pool, er
 	return container, db, nil
 }
 
+// cheap parameters so tests don't spend their time hashing
+var TestPasswordConfig = config.PasswordConfig{
+	Algorithm:         "argon2id",
+	BcryptCost:        4,
+	Argon2Memory:      64,
+	Argon2Iterations:  1,
+	Argon2Parallelism: 1,
+}
+
 func CreateRepos(db *pgxpool.Pool) (*repositories.AllRepositories, error) {
 	tables, err := inits.GetAllTables(db)
 	if err != nil {
 		return nil, err
 	}
 
-	repos, err := inits.InitRepositories(db, tables)
+	repos, err := inits.InitRepositories(db, tables, &config.Config{Password: TestPasswordConfig, SearchLanguage: "russian"})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestStockAdjustmentValidate(t *testing.T) {
+	valid := domain.StockAdjustment{ItemID: 1, Delta: -2, Reason: domain.AdjustmentReasonDamaged}
+	if err := valid.Validate(); err != nil {
+		t.Fatal("expected a valid adjustment, got", err)
+	}
+
+	invalid := map[string]domain.StockAdjustment{
+		"no delta":       {ItemID: 1, Reason: domain.AdjustmentReasonRestock},
+		"no reason":      {ItemID: 1, Delta: 5},
+		"unknown reason": {ItemID: 1, Delta: 5, Reason: "lost"},
+		"order reason":   {ItemID: 1, Delta: -1, Reason: domain.AdjustmentReasonOrder},
+	}
+	for name, adjustment := range invalid {
+		if err := adjustment.Validate(); !errors.Is(err, domain.ErrInvalidAdjustment) {
+			t.Errorf("%s: expected ErrInvalidAdjustment, got %v", name, err)
+		}
+	}
+}
+
+func TestStockShortageError(t *testing.T) {
+	var err error = &domain.StockShortageError{Lines: []domain.StockShortage{
+		{ItemID: 1, Requested: 3, Available: 1},
+		{ItemID: 2, VariantID: 5, Requested: 1, Available: 0},
+	}}
+	if !errors.Is(err, domain.ErrInsufficientStock) {
+		t.Fatal("expected the shortage to match ErrInsufficientStock")
+	}
+	if !strings.Contains(err.Error(), "item 1 variant 0: 3 requested, 1 available") ||
+		!strings.Contains(err.Error(), "item 2 variant 5") {
+		t.Fatal("expected every line in the message, got", err.Error())
+	}
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tefsi/internal/domain"
+	"tefsi/internal/handlers"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// item service that only supports updates, the item is at version 3
+type versionedItemService struct {
+	handlers.ItemService
+}
+
+func (s *versionedItemService) UpdateItem(ctx context.Context, id int, version int, update *domain.ItemUpdate) (*domain.Item, error) {
+	if version != 3 {
+		return nil, domain.ErrVersionConflict
+	}
+	return &domain.Item{ID: id, Title: *update.Title, Version: 4}, nil
+}
+
+func TestItemIfMatch(t *testing.T) {
+	handler := handlers.NewItemHandler(&versionedItemService{})
+	r := chi.NewRouter()
+	r.Patch("/item/{id}", handler.PatchItem)
+
+	cases := []struct {
+		ifMatch string
+		status  int
+	}{
+		{"", http.StatusPreconditionRequired},
+		{`"2"`, http.StatusPreconditionFailed},
+		{`W/"3"`, http.StatusPreconditionFailed},
+		{"*", http.StatusPreconditionFailed},
+		{`"3"`, http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPatch, "/item/1", strings.NewReader(`{"title": "new title"}`))
+		if c.ifMatch != "" {
+			req.Header.Set("If-Match", c.ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("expected %d for If-Match '%s', got %d", c.status, c.ifMatch, w.Code)
+		}
+		if w.Code == http.StatusOK && w.Header().Get("ETag") != `"4"` {
+			t.Errorf("expected the ETag of the new version, got '%s'", w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"errors"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestItemQueryValidate(t *testing.T) {
+	low, high := 10, 5
+	invalid := []domain.ItemQuery{
+		{MinPrice: &low, MaxPrice: &high},
+		{Limit: -1},
+		{Sort: "popularity"},
+		{Sort: domain.ItemSortPrice, Cursor: &domain.ItemCursor{Sort: domain.ItemSortTitle}},
+		{Offset: 10, Cursor: &domain.ItemCursor{}},
+		{Sort: domain.ItemSortRelevance},
+		{Attributes: map[string][]string{"color": {}}},
+	}
+	for _, query := range invalid {
+		if query.Validate() == nil {
+			t.Errorf("expected %+v to be invalid", query)
+		}
+	}
+
+	zero := 0
+	query := domain.ItemQuery{MinPrice: &zero, MaxPrice: &zero, Sort: domain.ItemSortNewest}
+	if err := query.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	query = domain.ItemQuery{Search: "phone"}
+	if query.SortKey() != domain.ItemSortRelevance {
+		t.Fatal("expected searches to be sorted by relevance by default, got", query.SortKey())
+	}
+}
+
+func TestItemCursor(t *testing.T) {
+	query := domain.ItemQuery{Sort: domain.ItemSortTitleDesc, Limit: 2}
+	items := []domain.Item{{ID: 1, Title: "b", Price: 3}, {ID: 7, Title: "a", Price: 5}}
+
+	if cursor := query.NextCursor(items[:1]); cursor != "" {
+		t.Fatal("expected no cursor after the last page, got", cursor)
+	}
+
+	cursor, err := domain.ParseItemCursor(query.NextCursor(items))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := domain.ItemCursor{Sort: domain.ItemSortTitleDesc, ID: 7, Title: "a"}
+	if *cursor != expected {
+		t.Fatalf("expected %+v, got %+v", expected, *cursor)
+	}
+
+	_, err = domain.ParseItemCursor("not a cursor")
+	if !errors.Is(err, domain.ErrInvalidCursor) {
+		t.Fatal("expected invalid cursor, got", err)
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"tefsi/internal/auth"
+	"tefsi/internal/config"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func pemKey(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func testKeys(t *testing.T) []config.JWTKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []config.JWTKey{
+		{ID: "hmac", Algorithm: "HS256", Secret: "0123456789abcdef0123456789abcdef"},
+		{ID: "rsa", Algorithm: "RS256", PrivateKey: pemKey(t, rsaKey)},
+		{ID: "ec", Algorithm: "ES256", PrivateKey: pemKey(t, ecKey)},
+		{ID: "ed", Algorithm: "EdDSA", PrivateKey: pemKey(t, edKey)},
+	}
+}
+
+func parse(manager *auth.KeyManager, token string) error {
+	parser := jwt.NewParser(jwt.WithValidMethods(manager.Algorithms()))
+	_, err := parser.Parse(token, manager.Keyfunc)
+	return err
+}
+
+func TestKeyManagerAlgorithms(t *testing.T) {
+	keys := testKeys(t)
+
+	for _, key := range keys {
+		manager, err := auth.NewKeyManager(config.JWTConfig{SigningKeyID: key.ID, Keys: keys})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := manager.Sign(jwt.RegisteredClaims{Subject: "user1"})
+		if err != nil {
+			t.Fatal(key.Algorithm, err)
+		}
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Header["kid"] != key.ID {
+			t.Fatalf("expected kid %s, got %v", key.ID, parsed.Header["kid"])
+		}
+
+		if err := parse(manager, token); err != nil {
+			t.Fatal(key.Algorithm, err)
+		}
+	}
+}
+
+func TestKeyManagerRotation(t *testing.T) {
+	keys := testKeys(t)
+
+	before, err := auth.NewKeyManager(config.JWTConfig{Keys: keys[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(jwt.RegisteredClaims{Subject: "user1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retired := keys[0]
+	retired.VerifyOnly = true
+	after, err := auth.NewKeyManager(config.JWTConfig{Keys: []config.JWTKey{retired, keys[1]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parse(after, oldToken); err != nil {
+		t.Fatal("token signed before the rotation should still be valid:", err)
+	}
+
+	newToken, err := after.Sign(jwt.RegisteredClaims{Subject: "user1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(before, newToken); err == nil {
+		t.Fatal("token signed with an unknown key should be invalid")
+	}
+
+	if _, err := auth.NewKeyManager(config.JWTConfig{Keys: []config.JWTKey{retired}}); err == nil {
+		t.Fatal("expected an error when there is no active signing key")
+	}
+}
+
+func TestKeyManagerJWKS(t *testing.T) {
+	manager, err := auth.NewKeyManager(config.JWTConfig{Keys: testKeys(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := manager.JWKS()
+	if len(jwks.Keys) != 3 {
+		t.Fatal("expected 3 public keys, got", len(jwks.Keys))
+	}
+
+	expected := map[string]string{"rsa": "RSA", "ec": "EC", "ed": "OKP"}
+	for _, key := range jwks.Keys {
+		if expected[key.KeyID] != key.KeyType {
+			t.Fatalf("expected key %s to be %s, got %s", key.KeyID, expected[key.KeyID], key.KeyType)
+		}
+	}
+}
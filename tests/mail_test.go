@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"tefsi/internal/domain"
+	"tefsi/internal/mail"
+	"testing"
+)
+
+func TestMemoryMailer(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+
+	err := mailer.Send(context.Background(), &domain.MailMessage{To: "user@example.com", Subject: "hi", Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != "user@example.com" {
+		t.Fatalf("expected 1 message to user@example.com, got %+v", messages)
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := mail.NewFileMailer("tefsi <noreply@example.com>", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), &domain.MailMessage{
+		To:      "user@example.com",
+		Subject: "hi\r\nBcc: someone@example.com",
+		Body:    "hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatal("expected 1 file, got", len(files))
+	}
+
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "To: user@example.com\r\n") {
+		t.Fatalf("expected To header, got:\n%s", content)
+	}
+	if strings.Contains(string(content), "\r\nBcc:") {
+		t.Fatalf("header injection wasn't prevented:\n%s", content)
+	}
+
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected the message to be readable only by the owner, got %s", info.Mode().Perm())
+	}
+}
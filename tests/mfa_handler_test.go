@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tefsi/internal/domain"
+	"tefsi/internal/handlers"
+	"tefsi/internal/middleware"
+	"tefsi/internal/throttle"
+	"testing"
+)
+
+// user service that rejects every mfa code
+type wrongCodeUserService struct {
+	handlers.UserService
+	verified int
+}
+
+func (s *wrongCodeUserService) VerifyMFA(ctx context.Context, userID int, code *domain.MFACode) error {
+	s.verified++
+	return domain.ErrInvalidMFACode
+}
+
+func TestDisableMFAThrottled(t *testing.T) {
+	service := &wrongCodeUserService{}
+	cfg := testThrottleConfig()
+	// no backoff, the codes are only stopped by the lockout
+	cfg.FreeAttempts = 100
+	limiter := throttle.NewLimiter(throttle.NewMemoryStore(), cfg)
+	handler := handlers.NewUserHandler(service, nil, nil, limiter)
+	user := &domain.User{ID: 1, Login: "user", MFAEnabled: true}
+
+	codes := 0
+	locked := false
+	for range 10 {
+		req := httptest.NewRequest(http.MethodPost, "/user/mfa/disable", strings.NewReader(`{"code": "000000"}`))
+		req = req.WithContext(middleware.WithUser(req.Context(), user))
+		w := httptest.NewRecorder()
+		handler.DisableMFA(w, req)
+
+		if w.Code == http.StatusTooManyRequests {
+			locked = w.Header().Get("Retry-After") == "86400"
+			break
+		}
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected %d for a wrong code, got %d", http.StatusBadRequest, w.Code)
+		}
+		codes++
+	}
+
+	if !locked {
+		t.Fatal("expected the login to be locked out")
+	}
+	if codes != cfg.MaxFailures || service.verified != cfg.MaxFailures {
+		t.Fatalf("expected %d codes to be checked before the lockout, got %d", cfg.MaxFailures, service.verified)
+	}
+}
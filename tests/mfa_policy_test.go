@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"context"
+	"tefsi/internal/auth"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"testing"
+	"time"
+)
+
+// auth service that accepts new sessions and refresh tokens
+type sessionAuthService struct {
+	auth.AuthService
+}
+
+func (s *sessionAuthService) CreateSession(ctx context.Context, session *domain.Session) error {
+	return nil
+}
+
+func (s *sessionAuthService) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
+	return nil
+}
+
+func TestMFAPolicyCustomRoles(t *testing.T) {
+	keys, err := auth.NewKeyManager(config.JWTConfig{Keys: testKeys(t)[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtCfg := config.JWTConfig{AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}
+	a := auth.NewAuth(&sessionAuthService{}, keys, jwtCfg, config.MFAConfig{RequireForAdmins: true})
+
+	cases := []struct {
+		user        domain.User
+		permissions int
+	}{
+		// a custom role with admin permissions needs mfa as much as the admin role
+		{domain.User{ID: 1, Roles: []string{"support"}, Permissions: []string{domain.PermissionUsersManage, domain.PermissionOrdersManage}}, 0},
+		{domain.User{ID: 2, Roles: []string{domain.RoleAdmin}, IsAdmin: true, Permissions: domain.AllPermissions}, 0},
+		{domain.User{ID: 3, Roles: []string{"support"}, Permissions: []string{domain.PermissionUsersManage}, MFAEnabled: true}, 1},
+	}
+	for _, c := range cases {
+		user := c.user
+		_, err := a.IssueTokens(context.Background(), &user, &domain.SessionInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(user.Permissions) != c.permissions {
+			t.Errorf("expected %d permissions for user %d, got %v", c.permissions, user.ID, user.Permissions)
+		}
+	}
+}
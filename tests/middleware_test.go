@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"tefsi/internal/domain"
+	"tefsi/internal/middleware"
+	"testing"
+)
+
+// authenticates every request as the given user
+type staticAuth struct {
+	user *domain.User
+}
+
+func (a *staticAuth) Authenticate(r *http.Request) (*domain.User, error) {
+	return a.user, nil
+}
+
+func TestRequireUser(t *testing.T) {
+	cases := []struct {
+		user   *domain.User
+		status int
+	}{
+		{&domain.User{ID: 1, Login: "user"}, http.StatusNoContent},
+		{&domain.User{Login: "api-key:abcd", Permissions: []string{domain.PermissionUsersManage}}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		mw := middleware.New(&staticAuth{user: c.user})
+		handler := mw.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/logout", nil))
+		if w.Code != c.status {
+			t.Errorf("expected %d for '%s', got %d", c.status, c.user.Login, w.Code)
+		}
+	}
+}
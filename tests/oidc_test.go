@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"tefsi/internal/config"
+	"tefsi/internal/domain"
+	"tefsi/internal/oidc"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minimal identity provider issuing ID tokens for the codes it handed out
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// code -> the challenge and nonce of the authorization request
+	codes    map[string]url.Values
+	audience string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: make(map[string]url.Values), audience: "tefsi-client"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(domain.JWKS{Keys: []domain.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "idp-key",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		request, ok := idp.codes[r.PostForm.Get("code")]
+		if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != request.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(idp.codes, r.PostForm.Get("code"))
+
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            idp.server.URL,
+			"sub":            "external-user",
+			"aud":            idp.audience,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          request.Get("nonce"),
+			"email":          "User@Example.com",
+			"email_verified": true,
+		})
+		token.Header["kid"] = "idp-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// what the idp would do after the user logged in: remember the request and hand out a code
+func (idp *mockIdP) authorize(t *testing.T, authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("code_challenge_method") != "S256" {
+		t.Fatal("expected a pkce challenge in", authURL)
+	}
+	idp.codes["code"] = u.Query()
+	return "code"
+}
+
+func (idp *mockIdP) provider() *oidc.Provider {
+	return oidc.NewProvider(config.OIDCProvider{
+		Name:        "mock",
+		Issuer:      idp.server.URL,
+		ClientID:    "tefsi-client",
+		RedirectURL: "http://localhost:8080/users/oidc/mock/callback",
+	}, idp.server.Client())
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.authorize(t, authURL)
+
+	claims, err := provider.Authenticate(context.Background(), code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "external-user" || claims.Email != "User@Example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestOIDCRejectsBadTokens(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := idp.authorize(t, authURL)
+	_, err = provider.Authenticate(context.Background(), code, "other verifier", "nonce")
+	if err == nil {
+		t.Fatal("expected wrong code verifier to be rejected")
+	}
+
+	code = idp.authorize(t, authURL)
+	_, err = provider.Authenticate(context.Background(), code, "verifier", "other nonce")
+	if !errors.Is(err, domain.ErrInvalidIDToken) {
+		t.Fatal("expected wrong nonce to be rejected, got", err)
+	}
+
+	idp.audience = "someone-else"
+	code = idp.authorize(t, authURL)
+	_, err = provider.Authenticate(context.Background(), code, "verifier", "nonce")
+	if !errors.Is(err, domain.ErrInvalidIDToken) {
+		t.Fatal("expected token for another client to be rejected, got", err)
+	}
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tefsi/internal/domain"
+	"tefsi/internal/handlers"
+	"testing"
+)
+
+// order service that only records the created order
+type recordingOrderService struct {
+	handlers.OrderService
+	created *domain.Order
+}
+
+func (s *recordingOrderService) CreateOrder(ctx context.Context, order *domain.Order) error {
+	s.created = order
+	order.ID = 1
+	return nil
+}
+
+func TestCreateOrderIgnoresStatus(t *testing.T) {
+	for _, status := range []string{"0", "2", "3"} {
+		service := &recordingOrderService{}
+		handler := handlers.NewOrderHandler(service, false)
+
+		body := `{"status_id": ` + status + `, "contact_email": "guest@example.com", "items": [{"item_id": 1, "amount": 1}]}`
+		req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.CreateOrder(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected %d, got %d", http.StatusCreated, w.Code)
+		}
+		if service.created.StatusID != domain.StatusInProgress {
+			t.Errorf("posted status %s was kept as %d", status, service.created.StatusID)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"strings"
+	"tefsi/internal/config"
+	"tefsi/internal/password"
+	"testing"
+)
+
+func newTestHasher(t *testing.T, algorithm string) *password.Hasher {
+	cfg := TestPasswordConfig
+	cfg.Algorithm = algorithm
+	hasher, err := password.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestPasswordHashing(t *testing.T) {
+	for _, algorithm := range []string{"argon2id", "bcrypt"} {
+		hasher := newTestHasher(t, algorithm)
+
+		hash, err := hasher.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm == "argon2id" && !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+			t.Fatal("expected a PHC argon2id hash, got", hash)
+		}
+
+		ok, err := hasher.Verify("password", hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("%s: expected the password to match", algorithm)
+		}
+
+		ok, err = hasher.Verify("wrong password", hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Fatalf("%s: expected a wrong password not to match", algorithm)
+		}
+
+		if hasher.NeedsRehash(hash) {
+			t.Fatalf("%s: expected a fresh hash not to need a rehash", algorithm)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHasher := newTestHasher(t, "bcrypt")
+	argon2Hasher := newTestHasher(t, "argon2id")
+
+	bcryptHash, err := bcryptHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hashes of the other algorithm still verify but get upgraded
+	ok, err := argon2Hasher.Verify("password", bcryptHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected a bcrypt hash to verify with argon2id configured")
+	}
+	if !argon2Hasher.NeedsRehash(bcryptHash) {
+		t.Fatal("expected a bcrypt hash to need a rehash with argon2id configured")
+	}
+
+	cfg := TestPasswordConfig
+	cfg.Argon2Iterations = 2
+	stronger, err := password.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := argon2Hasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stronger.NeedsRehash(argon2Hash) {
+		t.Fatal("expected a hash with old parameters to need a rehash")
+	}
+	ok, err = stronger.Verify("password", argon2Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected a hash with old parameters to still verify")
+	}
+
+	_, err = password.New(config.PasswordConfig{Algorithm: "md5"})
+	if err == nil {
+		t.Fatal("expected an unknown algorithm to be rejected")
+	}
+}
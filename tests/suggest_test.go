@@ -0,0 +1,41 @@
+package tests
+
+import (
+	"slices"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestSwitchKeyboardLayout(t *testing.T) {
+	cases := map[string]string{
+		"vfibyf":    "машина",
+		"ьфырштф":   "mashina",
+		"rjatq 42!": "кофей 42!",
+		"`kf":       "ёла",
+	}
+	for typed, expected := range cases {
+		if switched := domain.SwitchKeyboardLayout(typed); switched != expected {
+			t.Errorf("expected '%s' to be switched to '%s', got '%s'", typed, expected, switched)
+		}
+	}
+}
+
+func TestSuggestQuery(t *testing.T) {
+	query := domain.SuggestQuery{Query: "  VfiB ", Limit: 5}
+	if err := query.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if variants := query.Variants(); !slices.Equal(variants, []string{"vfib", "маши"}) {
+		t.Fatal("expected the query in both layouts, got", variants)
+	}
+
+	query = domain.SuggestQuery{Query: "123", Limit: 5}
+	if variants := query.Variants(); !slices.Equal(variants, []string{"123"}) {
+		t.Fatal("expected no second variant without letters, got", variants)
+	}
+
+	query = domain.SuggestQuery{Query: "phone", Limit: 0}
+	if query.Validate() == nil {
+		t.Fatal("expected a limit of 0 to be invalid")
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"tefsi/internal/config"
+	"tefsi/internal/throttle"
+	"testing"
+	"time"
+)
+
+func testThrottleConfig() config.LoginThrottleConfig {
+	return config.LoginThrottleConfig{
+		Store:           "memory",
+		FreeAttempts:    2,
+		BaseDelay:       time.Hour,
+		MaxDelay:        4 * time.Hour,
+		MaxFailures:     5,
+		IPMaxFailures:   10,
+		LockoutDuration: 24 * time.Hour,
+		Window:          48 * time.Hour,
+	}
+}
+
+// makes an attempt that is let through and fails
+func failLogin(t *testing.T, limiter *throttle.Limiter, login string, ip string) {
+	attempt, wait, err := limiter.Attempt(context.Background(), login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Fatalf("expected the attempt of '%s' from %s to go through, got a wait of %s", login, ip, wait)
+	}
+	err = limiter.Fail(context.Background(), attempt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// returns how long a new attempt has to wait, attempts that don't have to are taken back
+func attemptWait(t *testing.T, limiter *throttle.Limiter, login string, ip string) time.Duration {
+	attempt, wait, err := limiter.Attempt(context.Background(), login, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait == 0 {
+		err = limiter.Release(context.Background(), attempt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return wait
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	ctx := context.Background()
+	limiter := throttle.NewLimiter(throttle.NewMemoryStore(), testThrottleConfig())
+
+	// the free attempts don't slow anyone down
+	failLogin(t, limiter, "user", "10.0.0.1")
+	wait := attemptWait(t, limiter, "user", "10.0.0.1")
+	if wait != 0 {
+		t.Fatal("expected no wait after 1 failure, got", wait)
+	}
+
+	failLogin(t, limiter, "user", "10.0.0.1")
+	wait = attemptWait(t, limiter, "user", "10.0.0.1")
+	if wait <= 0 || wait > time.Hour {
+		t.Fatal("expected a wait of up to an hour after 2 failures, got", wait)
+	}
+
+	// throttled attempts don't count, so they don't make the wait longer
+	wait = attemptWait(t, limiter, "user", "10.0.0.2")
+	if wait <= 0 || wait > time.Hour {
+		t.Fatal("expected the same wait for the login from another ip, got", wait)
+	}
+
+	// other logins from the same ip aren't affected until the ip has too many failures
+	wait = attemptWait(t, limiter, "other", "10.0.0.1")
+	if wait != 0 {
+		t.Fatal("expected no wait for another login, got", wait)
+	}
+
+	err := limiter.Succeed(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait = attemptWait(t, limiter, "user", "10.0.0.2")
+	if wait != 0 {
+		t.Fatal("expected success to reset the login, got", wait)
+	}
+}
+
+func TestLimiterBackoffDoubles(t *testing.T) {
+	cfg := testThrottleConfig()
+	cfg.BaseDelay = 50 * time.Millisecond
+	limiter := throttle.NewLimiter(throttle.NewMemoryStore(), cfg)
+
+	failLogin(t, limiter, "user", "10.0.0.1")
+	failLogin(t, limiter, "user", "10.0.0.1")
+	time.Sleep(cfg.BaseDelay)
+	failLogin(t, limiter, "user", "10.0.0.1")
+
+	wait := attemptWait(t, limiter, "user", "10.0.0.1")
+	if wait <= cfg.BaseDelay || wait > 2*cfg.BaseDelay {
+		t.Fatal("expected the wait to double after 3 failures, got", wait)
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	cfg := testThrottleConfig()
+	cfg.FreeAttempts = 100
+	limiter := throttle.NewLimiter(throttle.NewMemoryStore(), cfg)
+
+	for range 5 {
+		failLogin(t, limiter, "user", "10.0.0.1")
+	}
+	wait := attemptWait(t, limiter, "user", "10.0.0.3")
+	if wait <= 23*time.Hour {
+		t.Fatal("expected the login to be locked out, got", wait)
+	}
+
+	// right passwords don't count against a shared ip
+	for i := range 20 {
+		wait = attemptWait(t, limiter, string(rune('a'+i)), "10.0.0.9")
+		if wait != 0 {
+			t.Fatal("expected no wait for right passwords, got", wait)
+		}
+	}
+
+	// spraying many logins from one ip locks the ip
+	for i := range 10 {
+		failLogin(t, limiter, string(rune('a'+i)), "10.0.0.9")
+	}
+	wait = attemptWait(t, limiter, "fresh", "10.0.0.9")
+	if wait <= 23*time.Hour {
+		t.Fatal("expected the ip to be locked out, got", wait)
+	}
+}
+
+// parallel attempts are counted before any of them is checked,
+// so a burst only gets as many password checks through as the limits allow
+func TestLimiterConcurrentAttempts(t *testing.T) {
+	cases := []struct {
+		name    string
+		cfg     func(*config.LoginThrottleConfig)
+		login   func(i int) string
+		allowed int64
+	}{
+		// the backoff starts after FreeAttempts failures of one login
+		{"login", func(*config.LoginThrottleConfig) {}, func(int) string { return "user" }, 2},
+		// and the ip lock after IPMaxFailures failures of any logins
+		{"ip", func(cfg *config.LoginThrottleConfig) { cfg.FreeAttempts = 100 }, func(i int) string { return string(rune('a' + i)) }, 10},
+	}
+	for _, c := range cases {
+		cfg := testThrottleConfig()
+		c.cfg(&cfg)
+		limiter := throttle.NewLimiter(throttle.NewMemoryStore(), cfg)
+
+		var checked atomic.Int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for i := range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				attempt, wait, err := limiter.Attempt(context.Background(), c.login(i), "10.0.0.1")
+				if err != nil || wait > 0 {
+					return
+				}
+				// a slow password check, every attempt fails
+				checked.Add(1)
+				time.Sleep(10 * time.Millisecond)
+				limiter.Fail(context.Background(), attempt)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if checked.Load() != c.allowed {
+			t.Errorf("%s: expected %d password checks, got %d", c.name, c.allowed, checked.Load())
+		}
+	}
+}
+
+func TestLimiterDeleteExpired(t *testing.T) {
+	cfg := testThrottleConfig()
+	cfg.Window = 20 * time.Millisecond
+	cfg.MaxFailures = 1
+	limiter := throttle.NewLimiter(throttle.NewMemoryStore(), cfg)
+
+	// the login is locked and stays, the ip is only forgotten
+	failLogin(t, limiter, "user", "10.0.0.1")
+	time.Sleep(2 * cfg.Window)
+
+	deleted, err := limiter.DeleteExpired(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatal("expected the ip to be deleted, got", deleted)
+	}
+	wait := attemptWait(t, limiter, "user", "10.0.0.2")
+	if wait <= 23*time.Hour {
+		t.Fatal("expected the login to stay locked, got", wait)
+	}
+}
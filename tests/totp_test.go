@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"strings"
+	"tefsi/internal/totp"
+	"testing"
+	"time"
+)
+
+// "12345678901234567890" from the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC lists 8 digit SHA1 codes, 6 digit codes are their last 6 digits
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := totp.Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected {
+			t.Errorf("expected code %s at %d, got %s", expected, unix, code)
+		}
+	}
+}
+
+func TestTOTPValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, err := totp.Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := totp.Validate(rfcSecret, code, now.Add(totp.Period))
+	if !ok || step != totp.Step(now) {
+		t.Fatal("expected code from the previous step to be accepted")
+	}
+	_, ok = totp.Validate(rfcSecret, code, now.Add(3*totp.Period))
+	if ok {
+		t.Fatal("expected old code to be rejected")
+	}
+	_, ok = totp.Validate(rfcSecret, "12345", now)
+	if ok {
+		t.Fatal("expected short code to be rejected")
+	}
+}
+
+func TestTOTPSecret(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := totp.Validate(secret, code, time.Now()); !ok {
+		t.Fatal("expected code of a generated secret to be valid")
+	}
+
+	uri := totp.URI("tefsi", "admin", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/tefsi:admin?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatal("unexpected otpauth uri", uri)
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"errors"
+	"tefsi/internal/domain"
+	"testing"
+)
+
+func TestValidateItemOptions(t *testing.T) {
+	valid := []domain.ItemOption{
+		{Name: "size", Values: []string{"S", "M", "L"}},
+		{Name: "color", Values: []string{"red"}},
+	}
+	if err := domain.ValidateItemOptions(valid); err != nil {
+		t.Fatal("expected valid options, got", err)
+	}
+	if err := domain.ValidateItemOptions(nil); err != nil {
+		t.Fatal("expected no options to be valid, got", err)
+	}
+
+	invalid := map[string][]domain.ItemOption{
+		"no name":         {{Name: "", Values: []string{"S"}}},
+		"no values":       {{Name: "size"}},
+		"empty value":     {{Name: "size", Values: []string{"S", ""}}},
+		"repeated value":  {{Name: "size", Values: []string{"S", "S"}}},
+		"repeated option": {{Name: "size", Values: []string{"S"}}, {Name: "size", Values: []string{"M"}}},
+	}
+	for name, options := range invalid {
+		if err := domain.ValidateItemOptions(options); !errors.Is(err, domain.ErrInvalidVariant) {
+			t.Errorf("%s: expected ErrInvalidVariant, got %v", name, err)
+		}
+	}
+}
+
+func TestVariantValidate(t *testing.T) {
+	options := []domain.ItemOption{
+		{Name: "size", Values: []string{"S", "M"}},
+		{Name: "color", Values: []string{"red", "blue"}},
+	}
+	price := 150
+	negative := -1
+
+	valid := domain.Variant{SKU: "TS-S-RED", Options: map[string]string{"size": "S", "color": "red"}, Price: &price}
+	if err := valid.Validate(options); err != nil {
+		t.Fatal("expected a valid variant, got", err)
+	}
+
+	invalid := map[string]domain.Variant{
+		"no sku":         {Options: map[string]string{"size": "S", "color": "red"}},
+		"missing option": {SKU: "TS-S", Options: map[string]string{"size": "S"}},
+		"unknown option": {SKU: "TS-S", Options: map[string]string{"size": "S", "color": "red", "fit": "slim"}},
+		"unknown value":  {SKU: "TS-XL", Options: map[string]string{"size": "XL", "color": "red"}},
+		"negative price": {SKU: "TS-S-RED", Options: map[string]string{"size": "S", "color": "red"}, Price: &negative},
+	}
+	for name, variant := range invalid {
+		if err := variant.Validate(options); !errors.Is(err, domain.ErrInvalidVariant) {
+			t.Errorf("%s: expected ErrInvalidVariant, got %v", name, err)
+		}
+	}
+
+	noOptions := domain.Variant{SKU: "TS", Options: map[string]string{}}
+	if err := noOptions.Validate(nil); !errors.Is(err, domain.ErrInvalidVariant) {
+		t.Error("expected items without options to have no variants, got", err)
+	}
+}